@@ -0,0 +1,51 @@
+package apierror
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Error 标准化的 API 错误，由全局错误处理器统一渲染为错误信封
+type Error struct {
+	Status  int         `json:"-"`
+	Code    string      `json:"code"`              // 机器可读的错误码，例如 not_found
+	Message string      `json:"message"`           // 面向调用方的错误描述
+	Details interface{} `json:"details,omitempty"` // 可选的附加信息
+}
+
+// Envelope 错误响应的外层结构: {"error": {...}}
+type Envelope struct {
+	Error Body `json:"error"`
+}
+
+// Body 错误信封的内容
+type Body struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// New 创建一个标准化 API 错误
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// WithDetails 附加详细信息并返回自身，便于链式调用
+func (e *Error) WithDetails(details interface{}) *Error {
+	e.Details = details
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// CodeForStatus 根据 HTTP 状态码生成默认错误码，例如 404 -> not_found
+func CodeForStatus(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
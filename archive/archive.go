@@ -0,0 +1,140 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go-agent-manager/db"
+	"go-agent-manager/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// batchSize 每个事务归档的设备数
+const batchSize = 100
+
+// ArchiveStale 将最后上报时间早于 cutoff 的设备及其绑定移入 archived_devices 表
+// 设备和绑定从热表中物理删除 (释放硬件 ID 的唯一索引)，返回归档的设备数
+func ArchiveStale(ctx context.Context, cutoff time.Time) (int, error) {
+	total := 0
+	for {
+		archived := 0
+		err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			// 在事务内加锁选取: 与归档并发的心跳 UPDATE 要么先提交 (不再满足 last_seen_at 条件)，
+			// 要么持有行锁而被跳过，留待下一轮，不会把刚上报的设备归档
+			var devices []models.Device
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("last_seen_at < ?", cutoff).
+				Order("last_seen_at ASC").
+				Limit(batchSize).
+				Find(&devices).Error
+			if err != nil {
+				return err
+			}
+			for _, d := range devices {
+				if err := archiveDevice(tx, d); err != nil {
+					return err
+				}
+			}
+			archived = len(devices)
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += archived
+		if archived < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// archiveDevice 在事务中归档单个设备
+// 已解绑 (软删除) 的绑定一并归档，保留 deleted_at，恢复时仍为已解绑状态，绑定历史不会因归档丢失
+func archiveDevice(tx *gorm.DB, d models.Device) error {
+	var bindings []models.UserDeviceBinding
+	if err := tx.Unscoped().Where("device_id = ?", d.ID).Find(&bindings).Error; err != nil {
+		return err
+	}
+	archived := models.ArchivedDevice{
+		ID:               d.ID,
+		UniqueHardwareID: d.UniqueHardwareID,
+		OS:               d.OS,
+		Hostname:         d.Hostname,
+		AgentVersion:     d.AgentVersion,
+		LastSeenAt:       d.LastSeenAt,
+		DecommissionedAt: d.DecommissionedAt,
+		Capabilities:     d.Capabilities,
+		BlockedAt:        d.BlockedAt,
+		BlockReason:      d.BlockReason,
+		Tags:             d.Tags,
+		DeviceCreatedAt:  d.CreatedAt,
+		Bindings:         bindings,
+	}
+	if err := tx.Create(&archived).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("device_id = ?", d.ID).Delete(&models.UserDeviceBinding{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id = ?", d.ID).Delete(&models.Device{}).Error
+}
+
+// Restore 设备以相同硬件 ID 重新注册时，将归档的设备及其绑定移回热表
+// report 为本次上报的设备信息 (覆盖 OS、Hostname 等字段)；没有对应归档记录时返回 (nil, nil)
+func Restore(ctx context.Context, hardwareID string, report models.Device) (*models.Device, error) {
+	var restored *models.Device
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var archived models.ArchivedDevice
+		err := tx.Where("unique_hardware_id = ?", hardwareID).First(&archived).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		device := models.Device{
+			ID:               archived.ID,
+			UniqueHardwareID: archived.UniqueHardwareID,
+			OS:               report.OS,
+			Hostname:         report.Hostname,
+			AgentVersion:     archived.AgentVersion,
+			LastSeenAt:       time.Now(),
+			DecommissionedAt: archived.DecommissionedAt,
+			Capabilities:     archived.Capabilities,
+			BlockedAt:        archived.BlockedAt,
+			BlockReason:      archived.BlockReason,
+			Tags:             archived.Tags,
+		}
+		device.CreatedAt = archived.DeviceCreatedAt
+		if report.Capabilities != nil {
+			device.Capabilities = report.Capabilities
+		}
+		if report.Tags != nil {
+			device.Tags = report.Tags
+		}
+		if report.AgentVersion != "" {
+			device.AgentVersion = report.AgentVersion
+		}
+		if err := tx.Create(&device).Error; err != nil {
+			return err
+		}
+		if len(archived.Bindings) > 0 {
+			if err := tx.Create(&archived.Bindings).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&archived).Error; err != nil {
+			return err
+		}
+		restored = &device
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
@@ -0,0 +1,124 @@
+package archive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-agent-manager/db"
+	"go-agent-manager/db/dbtest"
+	"go-agent-manager/models"
+
+	"gorm.io/gorm/clause"
+)
+
+func createDevice(t *testing.T, hardwareID string, lastSeen time.Time) models.Device {
+	t.Helper()
+	device := models.Device{UniqueHardwareID: hardwareID, Hostname: hardwareID, LastSeenAt: lastSeen}
+	if err := db.DB.Create(&device).Error; err != nil {
+		t.Fatalf("create device %s: %v", hardwareID, err)
+	}
+	return device
+}
+
+func createBinding(t *testing.T, userID, deviceID string) models.UserDeviceBinding {
+	t.Helper()
+	binding := models.UserDeviceBinding{KeycloakUserID: userID, DeviceID: deviceID, Status: models.BindingStatusActive, BoundAt: time.Now()}
+	if err := db.DB.Create(&binding).Error; err != nil {
+		t.Fatalf("create binding: %v", err)
+	}
+	return binding
+}
+
+func TestArchiveStaleKeepsUnboundHistory(t *testing.T) {
+	dbtest.Open(t, "archive_test")
+	ctx := context.Background()
+	now := time.Now()
+
+	stale := createDevice(t, "hw-stale", now.AddDate(0, -6, 0))
+	fresh := createDevice(t, "hw-fresh", now)
+	live := createBinding(t, "user-live", stale.ID)
+	unbound := createBinding(t, "user-unbound", stale.ID)
+	if err := db.DB.Delete(&unbound).Error; err != nil {
+		t.Fatalf("unbind: %v", err)
+	}
+
+	n, err := ArchiveStale(ctx, now.AddDate(0, -1, 0))
+	if err != nil {
+		t.Fatalf("ArchiveStale: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("archived %d devices, want 1", n)
+	}
+	if err := db.DB.First(&models.Device{}, "id = ?", fresh.ID).Error; err != nil {
+		t.Errorf("fresh device was archived: %v", err)
+	}
+	var remaining int64
+	db.DB.Unscoped().Model(&models.UserDeviceBinding{}).Where("device_id = ?", stale.ID).Count(&remaining)
+	if remaining != 0 {
+		t.Errorf("%d bindings of the archived device left in the hot table", remaining)
+	}
+
+	var archived models.ArchivedDevice
+	if err := db.DB.First(&archived, "id = ?", stale.ID).Error; err != nil {
+		t.Fatalf("load archived device: %v", err)
+	}
+	if len(archived.Bindings) != 2 {
+		t.Fatalf("archived %d bindings, want the live and the unbound one", len(archived.Bindings))
+	}
+
+	// 重新上报后绑定历史原样恢复: 已解绑的仍为已解绑
+	if _, err := Restore(ctx, stale.UniqueHardwareID, models.Device{Hostname: "hw-stale"}); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	var restoredLive, restoredUnbound models.UserDeviceBinding
+	if err := db.DB.First(&restoredLive, "id = ?", live.ID).Error; err != nil {
+		t.Errorf("live binding not restored: %v", err)
+	}
+	if err := db.DB.Unscoped().First(&restoredUnbound, "id = ?", unbound.ID).Error; err != nil {
+		t.Fatalf("unbound binding not restored: %v", err)
+	}
+	if !restoredUnbound.DeletedAt.Valid {
+		t.Error("unbound binding was restored as a live binding")
+	}
+}
+
+func TestArchiveStaleSkipsDevicesBeingUpdated(t *testing.T) {
+	dbtest.Open(t, "archive_test")
+	ctx := context.Background()
+	now := time.Now()
+	busy := createDevice(t, "hw-busy", now.AddDate(0, -6, 0))
+	idle := createDevice(t, "hw-idle", now.AddDate(0, -6, 0))
+
+	// 模拟正在进行的心跳: 另一个事务持有设备行锁
+	heartbeat := db.DB.Begin()
+	defer heartbeat.Rollback()
+	if err := heartbeat.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Device{}, "id = ?", busy.ID).Error; err != nil {
+		t.Fatalf("lock device: %v", err)
+	}
+
+	n, err := ArchiveStale(ctx, now.AddDate(0, -1, 0))
+	if err != nil {
+		t.Fatalf("ArchiveStale: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("archived %d devices, want only the idle one", n)
+	}
+	if err := db.DB.First(&models.Device{}, "id = ?", idle.ID).Error; err == nil {
+		t.Error("idle stale device was not archived")
+	}
+
+	// 心跳提交后设备已不满足归档条件
+	if err := heartbeat.Model(&models.Device{}).Where("id = ?", busy.ID).Update("last_seen_at", now).Error; err != nil {
+		t.Fatalf("heartbeat update: %v", err)
+	}
+	if err := heartbeat.Commit().Error; err != nil {
+		t.Fatalf("commit heartbeat: %v", err)
+	}
+	if n, err := ArchiveStale(ctx, now.AddDate(0, -1, 0)); err != nil || n != 0 {
+		t.Errorf("ArchiveStale after heartbeat = %d, %v; want 0", n, err)
+	}
+	if err := db.DB.First(&models.Device{}, "id = ?", busy.ID).Error; err != nil {
+		t.Errorf("device that just reported was archived: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"context"
+
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/logger"
+	"go-agent-manager/models"
+)
+
+// Entry 一条待写入的审计记录
+type Entry struct {
+	ActorID      string                 // 操作者 Keycloak 用户 ID，匿名请求为空
+	Action       string                 // 动作，例如 binding.extend, request.panic
+	ResourceType string                 // 资源类型，例如 device, binding
+	ResourceID   string                 // 资源 ID
+	RequestID    string                 // 关联的请求 ID
+	RemoteIP     string                 // 客户端 IP
+	Details      map[string]interface{} // 附加信息
+}
+
+// Record 写入一条审计记录
+// 审计写入失败不应影响业务请求，因此这里只记录日志而不返回错误
+// 只读模式下不写库，仅输出日志
+func Record(ctx context.Context, e Entry) {
+	if config.AppConfig.ReadOnlyMode {
+		logger.Log.Info("audit event (read-only mode, not persisted)",
+			"action", e.Action, "resource_type", e.ResourceType, "resource_id", e.ResourceID,
+			"actor_id", e.ActorID, "request_id", e.RequestID)
+		return
+	}
+	entry := models.AuditLog{
+		ActorID:      e.ActorID,
+		Action:       e.Action,
+		ResourceType: e.ResourceType,
+		ResourceID:   e.ResourceID,
+		RequestID:    e.RequestID,
+		RemoteIP:     e.RemoteIP,
+		Details:      e.Details,
+	}
+	if err := db.DB.WithContext(ctx).Create(&entry).Error; err != nil {
+		logger.Log.Error("failed to write audit log",
+			"action", e.Action, "resource_type", e.ResourceType, "resource_id", e.ResourceID,
+			"request_id", e.RequestID, "error", err)
+	}
+}
@@ -0,0 +1,158 @@
+// Package bindings 定义用户设备绑定状态的状态机
+// 所有修改绑定状态的代码 (创建、状态接口、审批、解绑、过期、用户停用级联) 都必须经由本包，
+// 以保证同一套合法转换规则在各处生效
+package bindings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-agent-manager/models"
+
+	"gorm.io/gorm"
+)
+
+// StatusNew 表示绑定尚未创建，作为创建时状态转换的起点
+const StatusNew = ""
+
+// transitions 合法的状态转换: 起始状态 -> 可转换到的目标状态
+//
+//	(新建)           -> active, pending_approval
+//	pending_approval -> active (审批通过), rejected (审批拒绝)
+//	active           -> inactive (解绑、过期、用户停用)
+//	inactive         -> active (重新激活)
+//	rejected         -> (终态)
+var transitions = map[string][]string{
+	StatusNew:                           {models.BindingStatusActive, models.BindingStatusPendingApproval},
+	models.BindingStatusPendingApproval: {models.BindingStatusActive, models.BindingStatusRejected},
+	models.BindingStatusActive:          {models.BindingStatusInactive},
+	models.BindingStatusInactive:        {models.BindingStatusActive},
+	models.BindingStatusRejected:        nil,
+}
+
+// ErrIllegalTransition 状态转换不被允许
+var ErrIllegalTransition = errors.New("illegal binding status transition")
+
+// ErrConcurrentUpdate 绑定状态在读取之后已被其他请求修改
+var ErrConcurrentUpdate = errors.New("binding status was changed concurrently")
+
+// TransitionError 描述一次被拒绝的状态转换，errors.Is(err, ErrIllegalTransition) 为 true
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	from := e.From
+	if from == StatusNew {
+		from = "(new)"
+	}
+	return fmt.Sprintf("binding status cannot change from %s to %s", from, e.To)
+}
+
+func (e *TransitionError) Unwrap() error { return ErrIllegalTransition }
+
+// IsValidStatus 判断是否为已知的绑定状态
+func IsValidStatus(status string) bool {
+	if status == StatusNew {
+		return false
+	}
+	_, ok := transitions[status]
+	return ok
+}
+
+// CanTransition 判断 from -> to 是否为合法转换
+func CanTransition(from, to string) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Check 校验状态转换，不合法时返回 *TransitionError
+func Check(from, to string) error {
+	if !CanTransition(from, to) {
+		return &TransitionError{From: from, To: to}
+	}
+	return nil
+}
+
+// statusFields 目标状态附带更新的时间字段
+func statusFields(to string, now time.Time) map[string]interface{} {
+	fields := map[string]interface{}{"status": to}
+	switch to {
+	case models.BindingStatusInactive:
+		fields["unbound_at"] = now
+	case models.BindingStatusActive:
+		fields["unbound_at"] = nil
+	}
+	return fields
+}
+
+// Transition 将单个绑定从当前状态转换到 to，并同步更新 binding
+// 以 "status = 当前状态" 作为更新条件，若期间被其他请求修改则返回 ErrConcurrentUpdate
+func Transition(ctx context.Context, tx *gorm.DB, binding *models.UserDeviceBinding, to string) error {
+	if err := Check(binding.Status, to); err != nil {
+		return err
+	}
+	now := time.Now()
+	fields := statusFields(to, now)
+	result := tx.WithContext(ctx).Model(&models.UserDeviceBinding{}).
+		Where("id = ? AND status = ?", binding.ID, binding.Status).
+		Updates(fields)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConcurrentUpdate
+	}
+	binding.Status = to
+	switch to {
+	case models.BindingStatusInactive:
+		binding.UnboundAt = &now
+	case models.BindingStatusActive:
+		binding.UnboundAt = nil
+	}
+	return nil
+}
+
+// TransitionAll 将 query 匹配的所有处于 from 状态的绑定批量转换到 to，返回受影响的行数
+// query 只需包含额外的筛选条件 (如按用户)，状态条件由本函数添加
+func TransitionAll(ctx context.Context, query *gorm.DB, from, to string) (int64, error) {
+	if err := Check(from, to); err != nil {
+		return 0, err
+	}
+	result := query.WithContext(ctx).Model(&models.UserDeviceBinding{}).
+		Where("status = ?", from).
+		Updates(statusFields(to, time.Now()))
+	return result.RowsAffected, result.Error
+}
+
+// unbindTransitions 解绑 (软删除) 前的状态转换: 生效中的绑定先转为 inactive，待审批的先转为 rejected；
+// inactive 和 rejected 的绑定已不再生效，直接删除
+var unbindTransitions = map[string]string{
+	models.BindingStatusActive:          models.BindingStatusInactive,
+	models.BindingStatusPendingApproval: models.BindingStatusRejected,
+}
+
+// Unbind 解绑并软删除绑定: 先按 unbindTransitions 经 Transition 转换状态 (记录 UnboundAt)，再软删除，
+// 删除后的记录保留最终状态和解绑时间供审计。应在事务中调用；绑定已被删除或状态被并发修改时返回 ErrConcurrentUpdate
+func Unbind(ctx context.Context, tx *gorm.DB, binding *models.UserDeviceBinding) error {
+	if to, ok := unbindTransitions[binding.Status]; ok {
+		if err := Transition(ctx, tx, binding, to); err != nil {
+			return err
+		}
+	}
+	result := tx.WithContext(ctx).Delete(&models.UserDeviceBinding{}, "id = ?", binding.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConcurrentUpdate
+	}
+	return nil
+}
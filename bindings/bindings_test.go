@@ -0,0 +1,116 @@
+package bindings
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go-agent-manager/models"
+)
+
+// allStatuses 转换的全部起点和终点，包括表示新建的 StatusNew 和一个未知状态
+var allStatuses = []string{
+	StatusNew,
+	models.BindingStatusPendingApproval,
+	models.BindingStatusActive,
+	models.BindingStatusInactive,
+	models.BindingStatusRejected,
+	"unknown",
+}
+
+func TestCheckAllPairs(t *testing.T) {
+	// 期望的合法转换，独立于 transitions 书写，两者不一致时测试失败
+	allowed := map[[2]string]bool{
+		{StatusNew, models.BindingStatusActive}:                             true,
+		{StatusNew, models.BindingStatusPendingApproval}:                    true,
+		{models.BindingStatusPendingApproval, models.BindingStatusActive}:   true,
+		{models.BindingStatusPendingApproval, models.BindingStatusRejected}: true,
+		{models.BindingStatusActive, models.BindingStatusInactive}:          true,
+		{models.BindingStatusInactive, models.BindingStatusActive}:          true,
+	}
+
+	for _, from := range allStatuses {
+		for _, to := range allStatuses {
+			want := allowed[[2]string{from, to}]
+			if got := CanTransition(from, to); got != want {
+				t.Errorf("CanTransition(%q, %q) = %v, want %v", from, to, got, want)
+			}
+
+			err := Check(from, to)
+			if want {
+				if err != nil {
+					t.Errorf("Check(%q, %q) = %v, want nil", from, to, err)
+				}
+				continue
+			}
+			if !errors.Is(err, ErrIllegalTransition) {
+				t.Errorf("Check(%q, %q) = %v, want ErrIllegalTransition", from, to, err)
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) || te.From != from || te.To != to {
+				t.Errorf("Check(%q, %q) = %#v, want *TransitionError{%q, %q}", from, to, err, from, to)
+			}
+		}
+	}
+
+	// transitions 中不能有上表之外的转换
+	for from, targets := range transitions {
+		for _, to := range targets {
+			if !allowed[[2]string{from, to}] {
+				t.Errorf("transitions allows unexpected %q -> %q", from, to)
+			}
+		}
+	}
+}
+
+func TestTransitionErrorMessage(t *testing.T) {
+	tests := []struct {
+		from, to, want string
+	}{
+		{StatusNew, models.BindingStatusInactive, "binding status cannot change from (new) to inactive"},
+		{models.BindingStatusRejected, models.BindingStatusActive, "binding status cannot change from rejected to active"},
+	}
+	for _, tt := range tests {
+		if got := Check(tt.from, tt.to).Error(); got != tt.want {
+			t.Errorf("Check(%q, %q).Error() = %q, want %q", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestIsValidStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{StatusNew, false},
+		{models.BindingStatusPendingApproval, true},
+		{models.BindingStatusActive, true},
+		{models.BindingStatusInactive, true},
+		{models.BindingStatusRejected, true},
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		if got := IsValidStatus(tt.status); got != tt.want {
+			t.Errorf("IsValidStatus(%q) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestStatusFields(t *testing.T) {
+	now := time.Now()
+
+	inactive := statusFields(models.BindingStatusInactive, now)
+	if inactive["status"] != models.BindingStatusInactive || inactive["unbound_at"] != now {
+		t.Errorf("statusFields(inactive) = %v, want status and unbound_at set", inactive)
+	}
+
+	active := statusFields(models.BindingStatusActive, now)
+	if v, ok := active["unbound_at"]; !ok || v != nil {
+		t.Errorf("statusFields(active) = %v, want unbound_at cleared", active)
+	}
+
+	rejected := statusFields(models.BindingStatusRejected, now)
+	if _, ok := rejected["unbound_at"]; ok {
+		t.Errorf("statusFields(rejected) = %v, want unbound_at untouched", rejected)
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// redactedValue 替代敏感值的占位符
+const redactedValue = "****"
+
+// Redacted 返回当前生效配置的扁平视图 (键为环境变量名)，敏感值已脱敏
+// 字段通过 redact 标签声明脱敏方式:
+//   - redact:"secret" 非空时整体替换为 ****
+//   - redact:"url"    只替换连接串中的密码部分
+func Redacted() map[string]interface{} {
+	out := make(map[string]interface{})
+	collectRedacted(reflect.ValueOf(AppConfig), out)
+	return out
+}
+
+// collectRedacted 遍历结构体字段，嵌套结构体 (squash) 的字段平铺到同一层
+func collectRedacted(v reflect.Value, out map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Time{}) {
+			collectRedacted(value, out)
+			continue
+		}
+		key := field.Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+		switch field.Tag.Get("redact") {
+		case "secret":
+			if value.String() != "" {
+				out[key] = redactedValue
+			} else {
+				out[key] = ""
+			}
+		case "url":
+			out[key] = redactURL(value.String())
+		default:
+			if d, ok := value.Interface().(time.Duration); ok {
+				out[key] = d.String()
+			} else {
+				out[key] = value.Interface()
+			}
+		}
+	}
+}
+
+// dsnPassword 匹配 key=value 形式连接串 (例如 "host=db user=app password=secret") 中的密码
+var dsnPassword = regexp.MustCompile(`(?i)(password=)('[^']*'|\S+)`)
+
+// redactURL 隐藏连接串中的密码，支持 URL 和 key=value 两种形式；无法解析时整体隐藏，避免泄露
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redactedValue
+	}
+	if u.Scheme == "" {
+		return dsnPassword.ReplaceAllString(raw, "${1}"+redactedValue)
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), redactedValue)
+	}
+	q := u.Query()
+	if q.Has("password") {
+		q.Set("password", redactedValue)
+		u.RawQuery = q.Encode()
+	}
+	// 占位符中的 * 会被转义为 %2A，还原以便阅读
+	return strings.ReplaceAll(u.String(), url.QueryEscape(redactedValue), redactedValue)
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"go-agent-manager/models"
+)
+
+// placeholderPrefix 示例配置 (.env 和默认值) 中需要替换的占位值前缀，例如 YOUR_ADMIN_CLI_SECRET
+const placeholderPrefix = "YOUR_"
+
+// Validate 检查配置是否可用: 必填项非空且不是占位值、数据库和 Keycloak 地址可以解析、枚举值合法
+// 一次性返回所有问题，而不是遇到第一个就停止，便于部署时一次修正
+func (c *Config) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	required := []struct {
+		key, value string
+	}{
+		{"SERVER_PORT", c.ServerPort},
+		{"DATABASE_URL", c.DatabaseURL},
+		{"KEYCLOAK_AUTH_SERVER_URL", c.Keycloak.AuthServerURL},
+		{"KEYCLOAK_REALM", c.Keycloak.Realm},
+		{"KEYCLOAK_ADMIN_CLIENT_ID", c.Keycloak.AdminClientID},
+		{"KEYCLOAK_ADMIN_CLIENT_SECRET", c.Keycloak.AdminClientSecret},
+		{"KEYCLOAK_FRONTEND_CLIENT_ID", c.Keycloak.FrontendClientID},
+	}
+	for _, r := range required {
+		switch {
+		case strings.TrimSpace(r.value) == "":
+			addf("%s is required", r.key)
+		case strings.HasPrefix(r.value, placeholderPrefix):
+			addf("%s is still set to the placeholder value %q", r.key, r.value)
+		}
+	}
+
+	if c.DatabaseURL != "" {
+		if err := validateDatabaseURL(c.DatabaseURL); err != nil {
+			addf("DATABASE_URL is invalid: %v", err)
+		}
+	}
+	if c.DatabaseReplicaURL != "" {
+		if err := validateDatabaseURL(c.DatabaseReplicaURL); err != nil {
+			addf("DATABASE_REPLICA_URL is invalid: %v", err)
+		}
+	}
+	if c.Keycloak.AuthServerURL != "" {
+		if u, err := url.Parse(c.Keycloak.AuthServerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			addf("KEYCLOAK_AUTH_SERVER_URL %q must be an absolute http(s) URL", c.Keycloak.AuthServerURL)
+		}
+	}
+
+	if c.DatabasePool.MaxOpenConns < 0 || c.DatabasePool.MaxIdleConns < 0 || c.DatabasePool.ConnMaxLifetime < 0 {
+		addf("DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and DB_CONN_MAX_LIFETIME must not be negative")
+	}
+
+	if c.DatabaseConnect.RetryInterval <= 0 {
+		addf("DB_CONNECT_RETRY_INTERVAL must be positive")
+	}
+
+	switch c.DefaultBindingStatus {
+	case models.BindingStatusActive, models.BindingStatusPendingApproval:
+	default:
+		addf("DEFAULT_BINDING_STATUS %q must be %q or %q",
+			c.DefaultBindingStatus, models.BindingStatusActive, models.BindingStatusPendingApproval)
+	}
+	switch c.LogLevel {
+	case LogLevelSilent, LogLevelError, LogLevelWarn, LogLevelInfo:
+	default:
+		addf("LOG_LEVEL %q must be one of %q, %q, %q or %q",
+			c.LogLevel, LogLevelSilent, LogLevelError, LogLevelWarn, LogLevelInfo)
+	}
+	switch c.Keycloak.TokenValidationMode {
+	case TokenValidationIntrospect, TokenValidationJWKS:
+	default:
+		addf("TOKEN_VALIDATION_MODE %q must be %q or %q",
+			c.Keycloak.TokenValidationMode, TokenValidationIntrospect, TokenValidationJWKS)
+	}
+	switch c.AgentOfflinePolicy {
+	case models.OfflinePolicyProxyAll, models.OfflinePolicyBlockAll, models.OfflinePolicyLastKnown:
+	default:
+		addf("AGENT_OFFLINE_POLICY %q must be %q, %q or %q", c.AgentOfflinePolicy,
+			models.OfflinePolicyProxyAll, models.OfflinePolicyBlockAll, models.OfflinePolicyLastKnown)
+	}
+	for _, entry := range strings.Split(c.TrustedProxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			addf("TRUSTED_PROXIES entry %q must be an IP address or CIDR", entry)
+		}
+	}
+	if c.AgentRateLimit < 0 {
+		addf("AGENT_RATE_LIMIT must not be negative (use 0 to disable rate limiting)")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d problem(s):\n  - %s", len(problems), strings.Join(problems, "\n  - "))
+}
+
+// validateDatabaseURL 接受 postgres:// URL 或 "host=... dbname=..." 形式的 DSN
+// 错误信息中不包含连接串本身，避免泄露密码
+func validateDatabaseURL(dsn string) error {
+	if !strings.Contains(dsn, "://") {
+		if !strings.Contains(dsn, "=") {
+			return errors.New("expected a postgres:// URL or a key=value connection string")
+		}
+		return nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return errors.New("cannot parse URL")
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return fmt.Errorf("unsupported scheme %q (expected postgres or postgresql)", u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+	return nil
+}
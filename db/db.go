@@ -1,7 +1,9 @@
 package db
 
 import (
+	"fmt"
 	"log"
+	"time"
 
 	"go-agent-manager/config"
 	"go-agent-manager/models"
@@ -9,31 +11,171 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
 )
 
 var DB *gorm.DB
 
+// maxConnectRetryDelay 连接重试的最长等待间隔
+const maxConnectRetryDelay = 30 * time.Second
+
 // InitDB 初始化数据库连接并自动迁移模型
 func InitDB() {
 	var err error
-	DB, err = gorm.Open(postgres.Open(config.AppConfig.DatabaseURL), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info), // 在控制台打印 SQL 日志
-	})
+	DB, err = connectWithRetry()
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
 	log.Println("Database connection established.")
 
+	// 连接池参数 (DB_MAX_OPEN_CONNS / DB_MAX_IDLE_CONNS / DB_CONN_MAX_LIFETIME)
+	pool := config.AppConfig.DatabasePool
+	sqlDB, err := DB.DB()
+	if err != nil {
+		log.Fatalf("Failed to access database connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
+	// 配置了只读副本时注册 dbresolver：SELECT 走副本，写操作走主库
+	// 副本使用同样的连接池参数 (连接池各自独立)
+	if replicaURL := config.AppConfig.DatabaseReplicaURL; replicaURL != "" {
+		err = DB.Use(dbresolver.Register(dbresolver.Config{
+			Replicas: []gorm.Dialector{postgres.Open(replicaURL)},
+			Policy:   dbresolver.RandomPolicy{},
+		}).
+			SetMaxOpenConns(pool.MaxOpenConns).
+			SetMaxIdleConns(pool.MaxIdleConns).
+			SetConnMaxLifetime(pool.ConnMaxLifetime))
+		if err != nil {
+			log.Fatalf("Failed to register database read replica: %v", err)
+		}
+		log.Println("Database read replica enabled; read queries will be routed to the replica.")
+	}
+
 	// 自动迁移数据库模式
 	err = DB.AutoMigrate(
 		&models.Device{},
 		&models.UserDeviceBinding{},
 		&models.Rule{},
+		&models.AuditLog{},
+		&models.RuleSnapshot{},
+		&models.AgentKey{},
+		&models.RuleApplication{},
+		&models.ArchivedDevice{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to auto migrate database: %v", err)
 	}
 
+	if err := ensureRuleNameIndex(); err != nil {
+		log.Fatalf("Failed to create case-insensitive rule name index: %v", err)
+	}
+	if err := dropLegacyBindingIndex(); err != nil {
+		log.Fatalf("Failed to drop legacy binding unique index: %v", err)
+	}
+	if err := dropLegacySnapshotIndex(); err != nil {
+		log.Fatalf("Failed to drop legacy rule snapshot unique index: %v", err)
+	}
+
 	log.Println("Database auto-migration completed.")
 }
+
+// ensureRuleNameIndex 创建 rules(lower(name)) 唯一索引，使规则名称不区分大小写唯一
+// 已有仅大小写不同的重名规则时无法创建索引: 逐组列出冲突并返回错误 (启动失败)，需人工改名后重启；
+// 设置 ALLOW_RULE_NAME_COLLISIONS=true 时只记录警告并在没有该索引的情况下继续启动
+func ensureRuleNameIndex() error {
+	var collisions []struct {
+		Key   string
+		Names string
+	}
+	err := DB.Raw(`SELECT lower(name) AS key, string_agg(name || ' (' || id || ')', ', ' ORDER BY created_at) AS names
+		FROM rules GROUP BY lower(name) HAVING COUNT(*) > 1`).Scan(&collisions).Error
+	if err != nil {
+		return err
+	}
+	if len(collisions) > 0 {
+		for _, c := range collisions {
+			log.Printf("WARNING: rule names differ only by case and must be renamed: %s", c.Names)
+		}
+		if !config.AppConfig.AllowRuleNameCollisions {
+			return fmt.Errorf("%d case-insensitive rule name collision(s) found; rename the rules listed above "+
+				"or set ALLOW_RULE_NAME_COLLISIONS=true to start without index idx_rules_name_lower", len(collisions))
+		}
+		log.Printf("WARNING: %d case-insensitive rule name collision(s) found; index idx_rules_name_lower was not created", len(collisions))
+		return nil
+	}
+	return DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_rules_name_lower ON rules (lower(name))").Error
+}
+
+// dropLegacyBindingIndex 删除旧的 (keycloak_user_id, device_id) 唯一索引
+// 旧索引同样覆盖软删除的绑定，导致解绑后无法重新绑定；AutoMigrate 已创建只覆盖 deleted_at IS NULL 的 idx_user_device_binding_live
+func dropLegacyBindingIndex() error {
+	return DB.Exec("DROP INDEX IF EXISTS idx_user_device_binding").Error
+}
+
+// connectWithRetry 连接数据库，失败时按指数退避重试 (DB_CONNECT_MAX_ATTEMPTS / DB_CONNECT_RETRY_INTERVAL)
+// 容器编排中应用可能先于数据库启动，短暂的连接失败不应直接退出
+func connectWithRetry() (*gorm.DB, error) {
+	attempts := config.AppConfig.DatabaseConnect.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := config.AppConfig.DatabaseConnect.RetryInterval
+
+	for attempt := 1; ; attempt++ {
+		conn, err := gorm.Open(postgres.Open(config.AppConfig.DatabaseURL), &gorm.Config{
+			Logger: logger.Default.LogMode(gormLogLevel(config.AppConfig.LogLevel)), // LOG_LEVEL=info 时打印每条 SQL
+			// 将唯一约束冲突等驱动错误转换为 gorm.ErrDuplicatedKey 等通用错误，便于映射为 409
+			TranslateError: true,
+		})
+		if err == nil {
+			return conn, nil
+		}
+		if attempt >= attempts {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		log.Printf("Database connection attempt %d/%d failed: %v. Retrying in %s...", attempt, attempts, err, delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxConnectRetryDelay {
+			delay = maxConnectRetryDelay
+		}
+	}
+}
+
+// gormLogLevel 将 LOG_LEVEL 映射为 GORM 日志级别 (warn 包括慢查询)
+func gormLogLevel(level string) logger.LogLevel {
+	switch level {
+	case config.LogLevelSilent:
+		return logger.Silent
+	case config.LogLevelError:
+		return logger.Error
+	case config.LogLevelInfo:
+		return logger.Info
+	default:
+		return logger.Warn
+	}
+}
+
+// Close 关闭主库连接池，在服务优雅退出时调用
+func Close() error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Primary 返回强制使用主库的会话
+// 用于"先读后写"的场景 (例如更新前的查询)，避免读到副本上尚未同步的数据
+func Primary() *gorm.DB {
+	return DB.Clauses(dbresolver.Write)
+}
+
+// dropLegacySnapshotIndex 删除旧的 rule_snapshots(etag) 唯一索引
+// 快照改为按能力分组保存后，同一 ETag 可以属于多个分组；AutoMigrate 已创建 (etag, capability_key) 唯一索引 idx_rule_snapshots_etag_key
+func dropLegacySnapshotIndex() error {
+	return DB.Exec("DROP INDEX IF EXISTS idx_rule_snapshots_etag").Error
+}
@@ -0,0 +1,92 @@
+// Package dbtest 为需要真实 Postgres 的测试准备数据库
+// 设置 TEST_DATABASE_URL (与 DATABASE_URL 格式相同) 后运行；未设置时相关测试跳过。
+// 每个调用方使用独立的 schema，不同包的测试可以并行运行而互不干扰
+package dbtest
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// EnvDatabaseURL 测试数据库连接串所在的环境变量
+const EnvDatabaseURL = "TEST_DATABASE_URL"
+
+var schemaName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Open 重建名为 schema 的空 schema，在其中执行与启动时相同的迁移 (db.InitDB)，并将 db.DB 指向它
+// 测试结束时关闭连接并删除 schema；未设置 TEST_DATABASE_URL 时调用 t.Skip
+func Open(t testing.TB, schema string) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv(EnvDatabaseURL)
+	if dsn == "" {
+		t.Skipf("%s is not set; skipping test that needs Postgres", EnvDatabaseURL)
+	}
+	if !schemaName.MatchString(schema) {
+		t.Fatalf("invalid schema name %q", schema)
+	}
+
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+	exec := func(sql string) {
+		if err := admin.Exec(sql).Error; err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+	}
+	exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+	exec(fmt.Sprintf("CREATE SCHEMA %s", schema))
+
+	prev := config.AppConfig
+	config.AppConfig.DatabaseURL = withSearchPath(dsn, schema)
+	config.AppConfig.DatabaseReplicaURL = ""
+	config.AppConfig.DatabaseConnect.MaxAttempts = 1
+	config.AppConfig.LogLevel = config.LogLevelError
+	db.InitDB()
+
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("close test database: %v", err)
+		}
+		exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+		config.AppConfig = prev
+	})
+	return db.DB
+}
+
+// Truncate 清空给定的表，用于同一个包内的测试之间互相隔离
+func Truncate(t testing.TB, tables ...string) {
+	t.Helper()
+	if err := db.DB.Exec("TRUNCATE " + strings.Join(tables, ", ") + " CASCADE").Error; err != nil {
+		t.Fatalf("truncate %v: %v", tables, err)
+	}
+}
+
+// withSearchPath 让连接默认使用指定 schema，支持 postgres:// URL 和 key=value 两种写法
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return dsn
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,50 @@
+package db_test
+
+import (
+	"strings"
+	"testing"
+
+	"go-agent-manager/db/dbtest"
+
+	"gorm.io/gorm"
+)
+
+// TestIndexesUsed 对常用过滤条件执行 EXPLAIN，确认查询走 models 中声明的索引
+// 测试表很小，规划器默认会选择顺序扫描，因此在事务内关闭 enable_seqscan
+func TestIndexesUsed(t *testing.T) {
+	conn := dbtest.Open(t, "db_indexes_test")
+
+	tests := []struct {
+		index string
+		query string
+	}{
+		{"idx_devices_os", "SELECT * FROM devices WHERE os = 'linux'"},
+		{"idx_devices_hostname", "SELECT * FROM devices WHERE hostname = 'host-1'"},
+		{"idx_devices_agent_version", "SELECT * FROM devices WHERE agent_version = '1.2.3'"},
+		{"idx_devices_last_seen_at", "SELECT * FROM devices WHERE last_seen_at < now() - interval '5 minutes'"},
+		{"idx_devices_capabilities", `SELECT * FROM devices WHERE capabilities @> '["tcp-proxy"]'`},
+		{"idx_devices_tags", `SELECT * FROM devices WHERE tags @> '{"dept": "finance"}'`},
+		{"idx_bindings_device_id", "SELECT * FROM user_device_bindings WHERE device_id = '0f3c2a4e-5b6d-4e7f-8a9b-0c1d2e3f4a5b'"},
+		{"idx_bindings_status", "SELECT * FROM user_device_bindings WHERE status = 'active'"},
+		{"idx_user_device_binding_live", "SELECT * FROM user_device_bindings WHERE keycloak_user_id = 'user-1' AND deleted_at IS NULL"},
+		{"idx_rules_type", "SELECT * FROM rules WHERE type = 'http-proxy'"},
+		{"idx_rules_priority", "SELECT * FROM rules WHERE priority < 10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.index, func(t *testing.T) {
+			var plan []string
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec("SET LOCAL enable_seqscan = off").Error; err != nil {
+					return err
+				}
+				return tx.Raw("EXPLAIN " + tt.query).Scan(&plan).Error
+			})
+			if err != nil {
+				t.Fatalf("EXPLAIN %s: %v", tt.query, err)
+			}
+			if joined := strings.Join(plan, "\n"); !strings.Contains(joined, tt.index) {
+				t.Errorf("query %q does not use %s:\n%s", tt.query, tt.index, joined)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Package events 进程内的设备状态事件发布/订阅
+// 心跳由接收它的实例发布；离线检测基于数据库中的 LastSeenAt，每个实例各自检测并通知自己的订阅者
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/jobs"
+	"go-agent-manager/models"
+)
+
+// 设备事件类型
+const (
+	DeviceHeartbeat = "heartbeat" // 收到设备心跳
+	DeviceOnline    = "online"    // 设备由离线变为在线 (或首次注册)
+	DeviceOffline   = "offline"   // 设备超过 DEVICE_OFFLINE_THRESHOLD 未上报
+)
+
+// DeviceEvent 推送给订阅者的设备事件
+type DeviceEvent struct {
+	Type       string    `json:"type"`
+	DeviceID   string    `json:"device_id"`
+	Hostname   string    `json:"hostname"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// subscriberBuffer 每个订阅者的事件缓冲，消费过慢时新事件被丢弃而不是阻塞发布者
+const subscriberBuffer = 64
+
+var (
+	mu          sync.RWMutex
+	subscribers = make(map[chan DeviceEvent]struct{})
+)
+
+// SubscribeDevices 订阅设备事件；调用方必须执行返回的 unsubscribe
+func SubscribeDevices() (updates <-chan DeviceEvent, unsubscribe func()) {
+	ch := make(chan DeviceEvent, subscriberBuffer)
+	mu.Lock()
+	subscribers[ch] = struct{}{}
+	mu.Unlock()
+	return ch, func() {
+		mu.Lock()
+		delete(subscribers, ch)
+		mu.Unlock()
+	}
+}
+
+// PublishDevice 向所有订阅者广播事件，不会阻塞
+func PublishDevice(ev DeviceEvent) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for ch := range subscribers {
+		select {
+		case ch <- ev:
+		default: // 订阅者缓冲已满，丢弃本条事件
+		}
+	}
+}
+
+// hasSubscribers 是否有订阅者，没有时离线检测跳过查询
+func hasSubscribers() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(subscribers) > 0
+}
+
+// OfflineDetectorJob 离线检测任务在 jobs 健康检查中的名称
+const OfflineDetectorJob = "device-offline-events"
+
+// offlineCheckInterval 离线检测间隔，决定 offline 事件的最大延迟
+const offlineCheckInterval = 15 * time.Second
+
+// StartOfflineDetector 定期找出在上次检测之后越过离线阈值的设备并发布 offline 事件
+// 只读取数据库，只读模式下同样运行
+func StartOfflineDetector(ctx context.Context) {
+	lastCheck := time.Now()
+	jobs.Run(ctx, OfflineDetectorJob, offlineCheckInterval, func(ctx context.Context) error {
+		now := time.Now()
+		from, to := lastCheck, now
+		if !hasSubscribers() {
+			lastCheck = now
+			return nil
+		}
+
+		// LastSeenAt 落在 (from-阈值, to-阈值] 内的设备恰好在本轮检测窗口内变为离线
+		threshold := config.AppConfig.DeviceOfflineThreshold
+		var devices []models.Device
+		err := db.DB.WithContext(ctx).Select("id", "hostname", "last_seen_at").
+			Where("last_seen_at > ? AND last_seen_at <= ? AND decommissioned_at IS NULL", from.Add(-threshold), to.Add(-threshold)).
+			Find(&devices).Error
+		if err != nil {
+			return err // 不推进 lastCheck，下一轮重新覆盖本轮窗口
+		}
+		lastCheck = now
+		for _, d := range devices {
+			PublishDevice(DeviceEvent{Type: DeviceOffline, DeviceID: d.ID, Hostname: d.Hostname, LastSeenAt: d.LastSeenAt})
+		}
+		return nil
+	})
+}
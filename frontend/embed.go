@@ -0,0 +1,21 @@
+//go:build embedfrontend
+
+package frontend
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// dist 编译时需要 frontend/dist 已经构建完成
+//
+//go:embed all:dist
+var dist embed.FS
+
+func init() {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		panic(err)
+	}
+	Assets = sub
+}
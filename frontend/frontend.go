@@ -0,0 +1,8 @@
+// Package frontend 提供编译进二进制的前端构建产物 (frontend/dist)
+// 只有使用 embedfrontend 构建标签编译时才会内嵌: go build -tags embedfrontend
+package frontend
+
+import "io/fs"
+
+// Assets 内嵌的前端文件，以 dist 目录为根；未使用 embedfrontend 构建标签时为 nil
+var Assets fs.FS
@@ -7,9 +7,12 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.2.1
 	github.com/joho/godotenv v1.5.1
 	github.com/labstack/echo/v4 v4.11.4
+	github.com/prometheus/client_golang v1.19.0
 	github.com/spf13/viper v1.18.2
+	golang.org/x/time v0.5.0
 	gorm.io/driver/postgres v1.5.6
 	gorm.io/gorm v1.25.7
+	gorm.io/plugin/dbresolver v1.5.2
 )
 
 require (
@@ -47,7 +50,6 @@ require (
 	golang.org/x/net v0.21.0 // indirect
 	golang.org/x/sys v0.17.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-	golang.org/x/time v0.5.0 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/middleware"
+	"go-agent-manager/models"
+	"go-agent-manager/ruleset"
+	"go-agent-manager/signing"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HeaderOfflinePolicy 当前的离线策略，GetAgentRules 在 200 和 304 响应中都会返回
+const HeaderOfflinePolicy = "X-Offline-Policy"
+
+// GetAgentRules 返回当前完整规则集 (仅启用的规则，按优先级排序) 及其 ETag，Agent 可用该 ETag 调用 /rules/diff 获取增量
+// 请求带 If-None-Match 且与当前 ETag 一致时返回 304 (无响应体)，Agent 可以低成本轮询。
+// ETag 只反映规则内容 (也是 /rules/diff 的版本号)，offline_policy 不参与计算，
+// 因此同时通过 X-Offline-Policy 响应头返回，规则未变化的 304 响应中 Agent 也能得到最新的离线策略
+func GetAgentRules(c echo.Context) error {
+	current, err := agentRuleSet(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	c.Response().Header().Set("ETag", `"`+current.ETag+`"`)
+	c.Response().Header().Set(HeaderOfflinePolicy, config.AppConfig.AgentOfflinePolicy)
+	if etagMatches(c.Request().Header.Get("If-None-Match"), current.ETag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return signedJSON(c, http.StatusOK, map[string]interface{}{
+		"etag":           current.ETag,
+		"rules":          current.Rules,
+		"offline_policy": config.AppConfig.AgentOfflinePolicy, // 与 X-Offline-Policy 响应头一致
+	})
+}
+
+// GetAgentRulesDiff 返回自 since 指定的规则集版本以来新增、删除和变更的规则
+// since 对应的快照已被清理时返回 410，Agent 应重新拉取完整规则集
+func GetAgentRulesDiff(c echo.Context) error {
+	since := ruleset.NormalizeETag(c.QueryParam("since"))
+	if since == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "since query parameter is required")
+	}
+
+	ctx := c.Request().Context()
+	current, err := agentRuleSet(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	var diff ruleset.Diff
+	if since == current.ETag {
+		diff = ruleset.Compare(current.Rules, current.Rules)
+	} else {
+		previous, err := ruleset.Load(ctx, since)
+		if errors.Is(err, ruleset.ErrSnapshotNotFound) {
+			return apierror.New(http.StatusGone, "snapshot_expired",
+				"Rule set version is unknown or has expired; fetch the full rule set")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		diff = ruleset.Compare(previous.Rules, current.Rules)
+	}
+
+	c.Response().Header().Set("ETag", `"`+current.ETag+`"`)
+	return signedJSON(c, http.StatusOK, map[string]interface{}{
+		"since":   since,
+		"etag":    current.ETag,
+		"added":   diff.Added,
+		"removed": diff.Removed,
+		"changed": diff.Changed,
+	})
+}
+
+// etagMatches 判断 If-None-Match 请求头是否包含指定 ETag
+// 支持逗号分隔的多个值、弱校验前缀 W/ 以及 "*"
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if ruleset.NormalizeETag(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigBundleSchemaVersion Agent 配置包的格式版本，结构发生不兼容变化时递增
+const ConfigBundleSchemaVersion = 1
+
+// ConfigBundle Agent 首次启动时获取并持久化的完整配置
+type ConfigBundle struct {
+	SchemaVersion          int               `json:"schema_version"`
+	GeneratedAt            time.Time         `json:"generated_at"`
+	DeviceID               string            `json:"device_id,omitempty"` // Agent Key 未关联设备时为空
+	ServerURLs             []string          `json:"server_urls"`
+	CheckinIntervalSeconds int               `json:"checkin_interval_seconds"`
+	OfflinePolicy          string            `json:"offline_policy"` // 连不上服务端时的策略: proxy-all / block-all / last-known
+	Endpoints              map[string]string `json:"endpoints"`      // 相对于 server_urls 的接口路径
+	Signing                *SigningInfo      `json:"signing"`        // 未启用签名时为 null
+}
+
+// SigningInfo Agent 校验规则签名所需的信息
+type SigningInfo struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// GetAgentConfigBundle 返回 Agent 引导配置包 (设备 ID、服务端地址、上报间隔、签名公钥等)
+// 启用签名时响应体同样带签名，Agent 可以校验后持久化
+func GetAgentConfigBundle(c echo.Context) error {
+	deviceID, _ := c.Get(middleware.AgentDeviceID).(string)
+
+	var serverURLs []string
+	for _, u := range strings.Split(config.AppConfig.AgentServerURLs, ",") {
+		if u = strings.TrimRight(strings.TrimSpace(u), "/"); u != "" {
+			serverURLs = append(serverURLs, u)
+		}
+	}
+	if len(serverURLs) == 0 {
+		serverURLs = []string{c.Scheme() + "://" + c.Request().Host}
+	}
+
+	bundle := ConfigBundle{
+		SchemaVersion:          ConfigBundleSchemaVersion,
+		GeneratedAt:            time.Now().UTC(),
+		DeviceID:               deviceID,
+		ServerURLs:             serverURLs,
+		CheckinIntervalSeconds: int(config.AppConfig.AgentCheckinInterval.Seconds()),
+		OfflinePolicy:          config.AppConfig.AgentOfflinePolicy,
+		Endpoints: map[string]string{
+			"rules":           "/api/agent/rules",
+			"rules_diff":      "/api/agent/rules/diff",
+			"rules_subscribe": "/api/agent/rules/subscribe",
+			"public_key":      "/api/agent/rules/public-key",
+		},
+	}
+	if signing.Enabled() {
+		bundle.Signing = currentSigningInfo()
+	}
+	return signedJSON(c, http.StatusOK, bundle)
+}
+
+// currentSigningInfo 当前签名密钥的公开信息
+func currentSigningInfo() *SigningInfo {
+	return &SigningInfo{
+		Algorithm: signing.Algorithm,
+		KeyID:     signing.KeyID(),
+		PublicKey: signing.PublicKey(),
+	}
+}
+
+// agentRuleSet 构建当前 Agent 可执行的规则集
+func agentRuleSet(c echo.Context) (*ruleset.Snapshot, error) {
+	capabilities, err := agentCapabilities(c)
+	if err != nil {
+		return nil, err
+	}
+	return ruleset.CurrentFor(c.Request().Context(), capabilities)
+}
+
+// agentCapabilities 返回用于过滤规则的能力集合
+// Agent Key 关联了设备时为设备上报的能力；未关联设备时返回 nil (下发完整规则集)；
+// 关联的设备已不存在 (删除或归档) 时返回空集合，只下发不依赖任何能力的规则，而不是退回完整规则集
+func agentCapabilities(c echo.Context) ([]string, error) {
+	deviceID, _ := c.Get(middleware.AgentDeviceID).(string)
+	if deviceID == "" {
+		return nil, nil
+	}
+	var device models.Device
+	err := db.DB.WithContext(c.Request().Context()).Select("id", "capabilities").First(&device, "id = ?", deviceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if device.Capabilities == nil {
+		return []string{}, nil // 未上报能力的设备只能执行不依赖任何能力的规则
+	}
+	return device.Capabilities, nil
+}
+
+// subscribeKeepAlive SSE 连接的心跳间隔，防止代理或负载均衡器关闭空闲连接
+const subscribeKeepAlive = 30 * time.Second
+
+// SubscribeAgentRules 以 Server-Sent Events 推送规则集变更
+// 连接建立后立即发送一次当前 ETag，之后每当该 Agent 可见的规则集 ETag 变化时发送 "rules" 事件，
+// Agent 收到后再调用 /rules 或 /rules/diff 拉取内容。Agent 断开时自动取消订阅
+func SubscribeAgentRules(c echo.Context) error {
+	capabilities, err := agentCapabilities(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	ctx := c.Request().Context()
+
+	// 先订阅再读取当前版本，避免两者之间的变更被遗漏
+	updates, unsubscribe := ruleset.Subscribe(capabilities, "")
+	defer unsubscribe()
+	current, err := ruleset.CurrentFor(ctx, capabilities)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no") // 关闭 nginx 缓冲
+	res.WriteHeader(http.StatusOK)
+
+	lastETag := current.ETag
+	if err := writeRulesEvent(res, lastETag); err != nil {
+		return nil
+	}
+
+	keepAlive := time.NewTicker(subscribeKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case etag := <-updates:
+			if etag == lastETag {
+				continue
+			}
+			lastETag = etag
+			if err := writeRulesEvent(res, etag); err != nil {
+				return nil // 客户端已断开
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+// writeRulesEvent 写出一条 SSE "rules" 事件
+func writeRulesEvent(res *echo.Response, etag string) error {
+	if _, err := fmt.Fprintf(res, "event: rules\ndata: {\"etag\":%q}\n\n", etag); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
+
+// maxRuleReportEntries 单次上报的最大规则数
+const maxRuleReportEntries = 1000
+
+// ReportRuleApplication Agent 上报规则应用结果
+// 请求体: {"applied": ["rule-id", ...], "failed": [{"rule_id": "...", "error": "..."}]}
+// 每个 (设备, 规则) 只保留最近一次结果；未知的规则 ID 会被忽略并在响应中返回
+func ReportRuleApplication(c echo.Context) error {
+	deviceID, _ := c.Get(middleware.AgentDeviceID).(string)
+	if deviceID == "" {
+		return apierror.New(http.StatusForbidden, "device_required", "Agent key is not associated with a device")
+	}
+
+	type FailedRule struct {
+		RuleID string `json:"rule_id"`
+		Error  string `json:"error"`
+	}
+	type RuleReport struct {
+		Applied []string     `json:"applied"`
+		Failed  []FailedRule `json:"failed"`
+	}
+	req := new(RuleReport)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	if len(req.Applied)+len(req.Failed) > maxRuleReportEntries {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("At most %d rules can be reported at once", maxRuleReportEntries))
+	}
+
+	now := time.Now()
+	results := make(map[string]models.RuleApplication, len(req.Applied)+len(req.Failed))
+	for _, id := range req.Applied {
+		results[id] = models.RuleApplication{DeviceID: deviceID, RuleID: id, Status: models.RuleApplicationApplied, ReportedAt: now}
+	}
+	for _, f := range req.Failed {
+		// 同一规则同时出现在 applied 和 failed 中时以失败为准
+		results[f.RuleID] = models.RuleApplication{DeviceID: deviceID, RuleID: f.RuleID, Status: models.RuleApplicationFailed, Error: f.Error, ReportedAt: now}
+	}
+
+	ids := make([]string, 0, len(results))
+	for id := range results {
+		if isUUID(id) {
+			ids = append(ids, id)
+		}
+	}
+	var known []string
+	if len(ids) > 0 {
+		if err := db.DB.Model(&models.Rule{}).Where("id IN ?", ids).Pluck("id", &known).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	records := make([]models.RuleApplication, 0, len(known))
+	for _, id := range known {
+		records = append(records, results[id])
+		delete(results, id)
+	}
+	if len(records) > 0 {
+		err := db.DB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "device_id"}, {Name: "rule_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"status", "error", "reported_at", "updated_at", "deleted_at"}),
+		}).Create(&records).Error
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	unknown := make([]string, 0, len(results))
+	for id := range results {
+		unknown = append(unknown, id)
+	}
+	sort.Strings(unknown)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"recorded":         len(records),
+		"unknown_rule_ids": unknown,
+	})
+}
+
+// GetRuleSigningPublicKey 返回 Agent 用于校验规则签名的公钥
+func GetRuleSigningPublicKey(c echo.Context) error {
+	if !signing.Enabled() {
+		return apierror.New(http.StatusNotFound, "signing_disabled", "Rule signing is not configured")
+	}
+	return c.JSON(http.StatusOK, currentSigningInfo())
+}
+
+// signedJSON 输出 JSON 响应，启用签名时在 X-Signature 头中附带对响应体原始字节的签名
+func signedJSON(c echo.Context, status int, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if signing.Enabled() {
+		header := c.Response().Header()
+		header.Set("X-Signature", signing.Sign(payload))
+		header.Set("X-Signature-Algorithm", signing.Algorithm)
+		header.Set("X-Signature-Key-Id", signing.KeyID())
+	}
+	return c.JSONBlob(status, payload)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"go-agent-manager/db"
+	"go-agent-manager/middleware"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// agentKeyPrefix 明文 API Key 的固定前缀，便于在日志和配置中识别
+const agentKeyPrefix = "agk_"
+
+// AgentKeyResponse Agent Key 列表项，附带是否已过期
+type AgentKeyResponse struct {
+	models.AgentKey
+	Expired bool `json:"expired"`
+}
+
+// GetAgentKeys 列出所有未吊销的 Agent API Key (不包含明文和哈希)
+func GetAgentKeys(c echo.Context) error {
+	var keys []models.AgentKey
+	if result := db.DB.Order("created_at DESC").Find(&keys); result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	now := time.Now()
+	resp := make([]AgentKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		resp = append(resp, AgentKeyResponse{
+			AgentKey: k,
+			Expired:  k.ExpiresAt != nil && !k.ExpiresAt.After(now),
+		})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// CreateAgentKey 签发新的 Agent API Key，明文只在本次响应中返回
+// 有效期可以通过 expires_in (例如 "2160h") 或 expires_at 指定，都不填表示永不过期
+func CreateAgentKey(c echo.Context) error {
+	type CreateRequest struct {
+		Name      string     `json:"name"`
+		DeviceID  string     `json:"device_id"`
+		ExpiresIn string     `json:"expires_in"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	req := new(CreateRequest)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	if req.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+	}
+	if req.ExpiresIn != "" && req.ExpiresAt != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Only one of expires_in or expires_at may be set")
+	}
+
+	now := time.Now()
+	expiresAt := req.ExpiresAt
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "expires_in must be a positive duration such as 2160h")
+		}
+		t := now.Add(d)
+		expiresAt = &t
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Expiry must be in the future")
+	}
+
+	if req.DeviceID != "" {
+		var device models.Device
+		if result := db.Primary().First(&device, "id = ?", req.DeviceID); result.Error != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid DeviceID")
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate agent key")
+	}
+	plain := agentKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	createdBy, _ := c.Get(middleware.UserKeycloakID).(string)
+	key := models.AgentKey{
+		Name:      req.Name,
+		KeyHash:   middleware.HashAgentKey(plain),
+		KeyPrefix: plain[:len(agentKeyPrefix)+6],
+		DeviceID:  req.DeviceID,
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+	}
+	if result := db.DB.Create(&key); result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	recordAudit(c, "agent_key.create", "agent_key", key.ID, map[string]interface{}{
+		"name":       key.Name,
+		"device_id":  key.DeviceID,
+		"expires_at": key.ExpiresAt,
+	})
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"key":       plain,
+		"agent_key": key,
+	})
+}
+
+// RevokeAgentKey 吊销 Agent API Key
+func RevokeAgentKey(c echo.Context) error {
+	id := c.Param("id")
+	result := db.DB.Delete(&models.AgentKey{}, "id = ?", id)
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "Agent key not found")
+	}
+	recordAudit(c, "agent_key.revoke", "agent_key", id, nil)
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/middleware"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestGetAgentRulesOfflinePolicyHeader(t *testing.T) {
+	openTestDB(t)
+	config.AppConfig.AgentOfflinePolicy = models.OfflinePolicyLastKnown
+
+	rec := serve(t, GetAgentRules, request{method: http.MethodGet, target: "/agent/rules"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	etag := rec.Header().Get("ETag")
+	if got := rec.Header().Get(HeaderOfflinePolicy); got != models.OfflinePolicyLastKnown {
+		t.Errorf("200 %s = %q, want %q", HeaderOfflinePolicy, got, models.OfflinePolicyLastKnown)
+	}
+
+	// 规则未变化但离线策略已变更: 仍返回 304，响应头中带有新的策略
+	config.AppConfig.AgentOfflinePolicy = models.OfflinePolicyBlockAll
+	rec = serve(t, GetAgentRules, request{method: http.MethodGet, target: "/agent/rules", header: map[string]string{"If-None-Match": etag}})
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("conditional request: status %d, want 304", rec.Code)
+	}
+	if got := rec.Header().Get(HeaderOfflinePolicy); got != models.OfflinePolicyBlockAll {
+		t.Errorf("304 %s = %q, want %q", HeaderOfflinePolicy, got, models.OfflinePolicyBlockAll)
+	}
+}
+
+func TestAgentCapabilities(t *testing.T) {
+	openTestDB(t)
+
+	reported := createTestDevice(t, "hw-caps-reported", func(d *models.Device) { d.Capabilities = []string{models.CapabilityCIDRMatch} })
+	deleted := createTestDevice(t, "hw-caps-deleted", func(d *models.Device) { d.Capabilities = []string{models.CapabilityCIDRMatch} })
+	if err := db.DB.Delete(&deleted).Error; err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		deviceID string
+		want     []string // nil 表示不过滤
+	}{
+		{"no device", "", nil},
+		{"reported", reported.ID, []string{models.CapabilityCIDRMatch}},
+		{"deleted device", deleted.ID, []string{}},
+		{"unknown device", "9a8b7c6d-5e4f-4a3b-9c2d-1e0f9a8b7c6d", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/agent/rules", nil), httptest.NewRecorder())
+			if tt.deviceID != "" {
+				c.Set(middleware.AgentDeviceID, tt.deviceID)
+			}
+			got, err := agentCapabilities(c)
+			if err != nil {
+				t.Fatalf("agentCapabilities: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("capabilities = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
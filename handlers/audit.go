@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"go-agent-manager/audit"
+	"go-agent-manager/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// recordAudit 以当前请求的操作者、请求 ID 和客户端 IP 写入一条审计记录
+func recordAudit(c echo.Context, action, resourceType, resourceID string, details map[string]interface{}) {
+	actorID, _ := c.Get(middleware.UserKeycloakID).(string)
+	audit.Record(c.Request().Context(), audit.Entry{
+		ActorID:      actorID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		RequestID:    c.Response().Header().Get(echo.HeaderXRequestID),
+		RemoteIP:     c.RealIP(),
+		Details:      details,
+	})
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"go-agent-manager/config"
+
+	"github.com/labstack/echo/v4"
+)
+
+// bindBody 绑定请求体
+// 开启 STRICT_BINDING 时 JSON 请求体中出现未知字段 (例如拼写错误的 "hostnam") 会返回 400，
+// 关闭时保持 Echo 默认行为，静默忽略未知字段
+func bindBody(c echo.Context, v interface{}) error {
+	req := c.Request()
+	if !config.AppConfig.StrictBinding || !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		if err := c.Bind(v); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return nil
+	}
+
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Request body is empty")
+		}
+		// encoding/json 对未知字段返回 `json: unknown field "xxx"`
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return echo.NewHTTPError(http.StatusBadRequest, "Unknown field "+field)
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+	}
+	// 对象之后只允许空白；dec.More 对紧跟的 "]" 或 "}" 返回 false，因此再解码一次并要求读到 EOF
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Request body must contain a single JSON object")
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-agent-manager/config"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestBindBody(t *testing.T) {
+	type payload struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	tests := []struct {
+		name        string
+		strict      bool
+		contentType string
+		body        string
+		want        payload
+		wantErr     string // 期望错误信息中包含的内容，空表示成功
+	}{
+		{"strict: valid", true, echo.MIMEApplicationJSON, `{"name": "a", "count": 2}`, payload{Name: "a", Count: 2}, ""},
+		{"strict: charset suffix", true, echo.MIMEApplicationJSONCharsetUTF8, `{"name": "a"}`, payload{Name: "a"}, ""},
+		{"strict: unknown field", true, echo.MIMEApplicationJSON, `{"name": "a", "nmae": "b"}`, payload{}, `Unknown field "nmae"`},
+		{"strict: empty body", true, echo.MIMEApplicationJSON, ``, payload{}, "Request body is empty"},
+		{"strict: whitespace body", true, echo.MIMEApplicationJSON, "  \n", payload{}, "Request body is empty"},
+		{"strict: trailing object", true, echo.MIMEApplicationJSON, `{"name": "a"} {"name": "b"}`, payload{}, "single JSON object"},
+		{"strict: trailing garbage", true, echo.MIMEApplicationJSON, `{"name": "a"} xyz`, payload{}, "single JSON object"},
+		{"strict: trailing brace", true, echo.MIMEApplicationJSON, `{"name": "a"}}`, payload{}, "single JSON object"},
+		{"strict: trailing whitespace", true, echo.MIMEApplicationJSON, "{\"name\": \"a\"}\n", payload{Name: "a"}, ""},
+		{"strict: malformed", true, echo.MIMEApplicationJSON, `{"name": `, payload{}, "Invalid JSON body"},
+		{"strict: wrong type", true, echo.MIMEApplicationJSON, `{"count": "two"}`, payload{}, "Invalid JSON body"},
+
+		{"lenient: valid", false, echo.MIMEApplicationJSON, `{"name": "a", "count": 2}`, payload{Name: "a", Count: 2}, ""},
+		{"lenient: unknown field ignored", false, echo.MIMEApplicationJSON, `{"name": "a", "nmae": "b"}`, payload{Name: "a"}, ""},
+		{"lenient: empty body", false, echo.MIMEApplicationJSON, ``, payload{}, ""},
+		{"lenient: trailing data ignored", false, echo.MIMEApplicationJSON, `{"name": "a"} {"name": "b"}`, payload{Name: "a"}, ""},
+		{"lenient: malformed", false, echo.MIMEApplicationJSON, `{"name": `, payload{}, "400"},
+	}
+	prev := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = prev })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppConfig.StrictBinding = tt.strict
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, tt.contentType)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			var got payload
+			err := bindBody(c, &got)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("bindBody: %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("bound %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			var httpErr *echo.HTTPError
+			if !errors.As(err, &httpErr) || httpErr.Code != http.StatusBadRequest {
+				t.Fatalf("bindBody = %v, want 400", err)
+			}
+			if !strings.Contains(httpErr.Error(), tt.wantErr) {
+				t.Errorf("error %q does not mention %q", httpErr.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBindBodyStrictRuleUpdate(t *testing.T) {
+	prev := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = prev })
+	config.AppConfig.StrictBinding = true
+
+	bind := func(body string) (*ruleUpdate, error) {
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		update := new(ruleUpdate)
+		return update, bindBody(c, update)
+	}
+
+	// 嵌入的规则字段在严格模式下同样是已知字段
+	update, err := bind(`{"name": "a", "match": "example.com", "active_schedule": "Mon 09:00-18:00", "priority": 0}`)
+	if err != nil {
+		t.Fatalf("bindBody: %v", err)
+	}
+	if update.Name != "a" || update.Match != "example.com" || update.ActiveSchedule != "Mon 09:00-18:00" {
+		t.Errorf("bound %+v, want rule fields populated", update.Rule)
+	}
+	if update.Priority == nil || *update.Priority != 0 {
+		t.Errorf("priority = %v, want explicit 0", update.Priority)
+	}
+
+	if update, err = bind(`{"name": "a"}`); err != nil || update.Priority != nil {
+		t.Errorf("omitted priority: err %v, priority %v; want nil", err, update.Priority)
+	}
+	if _, err := bind(`{"name": "a", "prority": 1}`); err == nil {
+		t.Error("unknown field accepted in strict mode")
+	}
+}
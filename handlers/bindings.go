@@ -1,76 +1,516 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"go-agent-manager/apierror"
+	"go-agent-manager/bindings"
+	"go-agent-manager/config"
 	"go-agent-manager/db"
 	"go-agent-manager/models"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
-// GetBindings 获取所有用户设备绑定
+// GetBindings 分页获取用户设备绑定 (limit / page_token，兼容 offset)
+// 支持 ?fields= 只返回指定字段；?keycloak_user_id= 和 ?device_id= 按用户、设备过滤，可同时使用；
+// ?bound_after= / ?bound_before= (RFC3339) 按绑定时间范围过滤，两端均包含
 func GetBindings(c echo.Context) error {
+	page, err := parsePageParams(c)
+	if err != nil {
+		return err
+	}
+	fields, err := parseFields(c, bindingListFields)
+	if err != nil {
+		return err
+	}
+	query := db.DB
+	if userID := c.QueryParam("keycloak_user_id"); userID != "" {
+		query = query.Where("keycloak_user_id = ?", userID)
+	}
+	if deviceID := c.QueryParam("device_id"); deviceID != "" {
+		if !isUUID(deviceID) {
+			return echo.NewHTTPError(http.StatusBadRequest, "device_id must be a UUID")
+		}
+		query = query.Where("device_id = ?", deviceID)
+	}
+	boundAfter, err := parseOptionalTime(c.QueryParam("bound_after"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "bound_after "+err.Error())
+	}
+	boundBefore, err := parseOptionalTime(c.QueryParam("bound_before"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "bound_before "+err.Error())
+	}
+	if boundAfter != nil && boundBefore != nil && boundBefore.Before(*boundAfter) {
+		return echo.NewHTTPError(http.StatusBadRequest, "bound_before must not be earlier than bound_after")
+	}
+	if boundAfter != nil {
+		query = query.Where("bound_at >= ?", *boundAfter)
+	}
+	if boundBefore != nil {
+		query = query.Where("bound_at <= ?", *boundBefore)
+	}
+	// Preload 对当前页涉及的设备只发一条 IN 查询，且只取填充主机名所需的列
+	query = query.Preload("Device", func(tx *gorm.DB) *gorm.DB { return tx.Select("id", "hostname") })
 	var bindings []models.UserDeviceBinding
-	// 可以在这里 preload Device 信息以便前端显示
-	if result := db.DB.Preload("Device").Find(&bindings); result.Error != nil {
+	if result := page.apply(query).Find(&bindings); result.Error != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
 	}
+	bindings = paginate(c, page, bindings, func(b models.UserDeviceBinding) pageCursor {
+		return pageCursor{CreatedAt: b.CreatedAt, ID: b.ID}
+	})
 
-	// 为了前端显示方便，手动填充 Device Hostname
-	type BindingWithDevice struct {
-		models.UserDeviceBinding
-		DeviceHostname string `json:"device_hostname"`
-	}
-	var bindingsWithHostnames []BindingWithDevice
+	bindingsWithHostnames := make([]BindingWithDevice, 0, len(bindings))
 	for _, b := range bindings {
-		bd := BindingWithDevice{
-			UserDeviceBinding: b,
-		}
-		var device models.Device
-		if err := db.DB.First(&device, "id = ?", b.DeviceID).Error; err == nil {
-			bd.DeviceHostname = device.Hostname
-		} else {
-			bd.DeviceHostname = "未知设备"
-		}
-		bindingsWithHostnames = append(bindingsWithHostnames, bd)
+		bindingsWithHostnames = append(bindingsWithHostnames, newBindingWithDevice(b))
 	}
 
-	return c.JSON(http.StatusOK, bindingsWithHostnames)
+	return sparseJSON(c, http.StatusOK, bindingsWithHostnames, fields)
+}
+
+// BindingWithDevice 绑定响应，为了前端显示方便附带设备主机名
+type BindingWithDevice struct {
+	models.UserDeviceBinding
+	DeviceHostname string `json:"device_hostname"`
+}
+
+// newBindingWithDevice 用预加载的设备填充 Device Hostname
+func newBindingWithDevice(b models.UserDeviceBinding) BindingWithDevice {
+	hostname := "未知设备" // 设备已删除或不存在
+	if b.Device != nil {
+		hostname = b.Device.Hostname
+	}
+	return BindingWithDevice{UserDeviceBinding: b, DeviceHostname: hostname}
+}
+
+// GetBinding 获取单个绑定，与列表一样附带设备主机名
+func GetBinding(c echo.Context) error {
+	id := c.Param("id")
+	if !isUUID(id) {
+		return echo.NewHTTPError(http.StatusNotFound, "Binding not found")
+	}
+	var binding models.UserDeviceBinding
+	err := db.DB.Preload("Device", func(tx *gorm.DB) *gorm.DB { return tx.Select("id", "hostname") }).
+		First(&binding, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Binding not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, newBindingWithDevice(binding))
 }
 
 // CreateBinding 创建新的用户设备绑定
 func CreateBinding(c echo.Context) error {
 	binding := new(models.UserDeviceBinding)
-	if err := c.Bind(binding); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	if err := bindBody(c, binding); err != nil {
+		return err
 	}
 
 	// 验证 KeycloakUserID 和 DeviceID 是否存在
 	var device models.Device
-	if result := db.DB.First(&device, "id = ?", binding.DeviceID); result.Error != nil {
+	if result := db.Primary().Unscoped().First(&device, "id = ?", binding.DeviceID); result.Error != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid DeviceID")
 	}
+	if err := checkDeviceBindable(device); err != nil {
+		return err
+	}
 	// TODO: 验证 KeycloakUserID 是否为 Keycloak 中的真实用户 (可选，但推荐)
 
+	if binding.ExpiresAt != nil && !binding.ExpiresAt.After(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "expires_at must be in the future")
+	}
+
 	binding.ID = "" // 让 GORM 自动生成 UUID
 	binding.BoundAt = time.Now()
-	binding.Status = "active" // 默认激活
+	binding.Status = config.AppConfig.DefaultBindingStatus // 初始状态由 DEFAULT_BINDING_STATUS 决定，REQUIRE_BINDING_APPROVAL 开启时为 pending_approval
+	if err := bindings.Check(bindings.StatusNew, binding.Status); err != nil {
+		return bindingStatusError(err)
+	}
 
-	if result := db.DB.Create(&binding); result.Error != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	// 上限检查与插入在同一事务中进行，并按用户加锁，防止并发请求同时通过计数
+	err := db.DB.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		// 已解绑 (软删除) 的绑定不占用唯一索引，同一用户和设备可以重新绑定
+		if err := checkBindingAbsent(tx, binding.KeycloakUserID, binding.DeviceID); err != nil {
+			return err
+		}
+		if binding.Status == models.BindingStatusActive {
+			if err := checkUserBindingLimit(tx, binding.KeycloakUserID); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&binding).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// 并发创建同一对绑定时，后提交的请求在唯一索引 idx_user_device_binding_live 上冲突
+		if existsErr := checkBindingAbsent(db.Primary(), binding.KeycloakUserID, binding.DeviceID); existsErr != nil {
+			err = existsErr
+		} else {
+			err = apierror.New(http.StatusConflict, "binding_exists", "This user is already bound to the device")
+		}
+	}
+	if err != nil {
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	recordAudit(c, "binding.create", "binding", binding.ID, map[string]interface{}{
+		"device_id":        binding.DeviceID,
+		"keycloak_user_id": binding.KeycloakUserID,
+		"status":           binding.Status,
+	})
 	return c.JSON(http.StatusCreated, binding)
 }
 
+// checkBindingAbsent 用户与设备之间已有未删除的绑定时返回 409 binding_exists
+func checkBindingAbsent(tx *gorm.DB, keycloakUserID, deviceID string) error {
+	var existing models.UserDeviceBinding
+	err := tx.Select("id", "status").
+		Where("keycloak_user_id = ? AND device_id = ?", keycloakUserID, deviceID).
+		First(&existing).Error
+	if err == nil {
+		return apierror.New(http.StatusConflict, "binding_exists", "This user is already bound to the device").
+			WithDetails(map[string]interface{}{"binding_id": existing.ID, "status": existing.Status})
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// findBinding 从主库按 ID 读取绑定 (修改前读取，避免只读副本延迟)，不存在时返回 404
+func findBinding(id string) (models.UserDeviceBinding, error) {
+	var binding models.UserDeviceBinding
+	if !isUUID(id) {
+		return binding, echo.NewHTTPError(http.StatusNotFound, "Binding not found")
+	}
+	err := db.Primary().First(&binding, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return binding, echo.NewHTTPError(http.StatusNotFound, "Binding not found")
+	}
+	if err != nil {
+		return binding, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return binding, nil
+}
+
 // DeleteBinding 删除用户设备绑定 (解绑)
+// 经由 bindings.Unbind: 生效中的绑定先转为 inactive (待审批的转为 rejected) 并记录 UnboundAt，再软删除
 func DeleteBinding(c echo.Context) error {
 	id := c.Param("id")
-	if result := db.DB.Delete(&models.UserDeviceBinding{}, "id = ?", id); result.Error != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	binding, err := findBinding(id)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	previous := binding.Status
+	err = db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return bindings.Unbind(ctx, tx, &binding)
+	})
+	if err != nil {
+		return bindingStatusError(err)
+	}
+	recordAudit(c, "binding.delete", "binding", id, map[string]interface{}{
+		"device_id":        binding.DeviceID,
+		"keycloak_user_id": binding.KeycloakUserID,
+		"from":             previous,
+		"to":               binding.Status,
+	})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ExtendBinding 延长绑定的有效期，支持两种方式:
+// {"extend_by": "720h"} 在当前过期时间 (已过期或未设置则从现在起) 基础上顺延
+// {"expires_at": "2025-01-01T00:00:00Z"} 直接指定新的过期时间
+// 只能延长 active 和 inactive (例如已过期被自动解绑) 的绑定；inactive 的绑定经由状态机重新激活，
+// 与状态接口一样要求设备可绑定且不超过用户绑定上限。待审批或已拒绝的绑定返回 409
+func ExtendBinding(c echo.Context) error {
+	id := c.Param("id")
+	type ExtendRequest struct {
+		ExtendBy  string     `json:"extend_by"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	req := new(ExtendRequest)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	if (req.ExtendBy == "") == (req.ExpiresAt == nil) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Exactly one of extend_by or expires_at is required")
+	}
+
+	binding, err := findBinding(id)
+	if err != nil {
+		return err
+	}
+	if binding.Status != models.BindingStatusActive && binding.Status != models.BindingStatusInactive {
+		return apierror.New(http.StatusConflict, "binding_not_extendable",
+			fmt.Sprintf("Cannot extend a binding in status %s", binding.Status)).
+			WithDetails(map[string]interface{}{"status": binding.Status})
+	}
+
+	now := time.Now()
+	var newExpiry time.Time
+	if req.ExpiresAt != nil {
+		newExpiry = *req.ExpiresAt
+	} else {
+		extendBy, err := time.ParseDuration(req.ExtendBy)
+		if err != nil || extendBy <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "extend_by must be a positive duration such as 720h")
+		}
+		base := now
+		if binding.ExpiresAt != nil && binding.ExpiresAt.After(now) {
+			base = *binding.ExpiresAt
+		}
+		newExpiry = base.Add(extendBy)
 	}
+	if !newExpiry.After(now) {
+		return echo.NewHTTPError(http.StatusBadRequest, "New expiry must be in the future")
+	}
+
+	reactivate := binding.Status == models.BindingStatusInactive
+	if reactivate {
+		if err := checkBindingDeviceBindable(binding.DeviceID); err != nil {
+			return err
+		}
+	}
+
+	ctx := c.Request().Context()
+	previous, previousStatus := binding.ExpiresAt, binding.Status
+	err = db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if reactivate {
+			if err := checkUserBindingLimit(tx, binding.KeycloakUserID); err != nil {
+				return err
+			}
+			if err := bindings.Transition(ctx, tx, &binding, models.BindingStatusActive); err != nil {
+				return err
+			}
+		}
+		// 以状态为条件，避免与过期任务或状态接口的并发修改交错
+		result := tx.Model(&models.UserDeviceBinding{}).
+			Where("id = ? AND status = ?", binding.ID, models.BindingStatusActive).
+			Update("expires_at", newExpiry)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return bindings.ErrConcurrentUpdate
+		}
+		return nil
+	})
+	if err != nil {
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) {
+			return err
+		}
+		return bindingStatusError(err)
+	}
+	binding.ExpiresAt = &newExpiry
+
+	details := map[string]interface{}{
+		"device_id":           binding.DeviceID,
+		"keycloak_user_id":    binding.KeycloakUserID,
+		"previous_expires_at": previous,
+		"expires_at":          newExpiry,
+	}
+	if reactivate {
+		details["from"] = previousStatus
+		details["to"] = binding.Status
+	}
+	recordAudit(c, "binding.extend", "binding", binding.ID, details)
+	return c.JSON(http.StatusOK, binding)
+}
+
+// UpdateBindingStatus 修改绑定状态，请求体: {"status": "inactive"}
+// 只允许 bindings 包定义的合法转换，非法转换返回 409；转为 inactive 时记录 UnboundAt，重新激活时清空
+// 转为 active 时设备必须处于可绑定状态 (未删除、未停用、未封禁)，否则返回 409
+func UpdateBindingStatus(c echo.Context) error {
+	id := c.Param("id")
+	type StatusRequest struct {
+		Status string `json:"status"`
+	}
+	req := new(StatusRequest)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	if !bindings.IsValidStatus(req.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			"status must be one of active, inactive, pending_approval, rejected")
+	}
+
+	binding, err := findBinding(id)
+	if err != nil {
+		return err
+	}
+	// 先校验状态转换，非法转换 (例如 active -> active) 不应被上限或设备状态检查的错误掩盖
+	if err := bindings.Check(binding.Status, req.Status); err != nil {
+		return bindingStatusError(err)
+	}
+	if req.Status == models.BindingStatusActive {
+		// 重新激活与新建绑定一样，设备必须处于可绑定状态
+		if err := checkBindingDeviceBindable(binding.DeviceID); err != nil {
+			return err
+		}
+	}
+	previous := binding.Status
+	err = db.DB.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		if req.Status == models.BindingStatusActive {
+			if err := checkUserBindingLimit(tx, binding.KeycloakUserID); err != nil {
+				return err
+			}
+		}
+		return bindings.Transition(c.Request().Context(), tx, &binding, req.Status)
+	})
+	if err != nil {
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) {
+			return err
+		}
+		return bindingStatusError(err)
+	}
+	recordAudit(c, "binding.status", "binding", binding.ID, map[string]interface{}{
+		"device_id":        binding.DeviceID,
+		"keycloak_user_id": binding.KeycloakUserID,
+		"from":             previous,
+		"to":               binding.Status,
+	})
+	return c.JSON(http.StatusOK, binding)
+}
+
+// ApproveBinding 审批通过待审批的绑定: 状态改为 active，BoundAt 记为审批时间；设备已不可绑定时返回 409
+func ApproveBinding(c echo.Context) error {
+	id := c.Param("id")
+	binding, err := findBinding(id)
+	if err != nil {
+		return err
+	}
+	if binding.Status != models.BindingStatusPendingApproval {
+		return bindingStatusError(&bindings.TransitionError{From: binding.Status, To: models.BindingStatusActive})
+	}
+	// 审批期间设备可能已被删除、停用或封禁
+	if err := checkBindingDeviceBindable(binding.DeviceID); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	now := time.Now()
+	err = db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkUserBindingLimit(tx, binding.KeycloakUserID); err != nil {
+			return err
+		}
+		if err := bindings.Transition(ctx, tx, &binding, models.BindingStatusActive); err != nil {
+			return err
+		}
+		return tx.Model(&models.UserDeviceBinding{}).Where("id = ?", binding.ID).Update("bound_at", now).Error
+	})
+	if err != nil {
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) {
+			return err
+		}
+		return bindingStatusError(err)
+	}
+	binding.BoundAt = now
+	recordAudit(c, "binding.approve", "binding", binding.ID, map[string]interface{}{
+		"device_id":        binding.DeviceID,
+		"keycloak_user_id": binding.KeycloakUserID,
+	})
+	return c.JSON(http.StatusOK, binding)
+}
+
+// RejectBinding 拒绝待审批的绑定: 状态改为 rejected 后软删除，记录仍保留在库中供审计
+func RejectBinding(c echo.Context) error {
+	id := c.Param("id")
+	binding, err := findBinding(id)
+	if err != nil {
+		return err
+	}
+	if binding.Status != models.BindingStatusPendingApproval {
+		return bindingStatusError(&bindings.TransitionError{From: binding.Status, To: models.BindingStatusRejected})
+	}
+
+	ctx := c.Request().Context()
+	err = db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return bindings.Unbind(ctx, tx, &binding) // pending_approval -> rejected，然后软删除
+	})
+	if err != nil {
+		return bindingStatusError(err)
+	}
+	recordAudit(c, "binding.reject", "binding", binding.ID, map[string]interface{}{
+		"device_id":        binding.DeviceID,
+		"keycloak_user_id": binding.KeycloakUserID,
+	})
 	return c.NoContent(http.StatusNoContent)
 }
 
-// TODO: UpdateBindingStatus 用于更改绑定状态 (active/inactive/pending)
+// checkUserBindingLimit 检查用户的 active 绑定数是否已达到 MAX_DEVICES_PER_USER
+// 必须在事务中调用: 先获取该用户的事务级 advisory lock，使同一用户的并发请求串行化，锁在事务结束时释放
+func checkUserBindingLimit(tx *gorm.DB, userID string) error {
+	limit := config.AppConfig.MaxDevicesPerUser
+	if limit <= 0 {
+		return nil
+	}
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "binding-limit:"+userID).Error; err != nil {
+		return err
+	}
+	var active int64
+	err := tx.Model(&models.UserDeviceBinding{}).
+		Where("keycloak_user_id = ? AND status = ?", userID, models.BindingStatusActive).
+		Count(&active).Error
+	if err != nil {
+		return err
+	}
+	if active >= int64(limit) {
+		return apierror.New(http.StatusConflict, "device_limit_reached",
+			fmt.Sprintf("User already has %d active device bindings (limit %d)", active, limit)).
+			WithDetails(map[string]interface{}{"active": active, "limit": limit})
+	}
+	return nil
+}
+
+// bindingStatusError 将 bindings 包返回的状态转换错误转换为 409 响应，其他错误按 500 处理
+func bindingStatusError(err error) error {
+	var transitionErr *bindings.TransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		return apierror.New(http.StatusConflict, "illegal_status_transition", transitionErr.Error()).
+			WithDetails(map[string]interface{}{"from": transitionErr.From, "to": transitionErr.To})
+	case errors.Is(err, bindings.ErrConcurrentUpdate):
+		return apierror.New(http.StatusConflict, "status_changed", "Binding status was changed by another request; reload and retry")
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// checkBindingDeviceBindable 从主库加载绑定关联的设备 (包括已软删除的) 并检查是否可绑定，设备已不存在时返回 409
+func checkBindingDeviceBindable(deviceID string) error {
+	var device models.Device
+	if err := db.Primary().Unscoped().First(&device, "id = ?", deviceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return apierror.New(http.StatusConflict, "device_not_found", "The bound device no longer exists")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return checkDeviceBindable(device)
+}
+
+// checkDeviceBindable 设备处于不可绑定的生命周期状态时返回 409 (已删除、已停用、已封禁)
+func checkDeviceBindable(device models.Device) error {
+	switch {
+	case device.DeletedAt.Valid:
+		return apierror.New(http.StatusConflict, "device_deleted", "Cannot bind a deleted device")
+	case device.DecommissionedAt != nil:
+		return apierror.New(http.StatusConflict, "device_decommissioned", "Cannot bind a decommissioned device").
+			WithDetails(map[string]interface{}{"decommissioned_at": device.DecommissionedAt})
+	case device.BlockedAt != nil:
+		return apierror.New(http.StatusConflict, "device_blocked", "Cannot bind a blocked device").
+			WithDetails(map[string]interface{}{"blocked_at": device.BlockedAt, "reason": device.BlockReason})
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func TestCreateBindingAfterUnbind(t *testing.T) {
+	openTestDB(t)
+	device := createTestDevice(t, "hw-rebind")
+	body := fmt.Sprintf(`{"keycloak_user_id": "user-1", "device_id": %q}`, device.ID)
+
+	rec := serve(t, CreateBinding, request{method: http.MethodPost, target: "/bindings", body: body})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("bind: status %d, body %s", rec.Code, rec.Body)
+	}
+	var first models.UserDeviceBinding
+	decode(t, rec, &first)
+
+	rec = serve(t, CreateBinding, request{method: http.MethodPost, target: "/bindings", body: body})
+	if rec.Code != http.StatusConflict || errorCode(t, rec) != "binding_exists" {
+		t.Fatalf("duplicate bind: status %d, body %s; want 409 binding_exists", rec.Code, rec.Body)
+	}
+
+	rec = serve(t, DeleteBinding, request{method: http.MethodDelete, target: "/bindings/" + first.ID, params: map[string]string{"id": first.ID}})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("unbind: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(t, CreateBinding, request{method: http.MethodPost, target: "/bindings", body: body})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("rebind: status %d, body %s", rec.Code, rec.Body)
+	}
+	var second models.UserDeviceBinding
+	decode(t, rec, &second)
+	if second.ID == first.ID {
+		t.Errorf("rebind reused binding ID %s, want a new binding", first.ID)
+	}
+}
+
+func TestCreateBindingConcurrentDuplicates(t *testing.T) {
+	openTestDB(t)
+	device := createTestDevice(t, "hw-concurrent")
+	body := fmt.Sprintf(`{"keycloak_user_id": "user-1", "device_id": %q}`, device.ID)
+
+	const workers = 8
+	var wg sync.WaitGroup
+	statuses := make([]int, workers)
+	codes := make([]string, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := serve(t, CreateBinding, request{method: http.MethodPost, target: "/bindings", body: body})
+			statuses[i], codes[i] = rec.Code, errorCode(t, rec)
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for i := range statuses {
+		switch {
+		case statuses[i] == http.StatusCreated:
+			created++
+		case statuses[i] == http.StatusConflict && codes[i] == "binding_exists":
+		default:
+			t.Errorf("request %d: status %d code %q, want 201 or 409 binding_exists", i, statuses[i], codes[i])
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d bindings created, want exactly 1", created)
+	}
+}
+
+func TestCheckDeviceBindable(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		device models.Device
+		want   string // 期望的错误码，空表示可绑定
+	}{
+		{"live", models.Device{}, ""},
+		{"deleted", models.Device{Model: gorm.Model{DeletedAt: gorm.DeletedAt{Time: now, Valid: true}}}, "device_deleted"},
+		{"decommissioned", models.Device{DecommissionedAt: &now}, "device_decommissioned"},
+		{"blocked", models.Device{BlockedAt: &now, BlockReason: "lost"}, "device_blocked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDeviceBindable(tt.device)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("checkDeviceBindable = %v, want nil", err)
+				}
+				return
+			}
+			var apiErr *apierror.Error
+			if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict || apiErr.Code != tt.want {
+				t.Errorf("checkDeviceBindable = %v, want 409 %s", err, tt.want)
+			}
+		})
+	}
+}
+
+// disableDevice 将设备置为某种不可绑定的状态
+var disableDevice = map[string]func(t *testing.T, device *models.Device){
+	"device_deleted": func(t *testing.T, device *models.Device) {
+		if err := db.DB.Delete(device).Error; err != nil {
+			t.Fatalf("soft delete device: %v", err)
+		}
+	},
+	"device_decommissioned": func(t *testing.T, device *models.Device) {
+		if err := db.DB.Model(device).Update("decommissioned_at", time.Now()).Error; err != nil {
+			t.Fatalf("decommission device: %v", err)
+		}
+	},
+	"device_blocked": func(t *testing.T, device *models.Device) {
+		if err := db.DB.Model(device).Update("blocked_at", time.Now()).Error; err != nil {
+			t.Fatalf("block device: %v", err)
+		}
+	},
+}
+
+func TestActivateBindingOnUnbindableDevice(t *testing.T) {
+	openTestDB(t)
+	activations := []struct {
+		name    string
+		from    string
+		handler echo.HandlerFunc
+		body    string
+	}{
+		{"approve", models.BindingStatusPendingApproval, ApproveBinding, ""},
+		{"reactivate", models.BindingStatusInactive, UpdateBindingStatus, `{"status": "active"}`},
+		{"extend", models.BindingStatusInactive, ExtendBinding, `{"extend_by": "24h"}`},
+	}
+	for _, a := range activations {
+		for code, disable := range disableDevice {
+			t.Run(a.name+"/"+code, func(t *testing.T) {
+				device := createTestDevice(t, "hw-"+a.name+"-"+code)
+				binding := models.UserDeviceBinding{KeycloakUserID: "user-1", DeviceID: device.ID, Status: a.from, BoundAt: time.Now()}
+				if err := db.DB.Create(&binding).Error; err != nil {
+					t.Fatalf("create binding: %v", err)
+				}
+				disable(t, &device)
+
+				rec := serve(t, a.handler, request{method: http.MethodPost, target: "/bindings/" + binding.ID, body: a.body, params: map[string]string{"id": binding.ID}})
+				if rec.Code != http.StatusConflict || errorCode(t, rec) != code {
+					t.Fatalf("status %d, body %s; want 409 %s", rec.Code, rec.Body, code)
+				}
+				var stored models.UserDeviceBinding
+				if err := db.DB.First(&stored, "id = ?", binding.ID).Error; err != nil {
+					t.Fatalf("reload binding: %v", err)
+				}
+				if stored.Status != a.from {
+					t.Errorf("binding status = %s after rejected activation, want %s", stored.Status, a.from)
+				}
+			})
+		}
+	}
+}
+
+func TestUpdateBindingStatusChecksTransitionFirst(t *testing.T) {
+	openTestDB(t)
+	config.AppConfig.MaxDevicesPerUser = 1
+	device := createTestDevice(t, "hw-at-limit")
+	binding := models.UserDeviceBinding{KeycloakUserID: "user-1", DeviceID: device.ID, Status: models.BindingStatusActive, BoundAt: time.Now()}
+	if err := db.DB.Create(&binding).Error; err != nil {
+		t.Fatalf("create binding: %v", err)
+	}
+	if err := db.DB.Model(&device).Update("blocked_at", time.Now()).Error; err != nil {
+		t.Fatalf("block device: %v", err)
+	}
+
+	// 用户已达上限且设备已封禁，但 active -> active 本身就是非法转换，应当如实报告
+	rec := serve(t, UpdateBindingStatus, request{method: http.MethodPut, target: "/bindings/" + binding.ID + "/status",
+		body: `{"status": "active"}`, params: map[string]string{"id": binding.ID}})
+	if rec.Code != http.StatusConflict || errorCode(t, rec) != "illegal_status_transition" {
+		t.Errorf("status %d, body %s; want 409 illegal_status_transition", rec.Code, rec.Body)
+	}
+}
+
+func TestBindingHandlersRejectNonUUID(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler echo.HandlerFunc
+		body    string
+	}{
+		{"GetBinding", GetBinding, ""},
+		{"DeleteBinding", DeleteBinding, ""},
+		{"ExtendBinding", ExtendBinding, `{"extend_by": "24h"}`},
+		{"UpdateBindingStatus", UpdateBindingStatus, `{"status": "inactive"}`},
+		{"ApproveBinding", ApproveBinding, ""},
+		{"RejectBinding", RejectBinding, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, tt.handler, request{method: http.MethodPost, target: "/bindings/not-a-uuid", body: tt.body, params: map[string]string{"id": "not-a-uuid"}})
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status %d, body %s; want 404", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestExtendBinding(t *testing.T) {
+	openTestDB(t)
+	tests := []struct {
+		from       string
+		wantStatus int
+		wantCode   string
+	}{
+		{models.BindingStatusActive, http.StatusOK, ""},
+		{models.BindingStatusInactive, http.StatusOK, ""}, // 经状态机重新激活
+		{models.BindingStatusPendingApproval, http.StatusConflict, "binding_not_extendable"},
+		{models.BindingStatusRejected, http.StatusConflict, "binding_not_extendable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			device := createTestDevice(t, "hw-extend-"+tt.from)
+			expired := time.Now().Add(-time.Hour)
+			binding := models.UserDeviceBinding{KeycloakUserID: "user-1", DeviceID: device.ID, Status: tt.from, BoundAt: time.Now(), ExpiresAt: &expired}
+			if err := db.DB.Create(&binding).Error; err != nil {
+				t.Fatalf("create binding: %v", err)
+			}
+
+			rec := serve(t, ExtendBinding, request{method: http.MethodPost, target: "/bindings/" + binding.ID + "/extend",
+				body: `{"extend_by": "24h"}`, params: map[string]string{"id": binding.ID}})
+			if rec.Code != tt.wantStatus || errorCode(t, rec) != tt.wantCode {
+				t.Fatalf("status %d, body %s; want %d %s", rec.Code, rec.Body, tt.wantStatus, tt.wantCode)
+			}
+
+			var stored models.UserDeviceBinding
+			if err := db.DB.First(&stored, "id = ?", binding.ID).Error; err != nil {
+				t.Fatalf("reload binding: %v", err)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if stored.Status != tt.from || stored.ExpiresAt == nil || stored.ExpiresAt.After(time.Now()) {
+					t.Errorf("rejected extension changed the binding: status %s, expires_at %v", stored.Status, stored.ExpiresAt)
+				}
+				return
+			}
+			if stored.Status != models.BindingStatusActive || stored.UnboundAt != nil {
+				t.Errorf("status = %s, unbound_at = %v; want active with no unbound_at", stored.Status, stored.UnboundAt)
+			}
+			if stored.ExpiresAt == nil || !stored.ExpiresAt.After(time.Now().Add(23*time.Hour)) {
+				t.Errorf("expires_at = %v, want about 24h from now", stored.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestDeleteBindingUnbindsThroughStateMachine(t *testing.T) {
+	openTestDB(t)
+	tests := []struct {
+		from string
+		want string // 软删除记录中保留的最终状态
+	}{
+		{models.BindingStatusActive, models.BindingStatusInactive},
+		{models.BindingStatusPendingApproval, models.BindingStatusRejected},
+		{models.BindingStatusInactive, models.BindingStatusInactive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			device := createTestDevice(t, "hw-unbind-"+tt.from)
+			binding := models.UserDeviceBinding{KeycloakUserID: "user-1", DeviceID: device.ID, Status: tt.from, BoundAt: time.Now()}
+			if err := db.DB.Create(&binding).Error; err != nil {
+				t.Fatalf("create binding: %v", err)
+			}
+			params := map[string]string{"id": binding.ID}
+
+			rec := serve(t, DeleteBinding, request{method: http.MethodDelete, target: "/bindings/" + binding.ID, params: params})
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("delete: status %d, body %s", rec.Code, rec.Body)
+			}
+			var stored models.UserDeviceBinding
+			if err := db.DB.Unscoped().First(&stored, "id = ?", binding.ID).Error; err != nil {
+				t.Fatalf("reload binding: %v", err)
+			}
+			if !stored.DeletedAt.Valid || stored.Status != tt.want {
+				t.Errorf("deleted=%v status=%s, want soft-deleted with status %s", stored.DeletedAt.Valid, stored.Status, tt.want)
+			}
+			if tt.from == models.BindingStatusActive && stored.UnboundAt == nil {
+				t.Error("unbound_at not recorded for an active binding")
+			}
+
+			rec = serve(t, DeleteBinding, request{method: http.MethodDelete, target: "/bindings/" + binding.ID, params: params})
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("second delete: status %d, body %s; want 404", rec.Code, rec.Body)
+			}
+		})
+	}
+}
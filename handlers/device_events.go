@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-agent-manager/events"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StreamDeviceEvents 以 Server-Sent Events 推送设备状态变化，替代前端轮询 /devices
+// 事件名为 heartbeat / online / offline，data 为 events.DeviceEvent 的 JSON
+func StreamDeviceEvents(c echo.Context) error {
+	updates, unsubscribe := events.SubscribeDevices()
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no") // 关闭 nginx 缓冲
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	ctx := c.Request().Context()
+	keepAlive := time.NewTicker(subscribeKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-updates:
+			if err := writeDeviceEvent(res, ev); err != nil {
+				return nil // 客户端已断开
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+// writeDeviceEvent 写出一条 SSE 设备事件
+func writeDeviceEvent(res *echo.Response, ev events.DeviceEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -1,69 +1,950 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"go-agent-manager/apierror"
+	"go-agent-manager/archive"
+	"go-agent-manager/bindings"
+	"go-agent-manager/config"
 	"go-agent-manager/db"
+	"go-agent-manager/keycloak"
+	"go-agent-manager/logger"
 	"go-agent-manager/models"
+	"go-agent-manager/ruleengine"
+	"go-agent-manager/ruleset"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// GetDevices 获取所有设备
+// DeviceResponse 设备响应 DTO，在模型基础上附加计算得出的在线状态
+type DeviceResponse struct {
+	models.Device
+	Status string `json:"status"` // online / offline
+}
+
+// newDeviceResponse 根据 LastSeenAt 和离线阈值构造设备响应
+func newDeviceResponse(device models.Device, now time.Time) DeviceResponse {
+	status := models.DeviceStatusOffline
+	if now.Sub(device.LastSeenAt) <= config.AppConfig.DeviceOfflineThreshold {
+		status = models.DeviceStatusOnline
+	}
+	return DeviceResponse{Device: device, Status: status}
+}
+
+// GetDevices 分页获取设备列表 (limit / page_token，兼容 offset)
+// 支持 ?fields= 只返回指定字段；?capability= 只返回上报了该能力的设备，可重复指定 (需同时具备)；
+// ?status=online|offline 按 DEVICE_OFFLINE_THRESHOLD 计算的在线状态过滤；
+// ?tag=dept:finance 只返回带有该标签的设备，可重复指定 (需同时匹配)；
+// ?agent_version=1.2.3 只返回运行该版本 Agent 的设备，可重复指定 (匹配任一)；
+// ?q= 在 hostname、os、unique_hardware_id 中不区分大小写地模糊搜索 (任一字段包含即可)，可与以上过滤组合
 func GetDevices(c echo.Context) error {
+	page, err := parsePageParams(c)
+	if err != nil {
+		return err
+	}
+	fields, err := parseFields(c, deviceListFields)
+	if err != nil {
+		return err
+	}
+	query, err := applyDeviceFilters(c, db.DB)
+	if err != nil {
+		return err
+	}
 	var devices []models.Device
-	if result := db.DB.Find(&devices); result.Error != nil {
+	if result := page.apply(query).Find(&devices); result.Error != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
 	}
+	devices = paginate(c, page, devices, func(d models.Device) pageCursor {
+		return pageCursor{CreatedAt: d.CreatedAt, ID: d.ID}
+	})
+
+	now := time.Now()
+	resp := make([]DeviceResponse, 0, len(devices))
+	for _, d := range devices {
+		resp = append(resp, newDeviceResponse(d, now))
+	}
+	return sparseJSON(c, http.StatusOK, resp, fields)
+}
+
+// applyDeviceFilters 将设备列表的查询参数转换为查询条件
+func applyDeviceFilters(c echo.Context, query *gorm.DB) (*gorm.DB, error) {
+	if q := strings.TrimSpace(c.QueryParam("q")); q != "" {
+		pattern := "%" + escapeLike(q) + "%"
+		query = query.Where("(hostname ILIKE ? OR os ILIKE ? OR unique_hardware_id ILIKE ?)", pattern, pattern, pattern)
+	}
+	capabilities := c.QueryParams()["capability"]
+	if len(capabilities) > 0 {
+		for _, capability := range capabilities {
+			if strings.TrimSpace(capability) == "" {
+				return nil, apierror.New(http.StatusBadRequest, "invalid_capability", "capability must not be empty")
+			}
+		}
+		// jsonb 包含运算 (@>) 可以使用 idx_devices_capabilities GIN 索引
+		contains, err := json.Marshal(capabilities)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		query = query.Where("capabilities @> ?::jsonb", string(contains))
+	}
+	if tagFilters := c.QueryParams()["tag"]; len(tagFilters) > 0 {
+		tags := make(map[string]string, len(tagFilters))
+		for _, f := range tagFilters {
+			key, value, ok := strings.Cut(f, ":")
+			if !ok || strings.TrimSpace(key) == "" {
+				return nil, apierror.New(http.StatusBadRequest, "invalid_tag", "tag must be in key:value form, e.g. dept:finance")
+			}
+			if existing, dup := tags[key]; dup && existing != value {
+				// 同一个键不可能同时等于两个值，直接返回空结果
+				return query.Where("1 = 0"), nil
+			}
+			tags[key] = value
+		}
+		contains, err := json.Marshal(tags)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		query = query.Where("tags @> ?::jsonb", string(contains))
+	}
+	if versions := c.QueryParams()["agent_version"]; len(versions) > 0 {
+		for i, v := range versions {
+			versions[i] = strings.TrimSpace(v)
+			if versions[i] == "" {
+				return nil, apierror.New(http.StatusBadRequest, "invalid_agent_version", "agent_version must not be empty")
+			}
+		}
+		query = query.Where("agent_version IN ?", versions)
+	}
+	if status := c.QueryParam("status"); status != "" {
+		// 与 newDeviceResponse 使用同一阈值，走 idx_devices_last_seen_at 索引
+		since := time.Now().Add(-config.AppConfig.DeviceOfflineThreshold)
+		switch status {
+		case models.DeviceStatusOnline:
+			query = query.Where("last_seen_at >= ?", since)
+		case models.DeviceStatusOffline:
+			query = query.Where("last_seen_at < ?", since)
+		default:
+			return nil, apierror.New(http.StatusBadRequest, "invalid_status", "status must be online or offline")
+		}
+	}
+	return query, nil
+}
+
+// likeEscaper 转义 LIKE 模式中的通配符，使搜索词按字面匹配
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike 转义用户输入中的 %、_ 和 \ (Postgres LIKE 默认转义字符为 \)
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// maxBatchGetDevices 单次批量查询的最大设备 ID 数量
+const maxBatchGetDevices = 500
+
+// BatchGetDevicesResponse 批量查询设备的响应
+type BatchGetDevicesResponse struct {
+	Devices  []DeviceResponse `json:"devices"`
+	NotFound []string         `json:"not_found"` // 不存在 (或已删除) 的设备 ID，按请求顺序
+}
+
+// BatchGetDevices 根据一组 ID 批量获取设备
+// 请求体: {"ids": ["...", "..."]}；返回找到的设备及未找到的 ID 列表
+func BatchGetDevices(c echo.Context) error {
+	type BatchGetRequest struct {
+		IDs []string `json:"ids"`
+	}
+	req := new(BatchGetRequest)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	if len(req.IDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "ids is required")
+	}
+	if len(req.IDs) > maxBatchGetDevices {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("At most %d ids are allowed per request", maxBatchGetDevices))
+	}
+
+	// 去重并过滤非法 ID，保持请求中的顺序
+	seen := make(map[string]bool, len(req.IDs))
+	var ids, valid []string
+	for _, id := range req.IDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		if isUUID(id) {
+			valid = append(valid, id)
+		}
+	}
+
+	var devices []models.Device
+	if len(valid) > 0 {
+		if result := db.DB.Where("id IN ?", valid).Find(&devices); result.Error != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+		}
+	}
+	byID := make(map[string]models.Device, len(devices))
+	for _, d := range devices {
+		byID[strings.ToLower(d.ID)] = d
+	}
+
+	now := time.Now()
+	resp := BatchGetDevicesResponse{Devices: []DeviceResponse{}, NotFound: []string{}}
+	for _, id := range ids {
+		if d, ok := byID[strings.ToLower(id)]; ok {
+			resp.Devices = append(resp.Devices, newDeviceResponse(d, now))
+		} else {
+			resp.NotFound = append(resp.NotFound, id)
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetArchivedDevices 分页获取已归档的设备 (limit / page_token，按归档时间排序)
+func GetArchivedDevices(c echo.Context) error {
+	page, err := parsePageParams(c)
+	if err != nil {
+		return err
+	}
+	var devices []models.ArchivedDevice
+	if result := page.apply(db.DB).Find(&devices); result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	devices = paginate(c, page, devices, func(d models.ArchivedDevice) pageCursor {
+		return pageCursor{CreatedAt: d.CreatedAt, ID: d.ID}
+	})
+	return c.JSON(http.StatusOK, devices)
+}
+
+// GetDeletedDevices 分页获取已软删除 (可恢复) 的设备
+func GetDeletedDevices(c echo.Context) error {
+	page, err := parsePageParams(c)
+	if err != nil {
+		return err
+	}
+	var devices []models.Device
+	query := db.DB.Unscoped().Where("deleted_at IS NOT NULL")
+	if result := page.apply(query).Find(&devices); result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	devices = paginate(c, page, devices, func(d models.Device) pageCursor {
+		return pageCursor{CreatedAt: d.CreatedAt, ID: d.ID}
+	})
 	return c.JSON(http.StatusOK, devices)
 }
 
+// RestoreDevice 恢复已软删除的设备 (清空 DeletedAt)
+// 只恢复设备本身: 以 force=true 删除时一并删除的绑定不会恢复 (其 ID 见 device.delete 审计记录)，需要重新绑定
+func RestoreDevice(c echo.Context) error {
+	id := c.Param("id")
+	if !isUUID(id) {
+		return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+	}
+	var device models.Device
+	if err := db.Primary().Unscoped().First(&device, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if !device.DeletedAt.Valid {
+		return apierror.New(http.StatusConflict, "device_not_deleted", "Device is not deleted")
+	}
+	deletedAt := device.DeletedAt.Time
+	result := db.DB.Unscoped().Model(&models.Device{}).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	if result.RowsAffected == 0 {
+		return apierror.New(http.StatusConflict, "device_not_deleted", "Device is not deleted")
+	}
+	device.DeletedAt = gorm.DeletedAt{}
+	recordAudit(c, "device.undelete", "device", id, map[string]interface{}{
+		"unique_hardware_id": device.UniqueHardwareID,
+		"deleted_at":         deletedAt,
+	})
+	return c.JSON(http.StatusOK, newDeviceResponse(device, time.Now()))
+}
+
+// GetDeviceByHardwareID 根据硬件 ID 查找设备
+// 硬件 ID 可能包含 "/" 等特殊字符，调用方需要对其进行 URL 编码
+func GetDeviceByHardwareID(c echo.Context) error {
+	hwid := c.Param("hwid")
+	// 仅当原始路径中存在转义字符时 Echo 才会返回未解码的参数，此时需要手动解码
+	if c.Request().URL.RawPath != "" {
+		unescaped, err := url.PathUnescape(hwid)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid hardware ID encoding")
+		}
+		hwid = unescaped
+	}
+
+	device, err := findDevice("unique_hardware_id = ?", hwid)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newDeviceResponse(*device, time.Now()))
+}
+
+// GetDevice 获取单个设备，附带计算得出的在线状态和标签
+// ID 不是合法 UUID 时同样返回 404，而不是把 Postgres 的类型错误暴露为 500
+func GetDevice(c echo.Context) error {
+	id := c.Param("id")
+	if !isUUID(id) {
+		return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+	}
+	device, err := findDevice("id = ?", id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newDeviceResponse(*device, time.Now()))
+}
+
+// DecommissionDevice 停用设备
+// 停用后的设备仍保留在库中 (列表中可见)，但单设备查询返回 410 Gone
+func DecommissionDevice(c echo.Context) error {
+	id := c.Param("id")
+	if !isUUID(id) {
+		return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+	}
+	device, err := findDevice("id = ?", id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if result := db.DB.Model(&models.Device{}).Where("id = ?", id).Update("decommissioned_at", now); result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	device.DecommissionedAt = &now
+	recordAudit(c, "device.decommission", "device", id, map[string]interface{}{
+		"unique_hardware_id": device.UniqueHardwareID,
+	})
+	return c.JSON(http.StatusOK, newDeviceResponse(*device, now))
+}
+
+// BlockDevice 封禁设备，封禁的设备不能被绑定，也不会通过 REQUIRE_BINDING_FOR_RULES 检查
+// 请求体: {"reason": "..."} (可选)
+func BlockDevice(c echo.Context) error {
+	type BlockRequest struct {
+		Reason string `json:"reason"`
+	}
+	id := c.Param("id")
+	if !isUUID(id) {
+		return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+	}
+	req := new(BlockRequest)
+	if c.Request().ContentLength != 0 { // 请求体可省略
+		if err := bindBody(c, req); err != nil {
+			return err
+		}
+	}
+	device, err := findDevice("id = ?", id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	result := db.DB.Model(&models.Device{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"blocked_at": now, "block_reason": req.Reason})
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	device.BlockedAt = &now
+	device.BlockReason = req.Reason
+	recordAudit(c, "device.block", "device", id, map[string]interface{}{"reason": req.Reason})
+	return c.JSON(http.StatusOK, newDeviceResponse(*device, now))
+}
+
+// UnblockDevice 解除设备封禁
+func UnblockDevice(c echo.Context) error {
+	id := c.Param("id")
+	if !isUUID(id) {
+		return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+	}
+	device, err := findDevice("id = ?", id)
+	if err != nil {
+		return err
+	}
+	result := db.DB.Model(&models.Device{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"blocked_at": nil, "block_reason": ""})
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	device.BlockedAt = nil
+	device.BlockReason = ""
+	recordAudit(c, "device.unblock", "device", id, nil)
+	return c.JSON(http.StatusOK, newDeviceResponse(*device, time.Now()))
+}
+
+// findDevice 查找单个设备，并按生命周期状态返回不同的错误:
+//   - 从未存在或已物理删除: 404 device_not_found
+//   - 已归档 (长期未上报): 404 device_archived
+//   - 已软删除 (可恢复): 404 device_deleted
+//   - 已停用: 410 device_decommissioned
+func findDevice(query string, args ...interface{}) (*models.Device, error) {
+	var device models.Device
+	// 先读主库，避免刚停用/删除的设备因副本延迟返回旧状态
+	if err := db.Primary().Unscoped().Where(query, args...).First(&device).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			var archived int64
+			db.DB.Model(&models.ArchivedDevice{}).Where(query, args...).Count(&archived)
+			if archived > 0 {
+				return nil, apierror.New(http.StatusNotFound, "device_archived",
+					"Device has been archived; see /api/admin/devices/archived")
+			}
+			return nil, apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if device.DeletedAt.Valid {
+		return nil, apierror.New(http.StatusNotFound, "device_deleted", "Device has been deleted")
+	}
+	if device.DecommissionedAt != nil {
+		return nil, apierror.New(http.StatusGone, "device_decommissioned", "Device has been decommissioned").
+			WithDetails(map[string]interface{}{"decommissioned_at": device.DecommissionedAt})
+	}
+	return &device, nil
+}
+
+// DuplicateHardwareRecord 重复硬件 ID 报告中的单条设备记录
+type DuplicateHardwareRecord struct {
+	ID               string     `json:"id"`
+	UniqueHardwareID string     `json:"unique_hardware_id"`
+	Hostname         string     `json:"hostname"`
+	OS               string     `json:"os"`
+	LastSeenAt       time.Time  `json:"last_seen_at"`
+	State            string     `json:"state"` // active / decommissioned / deleted / archived
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+	DecommissionedAt *time.Time `json:"decommissioned_at,omitempty"`
+	ArchivedAt       *time.Time `json:"archived_at,omitempty"`
+	createdAt        time.Time  // 设备最初注册时间，组内按此排序
+}
+
+// DuplicateHardwareGroup 归一化后相同的硬件 ID 及其所有记录
+type DuplicateHardwareGroup struct {
+	HardwareID string                    `json:"hardware_id"`
+	Records    []DuplicateHardwareRecord `json:"records"`
+}
+
+// normalizedHardwareID 重复检测使用的硬件 ID 归一化表达式，与 normalizeHardwareID 保持一致
+const normalizedHardwareID = "LOWER(TRIM(unique_hardware_id))"
+
+// normalizeHardwareID 去除首尾空白并转为小写
+func normalizeHardwareID(id string) string {
+	return strings.ToLower(strings.TrimSpace(id))
+}
+
+// GetDuplicateHardwareDevices 报告在多条记录中出现的硬件 ID (包括已软删除、已停用和已归档的记录)
+// 硬件 ID 按去除首尾空白并忽略大小写后比较，用于发现硬件回收后重新注册等情况
+func GetDuplicateHardwareDevices(c echo.Context) error {
+	var keys []string
+	err := db.DB.Raw(`SELECT key FROM (
+			SELECT ` + normalizedHardwareID + ` AS key FROM devices
+			UNION ALL
+			SELECT ` + normalizedHardwareID + ` AS key FROM archived_devices
+		) ids GROUP BY key HAVING COUNT(*) > 1`).Scan(&keys).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if len(keys) == 0 {
+		return c.JSON(http.StatusOK, []DuplicateHardwareGroup{})
+	}
+
+	var devices []models.Device
+	if err := db.DB.Unscoped().Where(normalizedHardwareID+" IN ?", keys).Find(&devices).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	var archived []models.ArchivedDevice
+	if err := db.DB.Where(normalizedHardwareID+" IN ?", keys).Find(&archived).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	records := make([]DuplicateHardwareRecord, 0, len(devices)+len(archived))
+	for _, d := range devices {
+		records = append(records, duplicateRecord(d))
+	}
+	for _, a := range archived {
+		records = append(records, archivedDuplicateRecord(a))
+	}
+	return c.JSON(http.StatusOK, groupDuplicateRecords(records))
+}
+
+// duplicateRecord 将热表中的设备转换为报告记录，状态优先级: deleted > decommissioned > active
+func duplicateRecord(d models.Device) DuplicateHardwareRecord {
+	record := DuplicateHardwareRecord{
+		ID:               d.ID,
+		UniqueHardwareID: d.UniqueHardwareID,
+		Hostname:         d.Hostname,
+		OS:               d.OS,
+		LastSeenAt:       d.LastSeenAt,
+		State:            "active",
+		DecommissionedAt: d.DecommissionedAt,
+		createdAt:        d.CreatedAt,
+	}
+	if d.DecommissionedAt != nil {
+		record.State = "decommissioned"
+	}
+	if d.DeletedAt.Valid {
+		deletedAt := d.DeletedAt.Time
+		record.State = "deleted"
+		record.DeletedAt = &deletedAt
+	}
+	return record
+}
+
+// archivedDuplicateRecord 将归档设备转换为报告记录
+func archivedDuplicateRecord(a models.ArchivedDevice) DuplicateHardwareRecord {
+	archivedAt := a.CreatedAt
+	return DuplicateHardwareRecord{
+		ID:               a.ID,
+		UniqueHardwareID: a.UniqueHardwareID,
+		Hostname:         a.Hostname,
+		OS:               a.OS,
+		LastSeenAt:       a.LastSeenAt,
+		State:            "archived",
+		DecommissionedAt: a.DecommissionedAt,
+		ArchivedAt:       &archivedAt,
+		createdAt:        a.DeviceCreatedAt,
+	}
+}
+
+// groupDuplicateRecords 按归一化的硬件 ID 分组，组按硬件 ID 排序，组内按设备注册时间排序
+func groupDuplicateRecords(records []DuplicateHardwareRecord) []DuplicateHardwareGroup {
+	sort.SliceStable(records, func(i, j int) bool {
+		ki, kj := normalizeHardwareID(records[i].UniqueHardwareID), normalizeHardwareID(records[j].UniqueHardwareID)
+		if ki != kj {
+			return ki < kj
+		}
+		return records[i].createdAt.Before(records[j].createdAt)
+	})
+	groups := make([]DuplicateHardwareGroup, 0)
+	for _, r := range records {
+		key := normalizeHardwareID(r.UniqueHardwareID)
+		if n := len(groups); n == 0 || groups[n-1].HardwareID != key {
+			groups = append(groups, DuplicateHardwareGroup{HardwareID: key})
+		}
+		groups[len(groups)-1].Records = append(groups[len(groups)-1].Records, r)
+	}
+	return groups
+}
+
+// TimelineEntry 设备时间线中的一条审计事件
+type TimelineEntry struct {
+	ID            string                 `json:"id"`
+	At            time.Time              `json:"at"`
+	Action        string                 `json:"action"`
+	ActorID       string                 `json:"actor_id"`
+	ActorUsername string                 `json:"actor_username,omitempty"`
+	ResourceType  string                 `json:"resource_type"`
+	ResourceID    string                 `json:"resource_id"`
+	Details       map[string]interface{} `json:"details"`
+}
+
+// GetDeviceTimeline 按时间顺序返回与设备相关的全部审计事件 (设备自身及其绑定的变更)
+// 已删除的设备同样可以查询
+func GetDeviceTimeline(c echo.Context) error {
+	id := c.Param("id")
+	if !isUUID(id) {
+		return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+	}
+	var device models.Device
+	if err := db.DB.Unscoped().First(&device, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// 包括已解绑 (软删除) 的绑定
+	var bindingIDs []string
+	if err := db.DB.Unscoped().Model(&models.UserDeviceBinding{}).Where("device_id = ?", id).Pluck("id", &bindingIDs).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// 绑定相关的审计记录在 details 中带有 device_id，可以覆盖已被物理删除的绑定
+	query := db.DB.Where("(resource_type = ? AND resource_id = ?) OR details->>'device_id' = ?", "device", id, id)
+	if len(bindingIDs) > 0 {
+		query = query.Or("resource_type = ? AND resource_id IN ?", "binding", bindingIDs)
+	}
+	var logs []models.AuditLog
+	if err := query.Order("created_at ASC").Find(&logs).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	actorIDs := make([]string, 0)
+	seen := map[string]bool{}
+	for _, l := range logs {
+		if l.ActorID != "" && !seen[l.ActorID] {
+			seen[l.ActorID] = true
+			actorIDs = append(actorIDs, l.ActorID)
+		}
+	}
+	usernames := keycloak.ResolveUsernames(c.Request().Context(), actorIDs)
+
+	timeline := make([]TimelineEntry, 0, len(logs))
+	for _, l := range logs {
+		timeline = append(timeline, TimelineEntry{
+			ID:            l.ID,
+			At:            l.CreatedAt,
+			Action:        l.Action,
+			ActorID:       l.ActorID,
+			ActorUsername: usernames[l.ActorID],
+			ResourceType:  l.ResourceType,
+			ResourceID:    l.ResourceID,
+			Details:       l.Details,
+		})
+	}
+	return c.JSON(http.StatusOK, timeline)
+}
+
+// maxSimulationRequests 单次模拟允许的最大请求数
+const maxSimulationRequests = 100
+
+// SimulateDeviceTraffic 针对设备的生效规则批量模拟请求，返回每个请求命中的规则和动作
+func SimulateDeviceTraffic(c echo.Context) error {
+	id := c.Param("id")
+	type SimulatedRequest struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	type SimulateRequest struct {
+		Requests []SimulatedRequest `json:"requests"`
+	}
+	type SimulationResult struct {
+		Host    string            `json:"host"`
+		Port    int               `json:"port"`
+		Matched bool              `json:"matched"`
+		Rule    *models.AgentRule `json:"rule"`
+		Action  string            `json:"action"`
+	}
+
+	req := new(SimulateRequest)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	if len(req.Requests) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "requests must not be empty")
+	}
+	if len(req.Requests) > maxSimulationRequests {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("At most %d requests can be simulated at once", maxSimulationRequests))
+	}
+
+	if !isUUID(id) {
+		return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+	}
+	var device models.Device
+	if err := db.DB.First(&device, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	rules, err := ruleset.Rules(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	// 只模拟设备实际会收到的规则 (排除设备不具备所需能力的规则)
+	supported := rules[:0]
+	for _, r := range rules {
+		if ruleset.Supports(r, device.Capabilities) {
+			supported = append(supported, r)
+		}
+	}
+	rules = supported
+
+	now := time.Now()
+	results := make([]SimulationResult, 0, len(req.Requests))
+	for i, r := range req.Requests {
+		if r.Host == "" || r.Port < 0 || r.Port > 65535 {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("requests[%d] must have a host and a port between 0 and 65535", i))
+		}
+		input := r.Host
+		if r.Port > 0 {
+			input = net.JoinHostPort(r.Host, strconv.Itoa(r.Port))
+		}
+		res := SimulationResult{Host: r.Host, Port: r.Port, Action: ruleengine.DefaultAction}
+		rule, err := ruleengine.EvaluateAt(rules, input, now)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("requests[%d]: %v", i, err))
+		}
+		if rule != nil {
+			res.Matched = true
+			res.Action = rule.Action
+			agentRule := ruleset.ToAgentRule(*rule)
+			res.Rule = &agentRule
+		}
+		results = append(results, res)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"device_id": device.ID,
+		"results":   results,
+	})
+}
+
 // CreateDevice 创建新设备 (通常由 Agent 上报)
 func CreateDevice(c echo.Context) error {
 	device := new(models.Device)
-	if err := c.Bind(device); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	if err := bindBody(c, device); err != nil {
+		return err
 	}
 	// 假设 UniqueHardwareID 是 Agent 提供的，其他由后端填充
+	// CreateDevice 属于 Agent 上报路径，允许按配置截断超长字段
+	if err := normalizeDeviceFields(c, device, true); err != nil {
+		return err
+	}
+	if err := validateDeviceTags(device.Tags); err != nil {
+		return err
+	}
 	device.ID = "" // 让 GORM 自动生成 UUID
 	device.LastSeenAt = time.Now()
 
+	// 已归档的设备重新注册时恢复原记录 (保留设备 ID 和绑定)
+	restored, err := archive.Restore(c.Request().Context(), device.UniqueHardwareID, *device)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if restored != nil {
+		recordAudit(c, "device.restore", "device", restored.ID, map[string]interface{}{
+			"unique_hardware_id": restored.UniqueHardwareID,
+			"hostname":           restored.Hostname,
+		})
+		return c.JSON(http.StatusCreated, restored)
+	}
+
+	// 先检查硬件 ID 是否已注册，避免把数据库约束错误暴露给调用方
+	if err := checkHardwareIDAvailable(device.UniqueHardwareID); err != nil {
+		return err
+	}
+
 	if result := db.DB.Create(&device); result.Error != nil {
+		// 检查与插入之间并发注册了同一硬件 ID 时，后提交的请求在唯一索引上冲突，同样返回 409 device_exists
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			if err := checkHardwareIDAvailable(device.UniqueHardwareID); err != nil {
+				return err
+			}
+			return apierror.New(http.StatusConflict, "device_exists", "A device with this unique_hardware_id already exists")
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
 	}
+	recordAudit(c, "device.create", "device", device.ID, map[string]interface{}{
+		"unique_hardware_id": device.UniqueHardwareID,
+		"hostname":           device.Hostname,
+	})
 	return c.JSON(http.StatusCreated, device)
 }
 
+// checkHardwareIDAvailable 硬件 ID 已被注册时返回 409 device_exists (包括已删除的设备，唯一索引同样覆盖它们)
+func checkHardwareIDAvailable(hardwareID string) error {
+	var existing models.Device
+	err := db.Primary().Unscoped().Select("id", "deleted_at").
+		Where("unique_hardware_id = ?", hardwareID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return apierror.New(http.StatusConflict, "device_exists", "A device with this unique_hardware_id already exists").
+		WithDetails(map[string]interface{}{"device_id": existing.ID, "deleted": existing.DeletedAt.Valid})
+}
+
 // UpdateDevice 更新设备信息 (例如更新 LastSeenAt, 或修改其他属性)
 func UpdateDevice(c echo.Context) error {
 	id := c.Param("id")
+	if !isUUID(id) {
+		return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+	}
 	var device models.Device
-	if result := db.DB.First(&device, "id = ?", id); result.Error != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "Device not found")
+	err := db.Primary().First(&device, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
 	updates := new(models.Device)
-	if err := c.Bind(updates); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	if err := bindBody(c, updates); err != nil {
+		return err
 	}
 
 	// 只允许更新部分字段
+	if err := normalizeDeviceFields(c, updates, false); err != nil {
+		return err
+	}
+	changes := map[string]interface{}{}
+	if device.OS != updates.OS {
+		changes["os"] = map[string]string{"from": device.OS, "to": updates.OS}
+	}
+	if device.Hostname != updates.Hostname {
+		changes["hostname"] = map[string]string{"from": device.Hostname, "to": updates.Hostname}
+	}
 	device.OS = updates.OS
 	device.Hostname = updates.Hostname
+	if updates.AgentVersion != "" && updates.AgentVersion != device.AgentVersion {
+		// 未提供 agent_version 时保留原值
+		changes["agent_version"] = map[string]string{"from": device.AgentVersion, "to": updates.AgentVersion}
+		device.AgentVersion = updates.AgentVersion
+	}
+	if updates.Capabilities != nil {
+		// 未提供 capabilities 时保留原值，旧版 Agent 不会上报该字段
+		device.Capabilities = updates.Capabilities
+	}
+	if updates.Tags != nil {
+		// 未提供 tags 时保留原值；传入 {} 清空全部标签
+		if err := validateDeviceTags(updates.Tags); err != nil {
+			return err
+		}
+		changes["tags"] = map[string]interface{}{"from": device.Tags, "to": updates.Tags}
+		device.Tags = updates.Tags
+	}
 	device.LastSeenAt = time.Now() // 每次更新也更新最后在线时间
 
 	if result := db.DB.Save(&device); result.Error != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
 	}
+	recordAudit(c, "device.update", "device", device.ID, changes)
 	return c.JSON(http.StatusOK, device)
 }
 
-// DeleteDevice 删除设备
+// DeleteDevice 删除设备 (软删除，可通过 RestoreDevice 恢复)，设备不存在或已删除时返回 404
+// 设备仍有绑定时默认返回 409 并列出这些绑定，避免留下指向不存在设备的绑定；
+// ?force=true 时在同一事务中删除设备及其全部绑定，被删除的绑定 ID 记录在审计日志中
 func DeleteDevice(c echo.Context) error {
 	id := c.Param("id")
-	if result := db.DB.Delete(&models.Device{}, "id = ?", id); result.Error != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	if !isUUID(id) {
+		return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
 	}
+	force, err := parseOptionalBool(c.QueryParam("force"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "force must be true or false")
+	}
+
+	ctx := c.Request().Context()
+	var device models.Device
+	var deletedBindings []string
+	err = db.Primary().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&device, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return apierror.New(http.StatusNotFound, "device_not_found", "Device not found")
+			}
+			return err
+		}
+		var blocking []models.UserDeviceBinding
+		if err := tx.Where("device_id = ?", id).Order("created_at").Find(&blocking).Error; err != nil {
+			return err
+		}
+		if len(blocking) > 0 && !force {
+			summaries := make([]map[string]interface{}, 0, len(blocking))
+			for _, b := range blocking {
+				summaries = append(summaries, map[string]interface{}{
+					"id":               b.ID,
+					"keycloak_user_id": b.KeycloakUserID,
+					"status":           b.Status,
+				})
+			}
+			return apierror.New(http.StatusConflict, "device_has_bindings",
+				"Device still has bindings; unbind them first or delete with force=true").
+				WithDetails(map[string]interface{}{"bindings": summaries})
+		}
+		// 与单独解绑一样经由状态机，保留解绑状态和时间
+		for i := range blocking {
+			if err := bindings.Unbind(ctx, tx, &blocking[i]); err != nil {
+				return err
+			}
+			deletedBindings = append(deletedBindings, blocking[i].ID)
+		}
+		return tx.Delete(&models.Device{}, "id = ?", id).Error
+	})
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	if err != nil {
+		return bindingStatusError(err)
+	}
+
+	details := map[string]interface{}{"unique_hardware_id": device.UniqueHardwareID}
+	if len(deletedBindings) > 0 {
+		details["force"] = true
+		details["bindings_deleted"] = len(deletedBindings)
+		details["deleted_binding_ids"] = deletedBindings
+	}
+	recordAudit(c, "device.delete", "device", id, details)
 	return c.NoContent(http.StatusNoContent)
 }
+
+// 设备标签限制
+const (
+	maxDeviceTags        = 50
+	maxDeviceTagKeyLen   = 63
+	maxDeviceTagValueLen = 255
+)
+
+// validateDeviceTags 校验标签数量和键值长度；键不能为空且不能包含 ":" (与 ?tag=key:value 过滤语法冲突)
+func validateDeviceTags(tags map[string]string) error {
+	if len(tags) > maxDeviceTags {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("At most %d tags are allowed per device", maxDeviceTags))
+	}
+	for key, value := range tags {
+		switch {
+		case strings.TrimSpace(key) == "":
+			return echo.NewHTTPError(http.StatusBadRequest, "Tag keys must not be empty")
+		case strings.Contains(key, ":"):
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Tag key %q must not contain ':'", key))
+		case len([]rune(key)) > maxDeviceTagKeyLen:
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Tag key %q exceeds %d characters", key, maxDeviceTagKeyLen))
+		case len([]rune(value)) > maxDeviceTagValueLen:
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Value of tag %q exceeds %d characters", key, maxDeviceTagValueLen))
+		}
+	}
+	return nil
+}
+
+// normalizeDeviceFields 校验 Hostname/OS/AgentVersion 的长度
+// 超长时默认返回 400；allowTruncate 为 true (Agent 上报路径) 且开启 TRUNCATE_OVERSIZED 时截断并记录日志
+func normalizeDeviceFields(c echo.Context, device *models.Device, allowTruncate bool) error {
+	maxLen := config.AppConfig.DeviceFieldMaxLength
+	if maxLen <= 0 {
+		return nil
+	}
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"hostname", &device.Hostname},
+		{"os", &device.OS},
+		{"agent_version", &device.AgentVersion},
+	}
+	for _, f := range fields {
+		runes := []rune(*f.value)
+		if len(runes) <= maxLen {
+			continue
+		}
+		if !allowTruncate || !config.AppConfig.TruncateOversized {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("%s exceeds the maximum length of %d characters", f.name, maxLen))
+		}
+		logger.For(c).Warn("truncated oversized device field",
+			"field", f.name, "length", len(runes), "max_length", maxLen,
+			"unique_hardware_id", device.UniqueHardwareID)
+		*f.value = string(runes[:maxLen])
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/db"
+	"go-agent-manager/logger"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// deviceExportHeader CSV 导出的列
+var deviceExportHeader = []string{"id", "unique_hardware_id", "os", "hostname", "last_seen_at", "status"}
+
+// deviceExportFlushRows 每写出多少行刷新一次响应
+const deviceExportFlushRows = 500
+
+// ExportDevices 以 CSV 导出全部设备 (?format=csv，目前仅支持 csv)，支持与 GetDevices 相同的过滤参数
+// 逐行读取数据库游标并写出，不在内存中保存整个设备列表
+func ExportDevices(c echo.Context) error {
+	if format := c.QueryParam("format"); format != "" && format != "csv" {
+		return apierror.New(http.StatusBadRequest, "invalid_format", "format must be csv")
+	}
+	query, err := applyDeviceFilters(c, db.DB.WithContext(c.Request().Context()))
+	if err != nil {
+		return err
+	}
+	rows, err := query.Model(&models.Device{}).
+		Select("id", "unique_hardware_id", "os", "hostname", "last_seen_at").
+		Order("created_at, id").Rows()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer rows.Close()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	res.Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="devices-%s.csv"`, time.Now().UTC().Format("20060102-150405")))
+	res.WriteHeader(http.StatusOK)
+
+	// 响应头已发出，之后的错误只能记录日志并截断输出
+	w := csv.NewWriter(res)
+	if err := w.Write(deviceExportHeader); err != nil {
+		return nil
+	}
+	now := time.Now()
+	count := 0
+	for rows.Next() {
+		var d models.Device
+		if err := query.ScanRows(rows, &d); err != nil {
+			logger.For(c).Error("Device export aborted", "error", err, "rows", count)
+			break
+		}
+		device := newDeviceResponse(d, now)
+		record := []string{
+			device.ID,
+			csvSafe(device.UniqueHardwareID),
+			csvSafe(device.OS),
+			csvSafe(device.Hostname),
+			device.LastSeenAt.UTC().Format(time.RFC3339),
+			device.Status,
+		}
+		if err := w.Write(record); err != nil {
+			return nil // 客户端已断开
+		}
+		count++
+		if count%deviceExportFlushRows == 0 {
+			w.Flush()
+			res.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.For(c).Error("Device export aborted", "error", err, "rows", count)
+	}
+	w.Flush()
+	res.Flush()
+	return nil
+}
+
+// csvSafe 为以公式字符开头的字段加上单引号前缀，避免 Agent 上报的主机名等在电子表格中被当作公式执行
+func csvSafe(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func TestDeviceHandlersRejectNonUUID(t *testing.T) {
+	handlers := map[string]echo.HandlerFunc{
+		"GetDevice":          GetDevice,
+		"DecommissionDevice": DecommissionDevice,
+		"BlockDevice":        BlockDevice,
+		"UnblockDevice":      UnblockDevice,
+		"RestoreDevice":      RestoreDevice,
+		"DeleteDevice":       DeleteDevice,
+		"UpdateDevice":       UpdateDevice,
+		"GetDeviceTimeline":  GetDeviceTimeline,
+	}
+	for name, h := range handlers {
+		t.Run(name, func(t *testing.T) {
+			rec := serve(t, h, request{method: http.MethodPost, target: "/devices/not-a-uuid", params: map[string]string{"id": "not-a-uuid"}})
+			if rec.Code != http.StatusNotFound || errorCode(t, rec) != "device_not_found" {
+				t.Errorf("status %d, body %s; want 404 device_not_found", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestFindDeviceLifecycle(t *testing.T) {
+	openTestDB(t)
+	now := time.Now()
+
+	live := createTestDevice(t, "hw-live")
+	deleted := createTestDevice(t, "hw-deleted")
+	if err := db.DB.Delete(&deleted).Error; err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	decommissioned := createTestDevice(t, "hw-decommissioned", func(d *models.Device) { d.DecommissionedAt = &now })
+	archived := models.ArchivedDevice{ID: "0f3c2a4e-5b6d-4e7f-8a9b-0c1d2e3f4a5b", UniqueHardwareID: "hw-archived", LastSeenAt: now.AddDate(0, -6, 0)}
+	if err := db.DB.Create(&archived).Error; err != nil {
+		t.Fatalf("create archived device: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int // 0 表示应当找到设备
+		wantCode   string
+	}{
+		{"live", live.ID, 0, ""},
+		{"unknown", "9a8b7c6d-5e4f-4a3b-9c2d-1e0f9a8b7c6d", http.StatusNotFound, "device_not_found"},
+		{"archived", archived.ID, http.StatusNotFound, "device_archived"},
+		{"soft deleted", deleted.ID, http.StatusNotFound, "device_deleted"},
+		{"decommissioned", decommissioned.ID, http.StatusGone, "device_decommissioned"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, err := findDevice("id = ?", tt.id)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("findDevice: %v", err)
+				}
+				if device.ID != tt.id {
+					t.Errorf("found device %s, want %s", device.ID, tt.id)
+				}
+				return
+			}
+			var apiErr *apierror.Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("findDevice error = %v, want *apierror.Error", err)
+			}
+			if apiErr.Status != tt.wantStatus || apiErr.Code != tt.wantCode {
+				t.Errorf("findDevice error = %d %s, want %d %s", apiErr.Status, apiErr.Code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestNormalizeDeviceFields(t *testing.T) {
+	prev := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = prev })
+	config.AppConfig.DeviceFieldMaxLength = 5
+
+	tests := []struct {
+		name          string
+		truncate      bool // TRUNCATE_OVERSIZED
+		allowTruncate bool // 是否为 Agent 上报路径
+		device        models.Device
+		wantErr       bool
+		want          models.Device
+	}{
+		{"within limit", false, true,
+			models.Device{Hostname: "host1", OS: "linux", AgentVersion: "1.2.3"}, false,
+			models.Device{Hostname: "host1", OS: "linux", AgentVersion: "1.2.3"}},
+		{"oversized hostname rejected", false, true,
+			models.Device{Hostname: "host-12"}, true, models.Device{}},
+		{"oversized os rejected", false, true,
+			models.Device{OS: "windows"}, true, models.Device{}},
+		{"oversized agent_version rejected", false, true,
+			models.Device{AgentVersion: "1.2.3-rc1"}, true, models.Device{}},
+		{"truncated when enabled", true, true,
+			models.Device{Hostname: "host-12", OS: "windows", AgentVersion: "1.2"}, false,
+			models.Device{Hostname: "host-", OS: "windo", AgentVersion: "1.2"}},
+		{"truncation counts characters not bytes", true, true,
+			models.Device{Hostname: "主机名称很长"}, false,
+			models.Device{Hostname: "主机名称很"}},
+		{"multi-byte within limit", false, true,
+			models.Device{Hostname: "主机名称很"}, false,
+			models.Device{Hostname: "主机名称很"}},
+		{"admin updates never truncated", true, false,
+			models.Device{Hostname: "host-12"}, true, models.Device{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppConfig.TruncateOversized = tt.truncate
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+			device := tt.device
+			err := normalizeDeviceFields(c, &device, tt.allowTruncate)
+			if tt.wantErr {
+				var httpErr *echo.HTTPError
+				if !errors.As(err, &httpErr) || httpErr.Code != http.StatusBadRequest {
+					t.Fatalf("normalizeDeviceFields = %v, want 400", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeDeviceFields: %v", err)
+			}
+			if device.Hostname != tt.want.Hostname || device.OS != tt.want.OS || device.AgentVersion != tt.want.AgentVersion {
+				t.Errorf("fields = %q/%q/%q, want %q/%q/%q", device.Hostname, device.OS, device.AgentVersion,
+					tt.want.Hostname, tt.want.OS, tt.want.AgentVersion)
+			}
+		})
+	}
+}
+
+func TestNormalizeDeviceFieldsDisabled(t *testing.T) {
+	prev := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = prev })
+	config.AppConfig.DeviceFieldMaxLength = 0
+
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+	device := models.Device{Hostname: strings.Repeat("h", 1000)}
+	if err := normalizeDeviceFields(c, &device, false); err != nil {
+		t.Fatalf("normalizeDeviceFields with no limit: %v", err)
+	}
+	if len(device.Hostname) != 1000 {
+		t.Errorf("hostname length = %d, want unchanged 1000", len(device.Hostname))
+	}
+}
+
+func TestDuplicateRecordState(t *testing.T) {
+	now := time.Now()
+	deleted := gorm.DeletedAt{Time: now, Valid: true}
+	tests := []struct {
+		name   string
+		record DuplicateHardwareRecord
+		want   string
+	}{
+		{"active", duplicateRecord(models.Device{}), "active"},
+		{"decommissioned", duplicateRecord(models.Device{DecommissionedAt: &now}), "decommissioned"},
+		{"deleted", duplicateRecord(models.Device{Model: gorm.Model{DeletedAt: deleted}}), "deleted"},
+		{"deleted after decommission", duplicateRecord(models.Device{Model: gorm.Model{DeletedAt: deleted}, DecommissionedAt: &now}), "deleted"},
+		{"archived", archivedDuplicateRecord(models.ArchivedDevice{CreatedAt: now}), "archived"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.record.State != tt.want {
+				t.Errorf("State = %q, want %q", tt.record.State, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetDuplicateHardwareDevices(t *testing.T) {
+	openTestDB(t)
+	now := time.Now()
+	base := now.Add(-time.Hour)
+
+	// 同一硬件先注册、停用、归档，再以不同大小写和空白重新注册
+	decommissioned := createTestDevice(t, " HW-REUSED ", func(d *models.Device) {
+		d.CreatedAt = base
+		d.DecommissionedAt = &now
+	})
+	archived := models.ArchivedDevice{ID: "0f3c2a4e-5b6d-4e7f-8a9b-0c1d2e3f4a5b", UniqueHardwareID: "hw-reused ",
+		LastSeenAt: base, DeviceCreatedAt: base.Add(time.Minute)}
+	if err := db.DB.Create(&archived).Error; err != nil {
+		t.Fatalf("create archived device: %v", err)
+	}
+	active := createTestDevice(t, "hw-reused", func(d *models.Device) { d.CreatedAt = base.Add(2 * time.Minute) })
+	createTestDevice(t, "hw-unique")
+
+	rec := serve(t, GetDuplicateHardwareDevices, request{method: http.MethodGet, target: "/devices/duplicates"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	var groups []DuplicateHardwareGroup
+	decode(t, rec, &groups)
+	if len(groups) != 1 || groups[0].HardwareID != "hw-reused" {
+		t.Fatalf("groups = %+v, want a single hw-reused group", groups)
+	}
+	want := []struct{ id, state string }{
+		{decommissioned.ID, "decommissioned"},
+		{archived.ID, "archived"},
+		{active.ID, "active"},
+	}
+	records := groups[0].Records
+	if len(records) != len(want) {
+		t.Fatalf("%d records, want %d: %+v", len(records), len(want), records)
+	}
+	for i, w := range want {
+		if records[i].ID != w.id || records[i].State != w.state {
+			t.Errorf("record %d = %s/%s, want %s/%s", i, records[i].ID, records[i].State, w.id, w.state)
+		}
+	}
+	if records[1].ArchivedAt == nil {
+		t.Error("archived record has no archived_at")
+	}
+}
+
+func TestDeleteDevice(t *testing.T) {
+	openTestDB(t)
+
+	t.Run("unknown device", func(t *testing.T) {
+		id := "9a8b7c6d-5e4f-4a3b-9c2d-1e0f9a8b7c6d"
+		rec := serve(t, DeleteDevice, request{method: http.MethodDelete, target: "/devices/" + id, params: map[string]string{"id": id}})
+		if rec.Code != http.StatusNotFound || errorCode(t, rec) != "device_not_found" {
+			t.Errorf("status %d, body %s; want 404 device_not_found", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("already deleted", func(t *testing.T) {
+		device := createTestDevice(t, "hw-delete-twice")
+		params := map[string]string{"id": device.ID}
+		if rec := serve(t, DeleteDevice, request{method: http.MethodDelete, target: "/devices/" + device.ID, params: params}); rec.Code != http.StatusNoContent {
+			t.Fatalf("first delete: status %d, body %s", rec.Code, rec.Body)
+		}
+		rec := serve(t, DeleteDevice, request{method: http.MethodDelete, target: "/devices/" + device.ID, params: params})
+		if rec.Code != http.StatusNotFound || errorCode(t, rec) != "device_not_found" {
+			t.Errorf("second delete: status %d, body %s; want 404 device_not_found", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("force records deleted bindings", func(t *testing.T) {
+		device := createTestDevice(t, "hw-delete-force")
+		var bindingIDs []string
+		for _, user := range []string{"user-1", "user-2"} {
+			binding := models.UserDeviceBinding{KeycloakUserID: user, DeviceID: device.ID, Status: models.BindingStatusActive, BoundAt: time.Now()}
+			if err := db.DB.Create(&binding).Error; err != nil {
+				t.Fatalf("create binding: %v", err)
+			}
+			bindingIDs = append(bindingIDs, binding.ID)
+		}
+		params := map[string]string{"id": device.ID}
+
+		rec := serve(t, DeleteDevice, request{method: http.MethodDelete, target: "/devices/" + device.ID, params: params})
+		if rec.Code != http.StatusConflict || errorCode(t, rec) != "device_has_bindings" {
+			t.Fatalf("delete without force: status %d, body %s; want 409 device_has_bindings", rec.Code, rec.Body)
+		}
+
+		rec = serve(t, DeleteDevice, request{method: http.MethodDelete, target: "/devices/" + device.ID + "?force=true", params: params})
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("force delete: status %d, body %s", rec.Code, rec.Body)
+		}
+		var remaining int64
+		db.DB.Model(&models.UserDeviceBinding{}).Where("device_id = ?", device.ID).Count(&remaining)
+		if remaining != 0 {
+			t.Errorf("%d bindings left after force delete, want 0", remaining)
+		}
+		var unbound []models.UserDeviceBinding
+		if err := db.DB.Unscoped().Where("device_id = ?", device.ID).Find(&unbound).Error; err != nil {
+			t.Fatalf("load deleted bindings: %v", err)
+		}
+		for _, b := range unbound {
+			if b.Status != models.BindingStatusInactive || b.UnboundAt == nil {
+				t.Errorf("binding %s: status %s, unbound_at %v; want inactive with unbound_at", b.ID, b.Status, b.UnboundAt)
+			}
+		}
+
+		var entry models.AuditLog
+		if err := db.DB.Where("action = ? AND resource_id = ?", "device.delete", device.ID).First(&entry).Error; err != nil {
+			t.Fatalf("load audit entry: %v", err)
+		}
+		got, _ := entry.Details["deleted_binding_ids"].([]interface{})
+		if len(got) != len(bindingIDs) {
+			t.Fatalf("deleted_binding_ids = %v, want %v", entry.Details["deleted_binding_ids"], bindingIDs)
+		}
+		for i, id := range bindingIDs {
+			if got[i] != id {
+				t.Errorf("deleted_binding_ids[%d] = %v, want %s", i, got[i], id)
+			}
+		}
+	})
+}
+
+func TestCreateDeviceConcurrentDuplicates(t *testing.T) {
+	openTestDB(t)
+
+	const attempts = 6
+	statuses := make([]int, attempts)
+	codes := make([]string, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := `{"unique_hardware_id": "hw-concurrent-create", "hostname": "host", "os": "linux"}`
+			rec := serve(t, CreateDevice, request{method: http.MethodPost, target: "/devices", body: body})
+			statuses[i], codes[i] = rec.Code, errorCode(t, rec)
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for i := 0; i < attempts; i++ {
+		switch {
+		case statuses[i] == http.StatusCreated:
+			created++
+		case statuses[i] == http.StatusConflict && codes[i] == "device_exists":
+		default:
+			t.Errorf("attempt %d: status %d code %q, want 201 or 409 device_exists", i, statuses[i], codes[i])
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d devices created, want exactly 1", created)
+	}
+}
+
+func TestDeviceHandlersEnforceFieldLength(t *testing.T) {
+	prev := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = prev })
+	config.AppConfig.DeviceFieldMaxLength = 8
+	config.AppConfig.TruncateOversized = false
+
+	// 截断关闭时，注册接口在写库之前拒绝超长字段
+	body := `{"unique_hardware_id": "hw-oversized", "hostname": "very-long-hostname", "os": "linux"}`
+	rec := serve(t, CreateDevice, request{method: http.MethodPost, target: "/devices", body: body})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("CreateDevice with oversized hostname: status %d, body %s; want 400", rec.Code, rec.Body)
+	}
+	rec = serve(t, DeviceHeartbeat, request{method: http.MethodPost, target: "/devices/heartbeat", body: body})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("DeviceHeartbeat with oversized hostname: status %d, body %s; want 400", rec.Code, rec.Body)
+	}
+}
+
+func TestHeartbeatTruncatesOversizedFields(t *testing.T) {
+	openTestDB(t)
+	config.AppConfig.DeviceFieldMaxLength = 8
+	config.AppConfig.TruncateOversized = true
+	t.Cleanup(func() { config.AppConfig.TruncateOversized = false })
+
+	resp := heartbeat(t, "hw-truncate", "very-long-hostname", "1.0.0")
+	var device models.Device
+	if err := db.DB.First(&device, "id = ?", resp.DeviceID).Error; err != nil {
+		t.Fatalf("load device: %v", err)
+	}
+	if device.Hostname != "very-lon" {
+		t.Errorf("hostname = %q, want truncated to very-lon", device.Hostname)
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 列表接口 ?fields= 可选择的字段
+var (
+	deviceListFields = []string{
+		"id", "unique_hardware_id", "os", "hostname", "agent_version", "last_seen_at", "decommissioned_at", "capabilities",
+		"blocked_at", "block_reason", "tags", "status",
+	}
+	ruleListFields = []string{
+		"id", "name", "type", "match", "action", "enabled", "priority", "description", "active_schedule", "required_capabilities",
+	}
+	bindingListFields = []string{
+		"id", "keycloak_user_id", "device_id", "status", "bound_at", "unbound_at", "expires_at", "device_hostname",
+	}
+)
+
+// parseFields 解析 ?fields=id,hostname,status 参数，返回 nil 表示输出全部字段
+// 不在 allowed 中的字段返回 400
+func parseFields(c echo.Context, allowed []string) ([]string, error) {
+	raw := c.QueryParam("fields")
+	if raw == "" {
+		return nil, nil
+	}
+	valid := make(map[string]bool, len(allowed))
+	for _, f := range allowed {
+		valid[f] = true
+	}
+	var fields []string
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !valid[f] {
+			return nil, echo.NewHTTPError(http.StatusBadRequest,
+				"Unknown field "+f+"; allowed fields: "+strings.Join(allowed, ","))
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "fields must list at least one field")
+	}
+	return fields, nil
+}
+
+// sparseJSON 输出列表的 JSON，fields 不为空时每个元素只保留指定字段
+func sparseJSON(c echo.Context, status int, items interface{}, fields []string) error {
+	if fields == nil {
+		return c.JSON(status, items)
+	}
+	raw, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &objects); err != nil {
+		return err
+	}
+	filtered := make([]map[string]json.RawMessage, 0, len(objects))
+	for _, obj := range objects {
+		out := make(map[string]json.RawMessage, len(fields))
+		for _, f := range fields {
+			if v, ok := obj[f]; ok {
+				out[f] = v
+			}
+		}
+		filtered = append(filtered, out)
+	}
+	return c.JSON(status, filtered)
+}
@@ -1,37 +1,39 @@
 package handlers
 
 import (
-	"log"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
-
-	"go-agent-manager/config"
+	"strings"
+	"sync"
 
 	"github.com/labstack/echo/v4"
 )
 
 // ServeFrontend 托管前端静态文件
-func ServeFrontend() echo.HandlerFunc {
-	staticPath := config.AppConfig.FrontendStaticPath
-	log.Printf("Serving frontend static files from: %s", staticPath)
-
+// fsys 以前端构建产物 (dist 目录) 为根，可以是磁盘目录 (os.DirFS) 或编译时内嵌的文件
+func ServeFrontend(fsys fs.FS) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		reqPath := c.Request().URL.Path
-
-		// 构建文件系统路径
-		fsPath := filepath.Join(staticPath, reqPath)
+		// 转换为 fs.FS 使用的相对路径，Clean 之后不会再包含 ".."
+		name := strings.TrimPrefix(path.Clean("/"+c.Request().URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
 
 		// 检查文件是否存在
-		_, err := os.Stat(fsPath)
-		if err == nil { // 文件存在，直接提供服务
-			return c.File(fsPath)
+		_, err := fs.Stat(fsys, name)
+		if err == nil { // 文件存在，直接提供服务 (目录会提供其中的 index.html)
+			return serveFile(c, fsys, name)
 		}
 
-		// 如果文件不存在，但请求的不是根路径，则尝试提供 index.html (适用于 SPA 的 History 模式)
-		if os.IsNotExist(err) && !isFilePath(reqPath) {
-			indexPath := filepath.Join(staticPath, "index.html")
-			return c.File(indexPath)
+		// 如果文件不存在，但请求的不是文件路径，则尝试提供 index.html (适用于 SPA 的 History 模式)
+		if errors.Is(err, fs.ErrNotExist) && !isFilePath(name) {
+			return serveFile(c, fsys, "index.html")
 		}
 
 		// 其他情况，文件不存在，返回 404
@@ -39,6 +41,74 @@ func ServeFrontend() echo.HandlerFunc {
 	}
 }
 
+// serveFile 从 fsys 提供单个文件，目录提供其中的 index.html
+// 通过 http.ServeContent 输出，支持 Range 和 If-Modified-Since
+func serveFile(c echo.Context, fsys fs.FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "File not found")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if fi.IsDir() {
+		return serveFile(c, fsys, path.Join(name, "index.html"))
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Static file is not seekable")
+	}
+	etag, err := staticETag(name, fi, content)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	header := c.Response().Header()
+	header.Set("Cache-Control", staticCacheControl(name))
+	header.Set("ETag", etag)
+	// ServeContent 根据上面的 ETag 处理 If-None-Match，匹配时返回 304
+	http.ServeContent(c.Response(), c.Request(), fi.Name(), fi.ModTime(), content)
+	return nil
+}
+
+// fingerprintedAssetsDir 构建工具输出带内容哈希文件名的目录，其中的文件内容永远不变
+const fingerprintedAssetsDir = "assets/"
+
+// staticCacheControl 带指纹的资源长期缓存；index.html 等入口文件每次都向服务端确认，保证发布后立即生效
+func staticCacheControl(name string) string {
+	if strings.HasPrefix(name, fingerprintedAssetsDir) {
+		return "public, max-age=31536000, immutable"
+	}
+	return "no-cache"
+}
+
+// embeddedETags 内嵌文件没有修改时间，按内容计算的 ETag 缓存在这里 (内嵌文件不会变化)
+var embeddedETags sync.Map
+
+// staticETag 根据文件修改时间和大小生成 ETag
+// 内嵌文件的修改时间为零值，改为对内容取哈希，避免大小相同的新旧版本使用同一个 ETag
+func staticETag(name string, fi fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !fi.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()), nil
+	}
+	if etag, ok := embeddedETags.Load(name); ok {
+		return etag.(string), nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+	embeddedETags.Store(name, etag)
+	return etag, nil
+}
+
 // isFilePath 辅助函数，检查路径是否看起来像一个文件路径 (包含扩展名)
 func isFilePath(path string) bool {
 	return len(filepath.Ext(path)) > 0
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go-agent-manager/db"
+	"go-agent-manager/keycloak"
+
+	"github.com/labstack/echo/v4"
+)
+
+// readinessCheckTimeout 单项就绪检查的超时时间
+const readinessCheckTimeout = 5 * time.Second
+
+// DependencyStatus 单个依赖的检查结果
+type DependencyStatus struct {
+	Status string `json:"status"` // ok / error
+	Error  string `json:"error,omitempty"`
+}
+
+// Healthz 存活检查: 进程能处理请求即返回 200，不检查任何依赖
+func Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz 就绪检查: 数据库可连通且能获取 Keycloak 管理员 token 时返回 200，否则返回 503
+// 响应中列出每个依赖的检查结果，便于定位是哪一项失败
+func Readyz(c echo.Context) error {
+	checks := map[string]DependencyStatus{
+		"database": dependencyStatus(pingDatabase(c.Request().Context())),
+		"keycloak": dependencyStatus(keycloak.CheckAdminToken()),
+	}
+
+	status, code := "ok", http.StatusOK
+	for _, check := range checks {
+		if check.Status != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+			break
+		}
+	}
+	return c.JSON(code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// pingDatabase 检查数据库连接池能否连通主库
+func pingDatabase(parent context.Context) error {
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(parent, readinessCheckTimeout)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
+
+// dependencyStatus 把检查错误转换为响应中的状态
+func dependencyStatus(err error) DependencyStatus {
+	if err != nil {
+		return DependencyStatus{Status: "error", Error: err.Error()}
+	}
+	return DependencyStatus{Status: "ok"}
+}
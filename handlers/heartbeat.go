@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/archive"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/events"
+	"go-agent-manager/middleware"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HeartbeatRequest Agent 心跳请求体
+type HeartbeatRequest struct {
+	UniqueHardwareID string `json:"unique_hardware_id"`
+	OS               string `json:"os"`
+	Hostname         string `json:"hostname"`
+	AgentVersion     string `json:"agent_version"` // 旧版 Agent 不上报，此时保留原值
+}
+
+// HeartbeatResponse Agent 心跳响应，Agent 应缓存 device_id 供后续请求使用
+type HeartbeatResponse struct {
+	DeviceID string `json:"device_id"`
+	Created  bool   `json:"created"` // 本次心跳是否新建了设备
+}
+
+// DeviceHeartbeat 处理 POST /api/devices/heartbeat (Agent Key 认证)
+// 按 UniqueHardwareID 定位设备: 已存在则刷新 OS、主机名、Agent 版本和 LastSeenAt；不存在则新建 (已归档的设备会被恢复)
+func DeviceHeartbeat(c echo.Context) error {
+	req := new(HeartbeatRequest)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	req.UniqueHardwareID = strings.TrimSpace(req.UniqueHardwareID)
+	if req.UniqueHardwareID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "unique_hardware_id is required")
+	}
+	device := models.Device{
+		UniqueHardwareID: req.UniqueHardwareID,
+		OS:               req.OS,
+		Hostname:         req.Hostname,
+		AgentVersion:     strings.TrimSpace(req.AgentVersion),
+		LastSeenAt:       time.Now(),
+	}
+	// 心跳属于 Agent 上报路径，允许按配置截断超长字段
+	if err := normalizeDeviceFields(c, &device, true); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	var existing models.Device
+	err := db.Primary().WithContext(ctx).Unscoped().Select("id", "last_seen_at", "deleted_at", "decommissioned_at").
+		First(&existing, "unique_hardware_id = ?", device.UniqueHardwareID).Error
+	switch {
+	case err == nil:
+		if err := checkAgentOwnsDevice(c, existing.ID); err != nil {
+			return err
+		}
+		if existing.DeletedAt.Valid {
+			return apierror.New(http.StatusNotFound, "device_deleted", "Device has been deleted")
+		}
+		if existing.DecommissionedAt != nil {
+			return apierror.New(http.StatusGone, "device_decommissioned", "Device has been decommissioned").
+				WithDetails(map[string]interface{}{"decommissioned_at": existing.DecommissionedAt})
+		}
+		updates := map[string]interface{}{"os": device.OS, "hostname": device.Hostname, "last_seen_at": device.LastSeenAt}
+		if device.AgentVersion != "" {
+			updates["agent_version"] = device.AgentVersion
+		}
+		result := db.DB.WithContext(ctx).Model(&models.Device{}).Where("id = ?", existing.ID).Updates(updates)
+		if result.Error != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+		}
+		if device.LastSeenAt.Sub(existing.LastSeenAt) > config.AppConfig.DeviceOfflineThreshold {
+			publishDeviceEvent(events.DeviceOnline, existing.ID, device)
+		}
+		publishDeviceEvent(events.DeviceHeartbeat, existing.ID, device)
+		return c.JSON(http.StatusOK, HeartbeatResponse{DeviceID: existing.ID})
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// 新设备: 绑定了设备的 Agent Key 不能注册其他设备
+	if err := checkAgentOwnsDevice(c, ""); err != nil {
+		return err
+	}
+	restored, err := archive.Restore(ctx, device.UniqueHardwareID, device)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if restored != nil {
+		recordAudit(c, "device.restore", "device", restored.ID, map[string]interface{}{
+			"unique_hardware_id": restored.UniqueHardwareID,
+			"hostname":           restored.Hostname,
+		})
+		publishDeviceEvent(events.DeviceOnline, restored.ID, device)
+		publishDeviceEvent(events.DeviceHeartbeat, restored.ID, device)
+		return c.JSON(http.StatusCreated, HeartbeatResponse{DeviceID: restored.ID, Created: true})
+	}
+
+	if err := upsertHeartbeatDevice(ctx, &device); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	recordAudit(c, "device.create", "device", device.ID, map[string]interface{}{
+		"unique_hardware_id": device.UniqueHardwareID,
+		"hostname":           device.Hostname,
+		"source":             "heartbeat",
+	})
+	publishDeviceEvent(events.DeviceOnline, device.ID, device)
+	publishDeviceEvent(events.DeviceHeartbeat, device.ID, device)
+	return c.JSON(http.StatusCreated, HeartbeatResponse{DeviceID: device.ID, Created: true})
+}
+
+// upsertHeartbeatDevice 插入心跳上报的新设备
+// 并发的首次心跳可能同时走到这里，冲突时退化为更新，与已有设备的心跳一样刷新上报的字段；
+// 未上报 agent_version 时保留原值
+func upsertHeartbeatDevice(ctx context.Context, device *models.Device) error {
+	updates := clause.AssignmentColumns([]string{"os", "hostname", "last_seen_at", "updated_at"})
+	updates = append(updates, clause.Assignment{
+		Column: clause.Column{Name: "agent_version"},
+		Value:  gorm.Expr("COALESCE(NULLIF(excluded.agent_version, ''), devices.agent_version)"),
+	})
+	return db.DB.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "unique_hardware_id"}},
+		DoUpdates: updates,
+	}).Create(device).Error
+}
+
+// publishDeviceEvent 向 /api/admin/devices/events 的订阅者推送心跳产生的设备事件
+func publishDeviceEvent(eventType, deviceID string, device models.Device) {
+	events.PublishDevice(events.DeviceEvent{
+		Type:       eventType,
+		DeviceID:   deviceID,
+		Hostname:   device.Hostname,
+		LastSeenAt: device.LastSeenAt,
+	})
+}
+
+// checkAgentOwnsDevice Agent Key 关联了设备时，只允许为该设备上报心跳
+func checkAgentOwnsDevice(c echo.Context, deviceID string) error {
+	keyDeviceID, _ := c.Get(middleware.AgentDeviceID).(string)
+	if keyDeviceID == "" || keyDeviceID == deviceID {
+		return nil
+	}
+	return apierror.New(http.StatusForbidden, "device_mismatch", "Agent key is bound to a different device")
+}
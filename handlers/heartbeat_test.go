@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go-agent-manager/db"
+	"go-agent-manager/models"
+)
+
+// heartbeat 以 Agent 身份上报一次心跳
+func heartbeat(t *testing.T, hardwareID, hostname, agentVersion string) HeartbeatResponse {
+	t.Helper()
+	body := fmt.Sprintf(`{"unique_hardware_id": %q, "os": "linux", "hostname": %q, "agent_version": %q}`, hardwareID, hostname, agentVersion)
+	rec := serve(t, DeviceHeartbeat, request{method: http.MethodPost, target: "/devices/heartbeat", body: body})
+	if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+		t.Fatalf("heartbeat: status %d, body %s", rec.Code, rec.Body)
+	}
+	var resp HeartbeatResponse
+	decode(t, rec, &resp)
+	return resp
+}
+
+func TestHeartbeatUpdatesAgentVersion(t *testing.T) {
+	openTestDB(t)
+
+	first := heartbeat(t, "hw-heartbeat-version", "host-1", "1.0.0")
+	if !first.Created {
+		t.Fatalf("first heartbeat did not create the device")
+	}
+	second := heartbeat(t, "hw-heartbeat-version", "host-1", "1.1.0")
+	if second.Created || second.DeviceID != first.DeviceID {
+		t.Fatalf("second heartbeat = %+v, want update of device %s", second, first.DeviceID)
+	}
+	// 旧版 Agent 不上报版本号，保留原值
+	heartbeat(t, "hw-heartbeat-version", "host-1", "")
+
+	var device models.Device
+	if err := db.DB.First(&device, "id = ?", first.DeviceID).Error; err != nil {
+		t.Fatalf("load device: %v", err)
+	}
+	if device.AgentVersion != "1.1.0" {
+		t.Errorf("agent_version = %q, want 1.1.0", device.AgentVersion)
+	}
+}
+
+func TestUpsertHeartbeatDeviceConflict(t *testing.T) {
+	openTestDB(t)
+	ctx := context.Background()
+
+	upsert := func(hostname, agentVersion string) {
+		t.Helper()
+		device := models.Device{UniqueHardwareID: "hw-upsert", OS: "linux", Hostname: hostname, AgentVersion: agentVersion, LastSeenAt: time.Now()}
+		if err := upsertHeartbeatDevice(ctx, &device); err != nil {
+			t.Fatalf("upsert: %v", err)
+		}
+	}
+	// 后两次插入与第一次冲突，走 ON CONFLICT 更新分支
+	upsert("host-1", "1.0.0")
+	upsert("host-2", "2.0.0")
+	upsert("host-3", "")
+
+	var device models.Device
+	if err := db.DB.First(&device, "unique_hardware_id = ?", "hw-upsert").Error; err != nil {
+		t.Fatalf("load device: %v", err)
+	}
+	if device.Hostname != "host-3" || device.AgentVersion != "2.0.0" {
+		t.Errorf("hostname = %q, agent_version = %q; want host-3, 2.0.0", device.Hostname, device.AgentVersion)
+	}
+}
+
+func TestHeartbeatUpdatesExistingDevice(t *testing.T) {
+	openTestDB(t)
+	lastSeen := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	device := createTestDevice(t, "hw-heartbeat-existing", func(d *models.Device) {
+		d.OS = "windows"
+		d.Hostname = "old-host"
+		d.AgentVersion = "1.0.0"
+		d.LastSeenAt = lastSeen
+	})
+
+	before := time.Now()
+	body := `{"unique_hardware_id": "hw-heartbeat-existing", "os": "linux", "hostname": "new-host", "agent_version": "2.0.0"}`
+	rec := serve(t, DeviceHeartbeat, request{method: http.MethodPost, target: "/devices/heartbeat", body: body})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s; want 200", rec.Code, rec.Body)
+	}
+	var resp HeartbeatResponse
+	decode(t, rec, &resp)
+	if resp.Created || resp.DeviceID != device.ID {
+		t.Fatalf("response = %+v, want update of device %s", resp, device.ID)
+	}
+
+	var stored models.Device
+	if err := db.DB.First(&stored, "id = ?", device.ID).Error; err != nil {
+		t.Fatalf("load device: %v", err)
+	}
+	if stored.OS != "linux" {
+		t.Errorf("os = %q, want linux", stored.OS)
+	}
+	if stored.Hostname != "new-host" {
+		t.Errorf("hostname = %q, want new-host", stored.Hostname)
+	}
+	if stored.AgentVersion != "2.0.0" {
+		t.Errorf("agent_version = %q, want 2.0.0", stored.AgentVersion)
+	}
+	if stored.LastSeenAt.Before(before.Add(-time.Second)) {
+		t.Errorf("last_seen_at = %v, want refreshed to about %v", stored.LastSeenAt, before)
+	}
+	var count int64
+	db.DB.Model(&models.Device{}).Where("unique_hardware_id = ?", "hw-heartbeat-existing").Count(&count)
+	if count != 1 {
+		t.Errorf("%d devices with the hardware ID, want 1", count)
+	}
+}
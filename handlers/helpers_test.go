@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/db/dbtest"
+	"go-agent-manager/middleware"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// openTestDB 连接 TEST_DATABASE_URL 中的 handlers_test schema 并设置处理器依赖的配置，未配置时跳过测试
+func openTestDB(t *testing.T) {
+	t.Helper()
+	dbtest.Open(t, "handlers_test")
+	config.AppConfig.DefaultBindingStatus = models.BindingStatusActive
+	config.AppConfig.MaxDevicesPerUser = 0
+	config.AppConfig.DeviceOfflineThreshold = 5 * time.Minute
+	config.AppConfig.DeviceFieldMaxLength = 255
+}
+
+// request 描述一次对处理器的调用
+type request struct {
+	method string
+	target string            // 含查询参数的路径
+	body   string            // JSON 请求体，为空表示没有请求体
+	params map[string]string // 路由参数，如 {"id": "..."}
+	header map[string]string // 额外的请求头
+}
+
+// serve 直接调用处理器，错误交给全局错误处理器渲染，返回最终响应
+func serve(t *testing.T, h echo.HandlerFunc, r request) *httptest.ResponseRecorder {
+	t.Helper()
+	e := echo.New()
+	e.HTTPErrorHandler = middleware.HTTPErrorHandler
+
+	var body io.Reader
+	if r.body != "" {
+		body = strings.NewReader(r.body)
+	}
+	req := httptest.NewRequest(r.method, r.target, body)
+	if r.body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	for name, value := range r.header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	for name, value := range r.params {
+		c.SetParamNames(append(c.ParamNames(), name)...)
+		c.SetParamValues(append(c.ParamValues(), value)...)
+	}
+	if err := h(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+// errorCode 返回错误信封中的错误码，响应不是错误信封时返回空字符串
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var env apierror.Envelope
+	if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+		return ""
+	}
+	return env.Error.Code
+}
+
+// decode 将响应体解码到 v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// createTestDevice 直接在数据库中创建设备
+func createTestDevice(t *testing.T, hardwareID string, modify ...func(*models.Device)) models.Device {
+	t.Helper()
+	device := models.Device{UniqueHardwareID: hardwareID, Hostname: hardwareID, OS: "linux", LastSeenAt: time.Now()}
+	for _, m := range modify {
+		m(&device)
+	}
+	if err := db.DB.Create(&device).Error; err != nil {
+		t.Fatalf("create device %s: %v", hardwareID, err)
+	}
+	return device
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go-agent-manager/jobs"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetJobsStatus 返回后台任务的健康状态
+// 任一任务超过预期间隔未成功运行时 healthy 为 false
+func GetJobsStatus(c echo.Context) error {
+	statuses := jobs.Health()
+	healthy := true
+	for _, st := range statuses {
+		if !st.Healthy {
+			healthy = false
+			break
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"healthy": healthy,
+		"jobs":    statuses,
+	})
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/keycloak"
+	"go-agent-manager/logger"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RefreshKeycloakAdminToken 强制刷新后端调用 Keycloak Admin API 使用的 token
+// 用于排查问题或轮换 client secret 之后，无需重启服务
+func RefreshKeycloakAdminToken(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 10*time.Second)
+	defer cancel()
+
+	expiresAt, err := keycloak.ForceRefreshAdminToken(ctx)
+	if err != nil {
+		logger.For(c).Error("forced Keycloak admin token refresh failed", "error", err)
+		recordAudit(c, "keycloak.token_refresh", "keycloak", "", map[string]interface{}{"success": false})
+		return apierror.New(http.StatusBadGateway, "keycloak_login_failed",
+			"Failed to refresh Keycloak admin token: "+err.Error())
+	}
+	recordAudit(c, "keycloak.token_refresh", "keycloak", "", map[string]interface{}{"success": true})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"refreshed":  true,
+		"expires_at": expiresAt,
+	})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go-agent-manager/apierror"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// 列表分页参数
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+
+	// HeaderNextPageToken 下一页的游标，最后一页时不返回
+	HeaderNextPageToken = "X-Next-Page-Token"
+)
+
+// pageCursor 键集分页游标: 上一页最后一条记录的排序键
+type pageCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+// pageParams 列表分页参数
+// 默认使用键集分页 (limit + page_token)，按 (created_at, id) 排序，翻页期间有新增/删除也不会重复或遗漏；
+// offset 参数仅为兼容旧客户端保留，数据变化时可能重复或跳过记录
+type pageParams struct {
+	limit     int
+	offset    int
+	useOffset bool
+	after     *pageCursor
+}
+
+// parsePageParams 解析 limit / page_token / offset 查询参数
+func parsePageParams(c echo.Context) (*pageParams, error) {
+	limit, err := parseNonNegativeInt(c.QueryParam("limit"), defaultPageSize)
+	if err != nil || limit == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	p := &pageParams{limit: limit}
+
+	token := c.QueryParam("page_token")
+	rawOffset := c.QueryParam("offset")
+	if token != "" && rawOffset != "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "page_token and offset cannot be combined")
+	}
+	if rawOffset != "" {
+		if p.offset, err = parseNonNegativeInt(rawOffset, 0); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "offset must be a non-negative integer")
+		}
+		p.useOffset = true
+	}
+	if token != "" {
+		cursor, err := decodePageToken(token)
+		if err != nil {
+			return nil, apierror.New(http.StatusBadRequest, "invalid_page_token", "page_token is invalid")
+		}
+		p.after = cursor
+	}
+	return p, nil
+}
+
+// apply 为查询加上排序和分页条件，多取一条用于判断是否还有下一页
+func (p *pageParams) apply(query *gorm.DB) *gorm.DB {
+	query = query.Order("created_at ASC, id ASC").Limit(p.limit + 1)
+	if p.useOffset {
+		return query.Offset(p.offset)
+	}
+	if p.after != nil {
+		query = query.Where("(created_at, id) > (?, ?)", p.after.CreatedAt, p.after.ID)
+	}
+	return query
+}
+
+// paginate 截取当前页并在还有下一页时通过 X-Next-Page-Token 返回游标
+func paginate[T any](c echo.Context, p *pageParams, items []T, key func(T) pageCursor) []T {
+	if len(items) <= p.limit {
+		return items
+	}
+	items = items[:p.limit]
+	if token, err := encodePageToken(key(items[len(items)-1])); err == nil {
+		c.Response().Header().Set(HeaderNextPageToken, token)
+	}
+	return items
+}
+
+func encodePageToken(cursor pageCursor) (string, error) {
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodePageToken(token string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/db"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestPageTokenRoundTrip(t *testing.T) {
+	want := pageCursor{CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC), ID: "7b0a5a8e-1111-4c55-9a53-3d6f1e0c9e01"}
+	token, err := encodePageToken(want)
+	if err != nil {
+		t.Fatalf("encodePageToken: %v", err)
+	}
+	got, err := decodePageToken(token)
+	if err != nil {
+		t.Fatalf("decodePageToken(%q): %v", token, err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+		t.Errorf("round trip = %+v, want %+v", *got, want)
+	}
+	for _, bad := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := decodePageToken(bad); err == nil {
+			t.Errorf("decodePageToken(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestParsePageParams(t *testing.T) {
+	token, err := encodePageToken(pageCursor{CreatedAt: time.Now(), ID: "7b0a5a8e-1111-4c55-9a53-3d6f1e0c9e01"})
+	if err != nil {
+		t.Fatalf("encodePageToken: %v", err)
+	}
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int // 0 表示解析成功
+		wantLimit  int
+		wantOffset bool
+		wantCursor bool
+	}{
+		{"defaults", "", 0, defaultPageSize, false, false},
+		{"limit", "limit=10", 0, 10, false, false},
+		{"limit clamped", "limit=5000", 0, maxPageSize, false, false},
+		{"offset", "limit=10&offset=20", 0, 10, true, false},
+		{"page token", "page_token=" + token, 0, defaultPageSize, false, true},
+		{"zero limit", "limit=0", http.StatusBadRequest, 0, false, false},
+		{"negative limit", "limit=-1", http.StatusBadRequest, 0, false, false},
+		{"negative offset", "offset=-1", http.StatusBadRequest, 0, false, false},
+		{"token and offset", "offset=0&page_token=" + token, http.StatusBadRequest, 0, false, false},
+		{"invalid token", "page_token=not-a-token", http.StatusBadRequest, 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/devices?"+tt.query, nil), httptest.NewRecorder())
+			p, err := parsePageParams(c)
+			if tt.wantStatus != 0 {
+				var httpErr *echo.HTTPError
+				var apiErr *apierror.Error
+				switch {
+				case errors.As(err, &httpErr) && httpErr.Code == tt.wantStatus:
+				case errors.As(err, &apiErr) && apiErr.Status == tt.wantStatus:
+				default:
+					t.Errorf("parsePageParams error = %v, want status %d", err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePageParams: %v", err)
+			}
+			if p.limit != tt.wantLimit || p.useOffset != tt.wantOffset || (p.after != nil) != tt.wantCursor {
+				t.Errorf("params = {limit %d, offset %v, cursor %v}; want {limit %d, offset %v, cursor %v}",
+					p.limit, p.useOffset, p.after != nil, tt.wantLimit, tt.wantOffset, tt.wantCursor)
+			}
+		})
+	}
+}
+
+// pageThrough 以 limit 逐页请求列表直到没有下一页，每取完一页 (最后一页除外) 调用一次 between，返回按顺序收到的 ID
+func pageThrough(t *testing.T, h echo.HandlerFunc, path string, limit int, between func(page int)) []string {
+	t.Helper()
+	var ids []string
+	token := ""
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatal("pagination did not terminate")
+		}
+		query := url.Values{"limit": {fmt.Sprint(limit)}}
+		if token != "" {
+			query.Set("page_token", token)
+		}
+		rec := serve(t, h, request{method: http.MethodGet, target: path + "?" + query.Encode()})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("page %d: status %d, body %s", page, rec.Code, rec.Body)
+		}
+		var items []struct {
+			ID string `json:"id"`
+		}
+		decode(t, rec, &items)
+		if len(items) > limit {
+			t.Fatalf("page %d: %d items, want at most %d", page, len(items), limit)
+		}
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		token = rec.Header().Get(HeaderNextPageToken)
+		if token == "" {
+			return ids
+		}
+		between(page)
+	}
+}
+
+// assertPagedOnce 检查 got 中没有重复，want 中的每个 ID 都恰好出现一次，且不包含 unwanted 中的 ID
+func assertPagedOnce(t *testing.T, got, want, unwanted []string) {
+	t.Helper()
+	seen := make(map[string]int, len(got))
+	for _, id := range got {
+		seen[id]++
+		if seen[id] == 2 {
+			t.Errorf("ID %s returned more than once", id)
+		}
+	}
+	for _, id := range want {
+		if seen[id] == 0 {
+			t.Errorf("ID %s was skipped", id)
+		}
+	}
+	for _, id := range unwanted {
+		if seen[id] != 0 {
+			t.Errorf("ID %s inserted before the cursor was returned", id)
+		}
+	}
+}
+
+// seedDevices 创建 n 台设备，每两台共用同一个 created_at 以覆盖按 id 排序的平局情况
+func seedDevices(t *testing.T, prefix string, n int, base time.Time) []models.Device {
+	t.Helper()
+	devices := make([]models.Device, 0, n)
+	for i := 0; i < n; i++ {
+		createdAt := base.Add(time.Duration(i/2) * time.Second)
+		devices = append(devices, createTestDevice(t, fmt.Sprintf("%s-%02d", prefix, i), func(d *models.Device) {
+			d.CreatedAt = createdAt
+		}))
+	}
+	return devices
+}
+
+func TestGetDevicesPaginationWithConcurrentInserts(t *testing.T) {
+	openTestDB(t)
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	seeded := seedDevices(t, "hw-page", 11, base)
+	want := make([]string, 0, len(seeded))
+	for _, d := range seeded {
+		want = append(want, d.ID)
+	}
+
+	var unwanted []string
+	got := pageThrough(t, GetDevices, "/devices", 3, func(page int) {
+		// 游标之前插入的记录不应出现，之后插入的记录在后续页出现且不挤掉已有记录
+		early := createTestDevice(t, fmt.Sprintf("hw-page-early-%d", page), func(d *models.Device) {
+			d.CreatedAt = base.Add(-time.Minute)
+		})
+		unwanted = append(unwanted, early.ID)
+		late := createTestDevice(t, fmt.Sprintf("hw-page-late-%d", page))
+		want = append(want, late.ID)
+	})
+	assertPagedOnce(t, got, want, unwanted)
+}
+
+func TestGetBindingsPaginationWithConcurrentInserts(t *testing.T) {
+	openTestDB(t)
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var want, unwanted []string
+	createBinding := func(userID string, createdAt time.Time) string {
+		t.Helper()
+		device := createTestDevice(t, "hw-"+userID)
+		binding := models.UserDeviceBinding{
+			KeycloakUserID: userID,
+			DeviceID:       device.ID,
+			Status:         models.BindingStatusActive,
+			BoundAt:        createdAt,
+		}
+		binding.CreatedAt = createdAt
+		if err := db.DB.Create(&binding).Error; err != nil {
+			t.Fatalf("create binding for %s: %v", userID, err)
+		}
+		return binding.ID
+	}
+	for i := 0; i < 11; i++ {
+		want = append(want, createBinding(fmt.Sprintf("user-%02d", i), base.Add(time.Duration(i/2)*time.Second)))
+	}
+
+	got := pageThrough(t, GetBindings, "/bindings", 3, func(page int) {
+		unwanted = append(unwanted, createBinding(fmt.Sprintf("user-early-%d", page), base.Add(-time.Minute)))
+		want = append(want, createBinding(fmt.Sprintf("user-late-%d", page), time.Now()))
+	})
+	assertPagedOnce(t, got, want, unwanted)
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// uuidPattern 匹配标准格式的 UUID，用于在查询前过滤掉无效 ID (Postgres 对非法 uuid 会直接报错)
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// parseNonNegativeInt 解析非负整数查询参数，为空时返回默认值
+func parseNonNegativeInt(raw string, def int) (int, error) {
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		return 0, errors.New("must be a non-negative integer")
+	}
+	return v, nil
+}
+
+// parseOptionalBool 解析布尔查询参数，为空时返回 false
+func parseOptionalBool(raw string) (bool, error) {
+	if raw == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(raw)
+}
+
+// parseOptionalTime 解析 RFC3339 时间查询参数，为空时返回 nil
+func parseOptionalTime(raw string) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, errors.New("must be an RFC3339 timestamp such as 2024-01-02T15:04:05Z")
+	}
+	return &t, nil
+}
+
+// isUUID 判断字符串是否为合法 UUID
+func isUUID(s string) bool {
+	return uuidPattern.MatchString(s)
+}
@@ -1,48 +1,188 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"go-agent-manager/apierror"
 	"go-agent-manager/db"
 	"go-agent-manager/models"
+	"go-agent-manager/ruleengine"
+	"go-agent-manager/ruleset"
+	"go-agent-manager/schedule"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// GetRules 获取所有代理规则
+// GetRules 获取所有代理规则 (按匹配顺序: priority ASC, created_at ASC)
+// 支持 ?fields= 只返回指定字段；?enabled=true|false 按启用状态过滤
 func GetRules(c echo.Context) error {
+	fields, err := parseFields(c, ruleListFields)
+	if err != nil {
+		return err
+	}
+	query := db.DB.Order(ruleset.RuleOrder)
+	if raw := c.QueryParam("enabled"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "enabled must be true or false")
+		}
+		query = query.Where("enabled = ?", enabled)
+	}
 	var rules []models.Rule
-	if result := db.DB.Find(&rules); result.Error != nil {
+	if result := query.Find(&rules); result.Error != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
 	}
-	return c.JSON(http.StatusOK, rules)
+	return sparseJSON(c, http.StatusOK, rules, fields)
+}
+
+// SearchRules 查找会作用于指定域名或 IP 的规则
+// 使用规则引擎进行匹配 (包括通配域名和 CIDR)，而不是简单的子串过滤
+func SearchRules(c echo.Context) error {
+	target := c.QueryParam("target")
+	if target == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "target query parameter is required")
+	}
+
+	var rules []models.Rule
+	if result := db.DB.Order(ruleset.RuleOrder).Find(&rules); result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+
+	parsed := ruleengine.ParseTarget(target)
+	matched := make([]models.Rule, 0)
+	for _, rule := range rules {
+		if ruleengine.MatchTarget(rule.Match, parsed) {
+			matched = append(matched, rule)
+		}
+	}
+	return c.JSON(http.StatusOK, matched)
+}
+
+// RuleTestResult 规则匹配测试结果
+type RuleTestResult struct {
+	Input   string        `json:"input"`
+	Matched bool          `json:"matched"`
+	Rule    *models.Rule  `json:"rule"`    // 按优先级胜出的规则，未命中时为 null
+	Action  string        `json:"action"`  // 最终动作，未命中时为默认动作
+	Matches []models.Rule `json:"matches"` // 所有命中输入的已启用规则 (按匹配顺序，包括当前不在生效时间窗口内的)
+}
+
+// TestRules 使用与 Agent 相同的匹配逻辑，对所有已启用规则评估输入，返回胜出的规则和最终动作
+// 请求体: {"input": "ads.example.com"}，输入可以带端口 (db.internal:5432) 或是 IP 地址
+func TestRules(c echo.Context) error {
+	var req struct {
+		Input string `json:"input"`
+	}
+	if err := bindBody(c, &req); err != nil {
+		return err
+	}
+	if strings.TrimSpace(req.Input) == "" {
+		return apierror.New(http.StatusBadRequest, "invalid_input", "input is required")
+	}
+
+	rules, err := ruleset.Rules(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	rule, err := ruleengine.Evaluate(rules, req.Input)
+	if err != nil {
+		return apierror.New(http.StatusBadRequest, "invalid_input", err.Error())
+	}
+
+	result := RuleTestResult{Input: req.Input, Action: ruleengine.DefaultAction, Matches: make([]models.Rule, 0)}
+	if rule != nil {
+		result.Matched = true
+		result.Rule = rule
+		result.Action = rule.Action
+	}
+	target := ruleengine.ParseTarget(req.Input)
+	for _, r := range rules {
+		if ruleengine.MatchTarget(r.Match, target) {
+			result.Matches = append(result.Matches, r)
+		}
+	}
+	return c.JSON(http.StatusOK, result)
 }
 
 // CreateRule 创建新规则
 func CreateRule(c echo.Context) error {
 	rule := new(models.Rule)
-	if err := c.Bind(rule); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	if err := bindBody(c, rule); err != nil {
+		return err
+	}
+	if err := validateRuleFields(rule); err != nil {
+		return err
+	}
+	if err := validateRuleSchedule(rule); err != nil {
+		return err
+	}
+	if err := validateRuleCapabilities(rule); err != nil {
+		return err
+	}
+	if err := checkRuleNameAvailable(db.Primary(), rule.Name, ""); err != nil {
+		return err
 	}
 	rule.ID = "" // 让 GORM 自动生成 UUID
+	if rule.Enabled == nil {
+		enabled := true
+		rule.Enabled = &enabled
+	}
 
 	if result := db.DB.Create(&rule); result.Error != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+		return ruleWriteError(result.Error, rule.Name, "")
 	}
+	ruleset.NotifyChanged()
 	return c.JSON(http.StatusCreated, rule)
 }
 
-// UpdateRule 更新规则
+// GetRule 获取单个规则
+func GetRule(c echo.Context) error {
+	id := c.Param("id")
+	if !isUUID(id) {
+		return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
+	}
+	var rule models.Rule
+	err := db.DB.First(&rule, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, rule)
+}
+
+// ruleUpdate UpdateRule 的请求体: 与规则字段相同，但 priority 为指针，以区分未提供与显式设为 0
+type ruleUpdate struct {
+	models.Rule
+	Priority *int `json:"priority"`
+}
+
+// UpdateRule 更新规则，未提供 priority 时保持原优先级
 func UpdateRule(c echo.Context) error {
 	id := c.Param("id")
+	if !isUUID(id) {
+		return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
+	}
 	var rule models.Rule
-	if result := db.DB.First(&rule, "id = ?", id); result.Error != nil {
+	err := db.Primary().First(&rule, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
 	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
 
-	updates := new(models.Rule)
-	if err := c.Bind(updates); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	updates := new(ruleUpdate)
+	if err := bindBody(c, updates); err != nil {
+		return err
 	}
 
 	// 仅允许更新特定字段，避免意外修改 ID 或创建时间
@@ -50,19 +190,368 @@ func UpdateRule(c echo.Context) error {
 	rule.Type = updates.Type
 	rule.Match = updates.Match
 	rule.Action = updates.Action
+	if updates.Priority != nil {
+		rule.Priority = *updates.Priority
+	}
+	if updates.Enabled != nil {
+		rule.Enabled = updates.Enabled // 未提供 enabled 时保持原状态
+	}
 	rule.Description = updates.Description
+	rule.ActiveSchedule = updates.ActiveSchedule
+	rule.RequiredCapabilities = updates.RequiredCapabilities
+	if err := validateRuleFields(&rule); err != nil {
+		return err
+	}
+	if err := validateRuleSchedule(&rule); err != nil {
+		return err
+	}
+	if err := validateRuleCapabilities(&rule); err != nil {
+		return err
+	}
+	if err := checkRuleNameAvailable(db.Primary(), rule.Name, rule.ID); err != nil {
+		return err
+	}
 
 	if result := db.DB.Save(&rule); result.Error != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+		return ruleWriteError(result.Error, rule.Name, rule.ID)
 	}
+	ruleset.NotifyChanged()
 	return c.JSON(http.StatusOK, rule)
 }
 
-// DeleteRule 删除规则
+// checkRuleNameAvailable 规则名称不区分大小写唯一 (idx_rules_name_lower)，与其他规则冲突时返回 409
+// 已软删除的规则仍占用名称；excludeID 为正在更新的规则自身
+func checkRuleNameAvailable(tx *gorm.DB, name, excludeID string) error {
+	var existing models.Rule
+	query := tx.Unscoped().Select("id", "name", "deleted_at").Where("lower(name) = lower(?)", name)
+	if excludeID != "" {
+		query = query.Where("id <> ?", excludeID)
+	}
+	err := query.First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return apierror.New(http.StatusConflict, "rule_name_conflict",
+		fmt.Sprintf("Rule name %q conflicts with existing rule %q (names are case-insensitive)", name, existing.Name)).
+		WithDetails(map[string]interface{}{
+			"conflicting_rule_id": existing.ID,
+			"conflicting_name":    existing.Name,
+			"deleted":             existing.DeletedAt.Valid,
+		})
+}
+
+// ruleWriteError 转换创建/保存规则时的数据库错误
+// 名称检查与写入之间并发写入同名规则时，后提交的请求在唯一索引上冲突，同样返回 409 rule_name_conflict
+func ruleWriteError(err error, name, excludeID string) error {
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if conflict := checkRuleNameAvailable(db.Primary(), name, excludeID); conflict != nil {
+		return conflict
+	}
+	return apierror.New(http.StatusConflict, "rule_name_conflict",
+		fmt.Sprintf("Rule name %q conflicts with an existing rule (names are case-insensitive)", name))
+}
+
+// DeleteRule 删除规则，规则不存在或已删除时返回 404
 func DeleteRule(c echo.Context) error {
 	id := c.Param("id")
-	if result := db.DB.Delete(&models.Rule{}, "id = ?", id); result.Error != nil {
+	if !isUUID(id) {
+		return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
+	}
+	result := db.DB.Delete(&models.Rule{}, "id = ?", id)
+	if result.Error != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
 	}
+	if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
+	}
+	ruleset.NotifyChanged()
 	return c.NoContent(http.StatusNoContent)
 }
+
+// ConflictRule 冲突报告中的规则摘要
+type ConflictRule struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Match    string `json:"match"`
+	Action   string `json:"action"`
+	Priority int    `json:"priority"`
+}
+
+// RuleConflict 一对 Match 重叠但动作不同的规则
+type RuleConflict struct {
+	RuleIDs         []string       `json:"rule_ids"`
+	Rules           []ConflictRule `json:"rules"`
+	Reason          string         `json:"reason"`
+	EffectiveRuleID string         `json:"effective_rule_id"` // 按当前评估顺序实际生效的规则 (排在前面的一条)
+}
+
+// GetRuleConflicts 找出 Match 相同或重叠但 Action 不同的已启用规则对
+// 只做静态分析: 不考虑生效时间窗口，域名与 IP 之间不做解析
+func GetRuleConflicts(c echo.Context) error {
+	rules, err := ruleset.Rules(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	summary := func(r models.Rule) ConflictRule {
+		return ConflictRule{ID: r.ID, Name: r.Name, Match: r.Match, Action: r.Action, Priority: r.Priority}
+	}
+	conflicts := make([]RuleConflict, 0)
+	// rules 已按评估顺序排列，因此每对中的 rules[i] 就是实际生效的规则
+	for i := 0; i < len(rules); i++ {
+		for j := i + 1; j < len(rules); j++ {
+			if rules[i].Action == rules[j].Action {
+				continue
+			}
+			overlap, reason := ruleengine.Overlap(rules[i].Match, rules[j].Match)
+			if !overlap {
+				continue
+			}
+			conflicts = append(conflicts, RuleConflict{
+				RuleIDs:         []string{rules[i].ID, rules[j].ID},
+				Rules:           []ConflictRule{summary(rules[i]), summary(rules[j])},
+				Reason:          reason,
+				EffectiveRuleID: rules[i].ID,
+			})
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"total":     len(conflicts),
+		"conflicts": conflicts,
+	})
+}
+
+// ToggleRule 切换规则的启用状态，返回切换后的状态
+func ToggleRule(c echo.Context) error {
+	id := c.Param("id")
+	if !isUUID(id) {
+		return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
+	}
+	result := db.DB.Model(&models.Rule{}).Where("id = ?", id).Update("enabled", gorm.Expr("NOT enabled"))
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
+	}
+	var rule models.Rule
+	if err := db.Primary().Select("id", "name", "enabled").First(&rule, "id = ?", id).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	ruleset.NotifyChanged()
+	recordAudit(c, "rule.toggle", "rule", rule.ID, map[string]interface{}{
+		"name":    rule.Name,
+		"enabled": rule.IsEnabled(),
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":      rule.ID,
+		"enabled": rule.IsEnabled(),
+	})
+}
+
+// reorderPriorityStep 重排后相邻规则的优先级间隔，留出空位便于之后插入规则而无需整体重排
+const reorderPriorityStep = 10
+
+// ReorderRules 按给定顺序重写所有规则的优先级，请求体: {"rule_ids": ["...", "..."]}
+// 列表必须恰好包含全部规则各一次，第 i 条规则的优先级被设为 (i+1)*10；在单个事务中完成
+func ReorderRules(c echo.Context) error {
+	type ReorderRequest struct {
+		RuleIDs []string `json:"rule_ids"`
+	}
+	req := new(ReorderRequest)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	if len(req.RuleIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "rule_ids is required")
+	}
+
+	err := db.DB.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		var ids []string
+		// 锁定全部规则，防止重排期间有其他请求修改优先级
+		if err := tx.Model(&models.Rule{}).Clauses(clause.Locking{Strength: "UPDATE"}).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		existing := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			existing[id] = true
+		}
+		seen := make(map[string]bool, len(req.RuleIDs))
+		var unknown, duplicate []string
+		for _, id := range req.RuleIDs {
+			switch {
+			case seen[id]:
+				duplicate = append(duplicate, id)
+			case !existing[id]:
+				unknown = append(unknown, id)
+			}
+			seen[id] = true
+		}
+		var missing []string
+		for _, id := range ids {
+			if !seen[id] {
+				missing = append(missing, id)
+			}
+		}
+		if len(unknown)+len(duplicate)+len(missing) > 0 {
+			return apierror.New(http.StatusBadRequest, "invalid_rule_order",
+				"rule_ids must list every rule exactly once").
+				WithDetails(map[string]interface{}{"unknown": unknown, "duplicate": duplicate, "missing": missing})
+		}
+
+		for i, id := range req.RuleIDs {
+			err := tx.Model(&models.Rule{}).Where("id = ?", id).Update("priority", (i+1)*reorderPriorityStep).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	ruleset.NotifyChanged()
+	recordAudit(c, "rule.reorder", "rule", "", map[string]interface{}{"rule_ids": req.RuleIDs})
+
+	var rules []models.Rule
+	if result := db.Primary().Order(ruleset.RuleOrder).Find(&rules); result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+// RuleApplicationStatus 单台设备对规则的应用结果
+type RuleApplicationStatus struct {
+	DeviceID   string    `json:"device_id"`
+	Hostname   string    `json:"hostname"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// GetRuleApplicationStatus 查看规则在各设备上的应用结果 (由 Agent 通过 /api/agent/rules/report 上报)
+func GetRuleApplicationStatus(c echo.Context) error {
+	id := c.Param("id")
+	if !isUUID(id) {
+		return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
+	}
+	var rule models.Rule
+	err := db.DB.First(&rule, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	var statuses []RuleApplicationStatus
+	err = db.DB.Model(&models.RuleApplication{}).
+		Select("rule_applications.device_id, devices.hostname, rule_applications.status, rule_applications.error, rule_applications.reported_at").
+		Joins("LEFT JOIN devices ON devices.id::text = rule_applications.device_id AND devices.deleted_at IS NULL").
+		Where("rule_applications.rule_id = ?", id).
+		Order("rule_applications.reported_at DESC").
+		Scan(&statuses).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	applied, failed := 0, 0
+	for _, s := range statuses {
+		if s.Status == models.RuleApplicationFailed {
+			failed++
+		} else {
+			applied++
+		}
+	}
+	if statuses == nil {
+		statuses = []RuleApplicationStatus{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"rule_id": rule.ID,
+		"applied": applied,
+		"failed":  failed,
+		"devices": statuses,
+	})
+}
+
+// validateRuleFields 校验规则的 type 和 action 是否为允许值 (models.RuleTypes / models.RuleActions)、priority 非负，
+// 以及 match 是否符合该类型的写法
+func validateRuleFields(rule *models.Rule) error {
+	fields := []struct {
+		name    string
+		value   string
+		allowed []string
+	}{
+		{"type", rule.Type, models.RuleTypes},
+		{"action", rule.Action, models.RuleActions},
+	}
+	if rule.Priority < 0 {
+		return apierror.New(http.StatusBadRequest, "invalid_priority", "priority must not be negative")
+	}
+	for _, f := range fields {
+		if !containsString(f.allowed, f.value) {
+			return apierror.New(http.StatusBadRequest, "invalid_"+f.name,
+				fmt.Sprintf("Invalid %s %q; allowed values: %s", f.name, f.value, strings.Join(f.allowed, ", "))).
+				WithDetails(map[string]interface{}{"field": f.name, "value": f.value, "allowed": f.allowed})
+		}
+	}
+	// type 合法后再按类型校验 match 写法
+	if err := ruleengine.ValidatePattern(rule.Type, rule.Match); err != nil {
+		return apierror.New(http.StatusBadRequest, "invalid_match",
+			fmt.Sprintf("Invalid match %q for type %s: %v", rule.Match, rule.Type, err)).
+			WithDetails(map[string]interface{}{"field": "match", "value": rule.Match, "type": rule.Type})
+	}
+	return nil
+}
+
+// containsString 判断 values 中是否包含 s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRuleSchedule 校验规则的生效时间窗口语法
+func validateRuleSchedule(rule *models.Rule) error {
+	if rule.ActiveSchedule == "" {
+		return nil
+	}
+	if _, err := schedule.Parse(rule.ActiveSchedule); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid active_schedule: "+err.Error())
+	}
+	return nil
+}
+
+// validateRuleCapabilities 校验规则声明的能力均为已知能力，并去除重复项
+func validateRuleCapabilities(rule *models.Rule) error {
+	known := make(map[string]bool, len(models.KnownCapabilities))
+	for _, c := range models.KnownCapabilities {
+		known[c] = true
+	}
+	seen := make(map[string]bool, len(rule.RequiredCapabilities))
+	capabilities := make([]string, 0, len(rule.RequiredCapabilities))
+	for _, c := range rule.RequiredCapabilities {
+		if !known[c] {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("Unknown capability %q; known capabilities: %s", c, strings.Join(models.KnownCapabilities, ", ")))
+		}
+		if !seen[c] {
+			seen[c] = true
+			capabilities = append(capabilities, c)
+		}
+	}
+	rule.RequiredCapabilities = capabilities
+	return nil
+}
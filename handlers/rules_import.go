@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/models"
+	"go-agent-manager/ruleset"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// 远程导入限制
+const (
+	remoteImportTimeout  = 10 * time.Second
+	remoteImportMaxBytes = 1 << 20 // 1 MiB
+)
+
+// RuleDocument 规则的可移植表示 (导入/导出使用，不包含 ID、时间戳等数据库内部字段)
+type RuleDocument struct {
+	Name                 string   `json:"name"`
+	Type                 string   `json:"type"`
+	Match                string   `json:"match"`
+	Action               string   `json:"action"`
+	Priority             int      `json:"priority,omitempty"`
+	Enabled              *bool    `json:"enabled,omitempty"` // 省略时为 true
+	Description          string   `json:"description,omitempty"`
+	ActiveSchedule       string   `json:"active_schedule,omitempty"`
+	RequiredCapabilities []string `json:"required_capabilities,omitempty"`
+}
+
+// 单条规则的导入结果
+const (
+	importCreated = "created"
+	importUpdated = "updated"
+	importSkipped = "skipped" // 同名规则已存在且未指定 overwrite
+	importInvalid = "invalid"
+)
+
+// RuleImportResult 单条规则的导入结果
+type RuleImportResult struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RuleImportSummary 导入汇总
+type RuleImportSummary struct {
+	Created int                `json:"created"`
+	Updated int                `json:"updated"`
+	Skipped int                `json:"skipped"`
+	Invalid int                `json:"invalid"`
+	Results []RuleImportResult `json:"results"`
+}
+
+// newRuleDocument 将规则模型转换为可移植表示
+func newRuleDocument(r models.Rule) RuleDocument {
+	return RuleDocument{
+		Name:                 r.Name,
+		Type:                 r.Type,
+		Match:                r.Match,
+		Action:               r.Action,
+		Priority:             r.Priority,
+		Enabled:              r.Enabled,
+		Description:          r.Description,
+		ActiveSchedule:       r.ActiveSchedule,
+		RequiredCapabilities: r.RequiredCapabilities,
+	}
+}
+
+// ExportRules 以 JSON 数组导出全部规则 (按匹配顺序，不含 ID、时间戳等数据库内部字段)
+// 输出可直接作为 POST /api/admin/rules/import 的请求体
+func ExportRules(c echo.Context) error {
+	var rules []models.Rule
+	if result := db.DB.Order(ruleset.RuleOrder).Find(&rules); result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	docs := make([]RuleDocument, 0, len(rules))
+	for _, r := range rules {
+		docs = append(docs, newRuleDocument(r))
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="rules-%s.json"`, time.Now().UTC().Format("20060102-150405")))
+	return c.JSON(http.StatusOK, docs)
+}
+
+// ImportRules 导入 JSON 规则数组 (格式同导出)，按名称 upsert
+// ?overwrite=true 时覆盖同名规则，否则跳过；存在无效规则时整体不导入并返回 400 及逐条结果
+func ImportRules(c echo.Context) error {
+	overwrite := false
+	if raw := c.QueryParam("overwrite"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "overwrite must be true or false")
+		}
+		overwrite = v
+	}
+	var docs []RuleDocument
+	if err := bindBody(c, &docs); err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Request body must be a non-empty JSON array of rules")
+	}
+
+	summary, err := importRules(c.Request().Context(), docs, overwrite)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if summary.Invalid > 0 {
+		return apierror.New(http.StatusBadRequest, "invalid_rules", "Some rules are invalid; nothing was imported").
+			WithDetails(summary)
+	}
+	recordAudit(c, "rule.import", "rule", "", map[string]interface{}{
+		"source":  "upload",
+		"created": summary.Created,
+		"updated": summary.Updated,
+		"skipped": summary.Skipped,
+	})
+	return c.JSON(http.StatusOK, summary)
+}
+
+// ImportRulesFromURL 从允许列表中的远程地址拉取规则文档并导入
+// 请求体: {"url": "https://...", "format": "json", "overwrite": false}
+// 只有位于 RULE_IMPORT_URL_ALLOWLIST 某一项之下的地址可以被拉取 (防止 SSRF)，重定向同样受限
+func ImportRulesFromURL(c echo.Context) error {
+	type ImportFromURLRequest struct {
+		URL       string `json:"url"`
+		Format    string `json:"format"`
+		Overwrite bool   `json:"overwrite"`
+	}
+	req := new(ImportFromURLRequest)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	if req.URL == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "url is required")
+	}
+	if req.Format == "" {
+		req.Format = "json"
+	}
+	if req.Format != "json" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unsupported format: only json is supported")
+	}
+	if !importURLAllowed(req.URL) {
+		return apierror.New(http.StatusForbidden, "url_not_allowed", "URL is not in RULE_IMPORT_URL_ALLOWLIST")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), remoteImportTimeout)
+	defer cancel()
+	body, err := fetchRemoteDocument(ctx, req.URL)
+	if err != nil {
+		return apierror.New(http.StatusBadGateway, "remote_fetch_failed", "Failed to fetch rules: "+err.Error())
+	}
+
+	var docs []RuleDocument
+	if err := json.Unmarshal(body, &docs); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Remote document is not a JSON array of rules: "+err.Error())
+	}
+
+	summary, err := importRules(ctx, docs, req.Overwrite)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if summary.Invalid > 0 {
+		return apierror.New(http.StatusBadRequest, "invalid_rules", "Some rules are invalid; nothing was imported").
+			WithDetails(summary)
+	}
+	recordAudit(c, "rule.import", "rule", "", map[string]interface{}{
+		"source":  req.URL,
+		"created": summary.Created,
+		"updated": summary.Updated,
+		"skipped": summary.Skipped,
+	})
+	return c.JSON(http.StatusOK, summary)
+}
+
+// importURLAllowed 判断 URL 是否落在允许列表的某一项之内 (只允许 http/https)
+// 协议和主机 (含端口，默认端口视为省略) 必须完全相同；路径经 path.Clean 规范化后按段做前缀匹配，
+// 因此 https://example.com 不会匹配 https://example.com.attacker.net，/allowed/../internal 也不会匹配 /allowed/
+func importURLAllowed(raw string) bool {
+	u, ok := parseImportURL(raw)
+	if !ok {
+		return false
+	}
+	for _, entry := range strings.Split(config.AppConfig.RuleImportURLAllowlist, ",") {
+		allowed, ok := parseImportURL(strings.TrimSpace(entry))
+		if !ok || allowed.Scheme != u.Scheme || allowed.Host != u.Host {
+			continue
+		}
+		if pathWithin(u.Path, allowed.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseImportURL 解析并规范化 http/https URL: 协议和主机转小写、去掉默认端口、路径经 path.Clean 处理
+// 带用户信息或缺少主机的 URL 视为无效
+func parseImportURL(raw string) (*url.URL, bool) {
+	if raw == "" {
+		return nil, false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.User != nil {
+		return nil, false
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, false
+	}
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (u.Scheme == "https" && port == "443") || (u.Scheme == "http" && port == "80") {
+		port = ""
+	}
+	u.Host = host
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	}
+	u.Path = path.Clean("/" + u.Path)
+	return u, true
+}
+
+// pathWithin 判断规范化后的路径 p 是否等于 prefix 或位于其下 (按路径段比较)
+func pathWithin(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// fetchRemoteDocument 拉取远程文档，限制大小并拒绝跳转到允许列表以外的地址
+func fetchRemoteDocument(ctx context.Context, rawURL string) ([]byte, error) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if !importURLAllowed(req.URL.String()) {
+				return errors.New("redirect target is not in the allowlist")
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, remoteImportMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > remoteImportMaxBytes {
+		return nil, fmt.Errorf("document exceeds %d bytes", remoteImportMaxBytes)
+	}
+	return body, nil
+}
+
+// importRules 校验并按名称 upsert 规则
+// 先校验全部规则，存在无效规则时不写入任何数据 (summary.Invalid > 0)；写入在单个事务中完成
+func importRules(ctx context.Context, docs []RuleDocument, overwrite bool) (*RuleImportSummary, error) {
+	summary := &RuleImportSummary{Results: make([]RuleImportResult, 0, len(docs))}
+	rules := make([]models.Rule, len(docs))
+	seen := make(map[string]int, len(docs))
+	for i, d := range docs {
+		rules[i] = models.Rule{
+			Name:                 strings.TrimSpace(d.Name),
+			Type:                 d.Type,
+			Match:                d.Match,
+			Action:               d.Action,
+			Priority:             d.Priority,
+			Enabled:              d.Enabled,
+			Description:          d.Description,
+			ActiveSchedule:       d.ActiveSchedule,
+			RequiredCapabilities: d.RequiredCapabilities,
+		}
+		result := RuleImportResult{Index: i, Name: rules[i].Name}
+		// 名称不区分大小写唯一，按小写去重和匹配已有规则
+		nameKey := strings.ToLower(rules[i].Name)
+		if prev, dup := seen[nameKey]; dup {
+			result.Status, result.Error = importInvalid, fmt.Sprintf("duplicate name (also at index %d)", prev)
+		} else if err := validateImportedRule(&rules[i]); err != nil {
+			result.Status, result.Error = importInvalid, err.Error()
+		}
+		seen[nameKey] = i
+		if result.Status == importInvalid {
+			summary.Invalid++
+		}
+		summary.Results = append(summary.Results, result)
+	}
+	if summary.Invalid > 0 {
+		return summary, nil
+	}
+
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := range rules {
+			var existing models.Rule
+			err := tx.Where("lower(name) = lower(?)", rules[i].Name).First(&existing).Error
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				if rules[i].Enabled == nil {
+					enabled := true
+					rules[i].Enabled = &enabled
+				}
+				if err := tx.Create(&rules[i]).Error; err != nil {
+					return err
+				}
+				summary.Results[i].Status = importCreated
+				summary.Created++
+			case err != nil:
+				return err
+			case !overwrite:
+				summary.Results[i].Status = importSkipped
+				summary.Skipped++
+			default:
+				existing.Type = rules[i].Type
+				existing.Match = rules[i].Match
+				existing.Action = rules[i].Action
+				existing.Priority = rules[i].Priority
+				if rules[i].Enabled != nil {
+					existing.Enabled = rules[i].Enabled
+				}
+				existing.Description = rules[i].Description
+				existing.ActiveSchedule = rules[i].ActiveSchedule
+				existing.RequiredCapabilities = rules[i].RequiredCapabilities
+				if err := tx.Save(&existing).Error; err != nil {
+					return err
+				}
+				summary.Results[i].Status = importUpdated
+				summary.Updated++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if summary.Created+summary.Updated > 0 {
+		ruleset.NotifyChanged()
+	}
+	return summary, nil
+}
+
+// validateImportedRule 校验导入的规则，与 CreateRule 的校验保持一致
+func validateImportedRule(rule *models.Rule) error {
+	switch {
+	case rule.Name == "":
+		return errors.New("name is required")
+	case rule.Type == "":
+		return errors.New("type is required")
+	case rule.Match == "":
+		return errors.New("match is required")
+	case rule.Action == "":
+		return errors.New("action is required")
+	}
+	if err := validateRuleFields(rule); err != nil {
+		return errors.New(httpErrorMessage(err))
+	}
+	if err := validateRuleSchedule(rule); err != nil {
+		return errors.New(httpErrorMessage(err))
+	}
+	if err := validateRuleCapabilities(rule); err != nil {
+		return errors.New(httpErrorMessage(err))
+	}
+	return nil
+}
+
+// httpErrorMessage 取出 echo.HTTPError 或 apierror.Error 中的消息文本
+func httpErrorMessage(err error) string {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return fmt.Sprint(he.Message)
+	}
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Message
+	}
+	return err.Error()
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"testing"
+
+	"go-agent-manager/config"
+)
+
+func TestImportURLAllowed(t *testing.T) {
+	prev := config.AppConfig.RuleImportURLAllowlist
+	t.Cleanup(func() { config.AppConfig.RuleImportURLAllowlist = prev })
+	config.AppConfig.RuleImportURLAllowlist = "https://raw.githubusercontent.com/acme/rules/, http://rules.internal:8080"
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://raw.githubusercontent.com/acme/rules/prod.json", true},
+		{"https://raw.githubusercontent.com/acme/rules", true},
+		{"https://RAW.githubusercontent.com:443/acme/rules/prod.json", true},
+		{"https://raw.githubusercontent.com/acme/rules-evil/prod.json", false},
+		{"https://raw.githubusercontent.com/acme/rules/../secrets.json", false},
+		{"https://raw.githubusercontent.com/acme/rules/%2e%2e/secrets.json", false},
+		{"https://raw.githubusercontent.com.attacker.net/acme/rules/prod.json", false},
+		{"http://raw.githubusercontent.com/acme/rules/prod.json", false},
+		{"https://user@raw.githubusercontent.com/acme/rules/prod.json", false},
+		{"http://rules.internal:8080/any/path.json", true},
+		{"http://rules.internal/any/path.json", false},
+		{"http://rules.internal:8081/any/path.json", false},
+		{"ftp://rules.internal:8080/rules.json", false},
+		{"not a url", false},
+	}
+	for _, tt := range tests {
+		if got := importURLAllowed(tt.url); got != tt.want {
+			t.Errorf("importURLAllowed(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCreateRuleConcurrentNameConflict(t *testing.T) {
+	openTestDB(t)
+
+	names := []string{"Block Ads", "block ads", "BLOCK ADS", "Block ads", "block Ads", "bLOCK ADS"}
+	statuses := make([]int, len(names))
+	codes := make([]string, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"name": %q, "type": "http-proxy", "match": "ads.example.com", "action": "block"}`, name)
+			rec := serve(t, CreateRule, request{method: http.MethodPost, target: "/rules", body: body})
+			statuses[i], codes[i] = rec.Code, errorCode(t, rec)
+		}(i, name)
+	}
+	wg.Wait()
+
+	created := 0
+	for i := range names {
+		switch {
+		case statuses[i] == http.StatusCreated:
+			created++
+		case statuses[i] == http.StatusConflict && codes[i] == "rule_name_conflict":
+		default:
+			t.Errorf("create %q: status %d code %q, want 201 or 409 rule_name_conflict", names[i], statuses[i], codes[i])
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d rules created, want exactly 1", created)
+	}
+}
+
+func TestRuleHandlersRejectNonUUID(t *testing.T) {
+	handlers := map[string]echo.HandlerFunc{
+		"GetRule":                  GetRule,
+		"UpdateRule":               UpdateRule,
+		"DeleteRule":               DeleteRule,
+		"ToggleRule":               ToggleRule,
+		"GetRuleApplicationStatus": GetRuleApplicationStatus,
+	}
+	for name, h := range handlers {
+		t.Run(name, func(t *testing.T) {
+			rec := serve(t, h, request{method: http.MethodPost, target: "/rules/not-a-uuid", params: map[string]string{"id": "not-a-uuid"}})
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status %d, body %s; want 404", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestDeleteRuleNotFound(t *testing.T) {
+	openTestDB(t)
+
+	rec := serve(t, CreateRule, request{method: http.MethodPost, target: "/rules",
+		body: `{"name": "delete me", "type": "http-proxy", "match": "delete.example.com", "action": "block"}`})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create rule: status %d, body %s", rec.Code, rec.Body)
+	}
+	var rule struct {
+		ID string `json:"id"`
+	}
+	decode(t, rec, &rule)
+	params := map[string]string{"id": rule.ID}
+
+	if rec := serve(t, DeleteRule, request{method: http.MethodDelete, target: "/rules/" + rule.ID, params: params}); rec.Code != http.StatusNoContent {
+		t.Fatalf("first delete: status %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, DeleteRule, request{method: http.MethodDelete, target: "/rules/" + rule.ID, params: params}); rec.Code != http.StatusNotFound {
+		t.Errorf("second delete: status %d, body %s; want 404", rec.Code, rec.Body)
+	}
+}
+
+func TestUpdateRuleKeepsPriorityWhenOmitted(t *testing.T) {
+	openTestDB(t)
+
+	rec := serve(t, CreateRule, request{method: http.MethodPost, target: "/rules",
+		body: `{"name": "keep priority", "type": "http-proxy", "match": "priority.example.com", "action": "block", "priority": 50}`})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create rule: status %d, body %s", rec.Code, rec.Body)
+	}
+	var rule models.Rule
+	decode(t, rec, &rule)
+	params := map[string]string{"id": rule.ID}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"omitted", `{"name": "keep priority", "type": "http-proxy", "match": "priority.example.com", "action": "proxy"}`, 50},
+		{"explicit zero", `{"name": "keep priority", "type": "http-proxy", "match": "priority.example.com", "action": "proxy", "priority": 0}`, 0},
+		{"explicit value", `{"name": "keep priority", "type": "http-proxy", "match": "priority.example.com", "action": "proxy", "priority": 20}`, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, UpdateRule, request{method: http.MethodPut, target: "/rules/" + rule.ID, body: tt.body, params: params})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("update rule: status %d, body %s", rec.Code, rec.Body)
+			}
+			var updated models.Rule
+			decode(t, rec, &updated)
+			if updated.Priority != tt.want {
+				t.Errorf("priority = %d, want %d", updated.Priority, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleScheduleValidation(t *testing.T) {
+	tests := []struct {
+		schedule string
+		valid    bool
+	}{
+		{"", true}, // 未设置表示始终生效
+		{"Mon-Fri 09:00-18:00", true},
+		{"Mon-Fri 09:00-18:00; Sat 10:00-14:00", true},
+		{"Mon 9:00-18:00", false},
+		{"Funday 09:00-18:00", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			err := validateRuleSchedule(&models.Rule{ActiveSchedule: tt.schedule})
+			if tt.valid != (err == nil) {
+				t.Errorf("validateRuleSchedule(%q) = %v, want valid=%v", tt.schedule, err, tt.valid)
+			}
+		})
+	}
+
+	// 创建规则时在写库之前拒绝无效的时间窗口
+	body := `{"name": "bad schedule", "type": "http-proxy", "match": "schedule.example.com", "action": "block", "active_schedule": "Mon 25:00-26:00"}`
+	rec := serve(t, CreateRule, request{method: http.MethodPost, target: "/rules", body: body})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("CreateRule with invalid schedule: status %d, body %s; want 400", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// DeviceStats 设备数量统计 (不含已删除设备)
+type DeviceStats struct {
+	Total   int64 `json:"total"`
+	Online  int64 `json:"online"`
+	Offline int64 `json:"offline"`
+	// ByAgentVersion 按 Agent 版本分组，用于跟踪升级进度；未上报版本的设备计入 "unknown"
+	ByAgentVersion map[string]int64 `json:"by_agent_version"`
+}
+
+// Stats 仪表盘汇总数据
+type Stats struct {
+	Devices  DeviceStats      `json:"devices"`
+	Bindings map[string]int64 `json:"bindings"` // 按状态分组
+	Rules    map[string]int64 `json:"rules"`    // 按类型分组
+}
+
+// unknownAgentVersion 统计中未上报 Agent 版本的设备的分组名
+const unknownAgentVersion = "unknown"
+
+// GetStats 返回设备、绑定和规则的汇总统计，全部使用 COUNT / GROUP BY 在数据库中计算
+func GetStats(c echo.Context) error {
+	tx := db.DB.WithContext(c.Request().Context())
+	stats := Stats{}
+
+	// 在线判断与 newDeviceResponse 使用同一阈值
+	since := time.Now().Add(-config.AppConfig.DeviceOfflineThreshold)
+	err := tx.Model(&models.Device{}).
+		Select("COUNT(*) AS total, COUNT(*) FILTER (WHERE last_seen_at >= ?) AS online", since).
+		Scan(&stats.Devices).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	stats.Devices.Offline = stats.Devices.Total - stats.Devices.Online
+	if stats.Devices.ByAgentVersion, err = countGroupedBy(tx.Model(&models.Device{}), "agent_version"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if n, ok := stats.Devices.ByAgentVersion[""]; ok {
+		delete(stats.Devices.ByAgentVersion, "")
+		stats.Devices.ByAgentVersion[unknownAgentVersion] += n
+	}
+
+	if stats.Bindings, err = countGroupedBy(tx.Model(&models.UserDeviceBinding{}), "status"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if stats.Rules, err = countGroupedBy(tx.Model(&models.Rule{}), "type"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// countGroupedBy 按 column 分组计数，返回 值 -> 数量
+func countGroupedBy(query *gorm.DB, column string) (map[string]int64, error) {
+	var rows []struct {
+		GroupKey string
+		Count    int64
+	}
+	err := query.Select(column + " AS group_key, COUNT(*) AS count").Group(column).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		counts[r.GroupKey] = r.Count
+	}
+	return counts, nil
+}
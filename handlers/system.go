@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go-agent-manager/config"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetEffectiveConfig 返回当前生效的配置 (键为环境变量名)，密钥和连接串密码已脱敏
+func GetEffectiveConfig(c echo.Context) error {
+	return c.JSON(http.StatusOK, config.Redacted())
+}
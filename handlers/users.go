@@ -2,27 +2,195 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time" // 添加了缺失的 time 包
 
+	"go-agent-manager/apierror"
+	"go-agent-manager/bindings"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
 	"go-agent-manager/keycloak"
+	"go-agent-manager/logger"
+	"go-agent-manager/models"
 
 	"github.com/labstack/echo/v4"
 )
 
-// GetUsers 获取 Keycloak 用户列表
+// GetUsers 的分页参数
+const (
+	usersDefaultPageSize = 100
+	usersMaxPageSize     = 1000
+	// userEnrichConcurrency 补充用户附加信息 (联合身份等) 时同时发出的 Keycloak 请求数
+	userEnrichConcurrency = 5
+)
+
+// GetUsers 分页获取 Keycloak 用户列表
+// 支持 first / max (默认 100，最大 1000) 和 search 参数，由 Keycloak 服务端分页和搜索；
+// 符合条件的用户总数通过 X-Total-Count 返回。
+// with_federated=true 时为每个用户额外查询联合身份，with_roles=true 时额外查询 Realm 角色，
+// with_last_login=true 时从登录事件中查询最近登录时间 (用于发现长期未使用的账号)。
+// 这几项都是每个用户一次额外的 Keycloak 请求 (一页 100 个用户即 100 次往返，受并发上限约束)，
+// 会显著增加响应时间和 Keycloak 负载，因此默认不查询，只在界面确实需要展示时开启
 func GetUsers(c echo.Context) error {
-	// 创建一个带超时的 Context，防止请求 Keycloak 卡死
-	ctx, cancel := context.WithTimeout(c.Request().Context(), 10*time.Second)
+	first, err := parseNonNegativeInt(c.QueryParam("first"), 0)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "first must be a non-negative integer")
+	}
+	max, err := parseNonNegativeInt(c.QueryParam("max"), usersDefaultPageSize)
+	if err != nil || max == 0 || max > usersMaxPageSize {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("max must be an integer between 1 and %d", usersMaxPageSize))
+	}
+
+	withFederated, err := parseOptionalBool(c.QueryParam("with_federated"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "with_federated must be true or false")
+	}
+	withRoles, err := parseOptionalBool(c.QueryParam("with_roles"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "with_roles must be true or false")
+	}
+
+	withLastLogin, err := parseOptionalBool(c.QueryParam("with_last_login"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "with_last_login must be true or false")
+	}
+
+	// 创建一个带超时的 Context，防止请求 Keycloak 卡死；需要逐个用户补充信息时放宽超时
+	timeout := 10 * time.Second
+	if withFederated || withRoles || withLastLogin {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
 	defer cancel()
 
-	users, err := keycloak.FetchKeycloakUsers(ctx)
+	users, total, err := keycloak.FetchKeycloakUsers(ctx, keycloak.UserQuery{
+		First:  first,
+		Max:    max,
+		Search: strings.TrimSpace(c.QueryParam("search")),
+	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch users from Keycloak: "+err.Error())
 	}
+	if withFederated {
+		err := enrichUsers(ctx, users, func(ctx context.Context, u *models.KeycloakUser) error {
+			identities, err := keycloak.FetchUserFederatedIdentities(ctx, u.ID)
+			u.FederatedIdentities = identities
+			return err
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch federated identities from Keycloak: "+err.Error())
+		}
+	}
+	if withRoles {
+		err := enrichUsers(ctx, users, func(ctx context.Context, u *models.KeycloakUser) error {
+			roles, err := keycloak.FetchUserRealmRoles(ctx, u.ID)
+			u.Roles = roles
+			return err
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user roles from Keycloak: "+err.Error())
+		}
+	}
+	if withLastLogin {
+		err := enrichUsers(ctx, users, func(ctx context.Context, u *models.KeycloakUser) error {
+			lastLogin, err := keycloak.FetchUserLastLogin(ctx, u.ID)
+			u.LastLogin = lastLogin
+			return err
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch login events from Keycloak: "+err.Error())
+		}
+	}
+	c.Response().Header().Set("X-Total-Count", strconv.Itoa(total))
 	return c.JSON(http.StatusOK, users)
 }
 
+// enrichUsers 以有限并发对每个用户调用 fn 补充附加信息，返回遇到的第一个错误
+func enrichUsers(parent context.Context, users []models.KeycloakUser, fn func(context.Context, *models.KeycloakUser) error) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	sem := make(chan struct{}, userEnrichConcurrency)
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i := range users {
+		wg.Add(1)
+		go func(u *models.KeycloakUser) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return // 已有请求失败，跳过剩余用户
+			}
+			if err := fn(ctx, u); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(&users[i])
+	}
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = parent.Err() // 超时或客户端断开导致部分用户被跳过
+	}
+	return firstErr
+}
+
+// usersStreamPageSize 流式导出时每次向 Keycloak 请求的用户数
+const usersStreamPageSize = 500
+
+// StreamUsers 以 NDJSON (每行一个 JSON 对象) 流式导出 Keycloak 用户
+// 服务端逐页拉取并立即写出，适合大 Realm 的批量导出；最多导出 USERS_STREAM_MAX 个用户，
+// 可通过 max 参数进一步限制。流结束后通过 X-Stream-Status trailer 标明是否完整 (complete/error)
+func StreamUsers(c echo.Context) error {
+	limit := config.AppConfig.UsersStreamMax
+	max, err := parseNonNegativeInt(c.QueryParam("max"), 0)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "max must be a non-negative integer")
+	}
+	if max > 0 && max < limit {
+		limit = max
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	resp.Header().Set("Trailer", "X-Stream-Status")
+	resp.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(resp)
+	written := 0
+	err = keycloak.StreamKeycloakUsers(c.Request().Context(), usersStreamPageSize, limit, func(u models.KeycloakUser) error {
+		if err := enc.Encode(u); err != nil {
+			return err
+		}
+		written++
+		if written%usersStreamPageSize == 0 {
+			resp.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// 响应头已经发出，只能记录日志并通过 trailer 告知客户端导出不完整
+		logger.For(c).Error("user stream aborted", "written", written, "error", err)
+		resp.Header().Set("X-Stream-Status", "error")
+		return nil
+	}
+	resp.Header().Set("X-Stream-Status", "complete")
+	resp.Flush()
+	return nil
+}
+
 // UpdateUserStatus 启用或禁用 Keycloak 用户
 func UpdateUserStatus(c echo.Context) error {
 	userID := c.Param("id")
@@ -30,8 +198,8 @@ func UpdateUserStatus(c echo.Context) error {
 		Enabled bool `json:"enabled"`
 	}
 	su := new(StatusUpdate)
-	if err := c.Bind(su); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	if err := bindBody(c, su); err != nil {
+		return err
 	}
 
 	ctx, cancel := context.WithTimeout(c.Request().Context(), 10*time.Second)
@@ -43,3 +211,202 @@ func UpdateUserStatus(c echo.Context) error {
 	}
 	return c.NoContent(http.StatusOK)
 }
+
+// AssignUserRole 为用户分配 Realm 角色，请求体: {"role": "admin"}
+func AssignUserRole(c echo.Context) error {
+	return updateUserRole(c, true)
+}
+
+// RemoveUserRole 移除用户的 Realm 角色，请求体: {"role": "admin"}
+func RemoveUserRole(c echo.Context) error {
+	return updateUserRole(c, false)
+}
+
+// updateUserRole AssignUserRole / RemoveUserRole 的共同实现
+func updateUserRole(c echo.Context, assign bool) error {
+	userID := c.Param("id")
+	type RoleRequest struct {
+		Role string `json:"role"`
+	}
+	req := new(RoleRequest)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	req.Role = strings.TrimSpace(req.Role)
+	if req.Role == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "role is required")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 10*time.Second)
+	defer cancel()
+
+	err := keycloak.UpdateUserRealmRole(ctx, userID, req.Role, assign)
+	switch {
+	case errors.Is(err, keycloak.ErrRoleNotFound):
+		return apierror.New(http.StatusNotFound, "role_not_found", fmt.Sprintf("Role %q does not exist in the realm", req.Role))
+	case keycloak.IsNotFound(err):
+		return apierror.New(http.StatusNotFound, "user_not_found", "User not found")
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update user roles in Keycloak: "+err.Error())
+	}
+
+	action := "user.role.assign"
+	if !assign {
+		action = "user.role.remove"
+	}
+	recordAudit(c, action, "user", userID, map[string]interface{}{"role": req.Role})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// 批量修改用户状态的限制
+const (
+	maxBulkUserStatus         = 200 // 单次请求最多处理的用户数
+	bulkUserStatusConcurrency = 5   // 同时调用 Keycloak 的请求数
+)
+
+// BulkUserStatusResult 单个用户的批量处理结果
+type BulkUserStatusResult struct {
+	UserID              string `json:"user_id"`
+	Success             bool   `json:"success"`
+	Error               string `json:"error,omitempty"`
+	BindingsDeactivated int64  `json:"bindings_deactivated,omitempty"`
+}
+
+// BulkUpdateUserStatus 批量启用/禁用 Keycloak 用户 (例如整个团队离职)
+// 请求体: {"user_ids": [...], "enabled": false, "deactivate_bindings": true}，user_ids 也可以写作 ids
+// 逐个用户调用 Keycloak (有并发上限)，部分失败不影响其他用户，返回每个用户的结果
+// deactivate_bindings 仅在禁用时生效，将用户的 active 绑定置为 inactive
+func BulkUpdateUserStatus(c echo.Context) error {
+	type BulkStatusUpdate struct {
+		UserIDs            []string `json:"user_ids"`
+		IDs                []string `json:"ids"` // user_ids 的别名
+		Enabled            *bool    `json:"enabled"`
+		DeactivateBindings bool     `json:"deactivate_bindings"`
+	}
+	req := new(BulkStatusUpdate)
+	if err := bindBody(c, req); err != nil {
+		return err
+	}
+	if req.Enabled == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "enabled is required")
+	}
+	req.UserIDs = append(req.UserIDs, req.IDs...)
+	if len(req.UserIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "user_ids is required")
+	}
+	if len(req.UserIDs) > maxBulkUserStatus {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("At most %d user_ids are allowed per request", maxBulkUserStatus))
+	}
+	enabled := *req.Enabled
+	cascade := req.DeactivateBindings && !enabled
+
+	// 去重，保持请求顺序
+	seen := make(map[string]bool, len(req.UserIDs))
+	var userIDs []string
+	for _, id := range req.UserIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		userIDs = append(userIDs, id)
+	}
+
+	results := make([]BulkUserStatusResult, len(userIDs))
+	sem := make(chan struct{}, bulkUserStatusConcurrency)
+	var wg sync.WaitGroup
+	for i, id := range userIDs {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = updateUserStatus(c.Request().Context(), id, enabled, cascade)
+		}(i, id)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, r := range results {
+		if !r.Success {
+			continue
+		}
+		succeeded++
+		details := map[string]interface{}{"enabled": enabled}
+		if cascade {
+			details["bindings_deactivated"] = r.BindingsDeactivated
+		}
+		recordAudit(c, "user.status", "user", r.UserID, details)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+		"results":   results,
+	})
+}
+
+// updateUserStatus 修改单个用户状态，cascade 为 true 时同时停用其 active 绑定
+func updateUserStatus(ctx context.Context, userID string, enabled, cascade bool) BulkUserStatusResult {
+	result := BulkUserStatusResult{UserID: userID}
+
+	kcCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := keycloak.UpdateKeycloakUserStatus(kcCtx, userID, enabled); err != nil {
+		if keycloak.IsNotFound(err) {
+			result.Error = "User not found"
+		} else {
+			result.Error = "Failed to update user status in Keycloak: " + err.Error()
+		}
+		return result
+	}
+
+	if cascade {
+		deactivated, err := bindings.TransitionAll(ctx, db.DB.Where("keycloak_user_id = ?", userID),
+			models.BindingStatusActive, models.BindingStatusInactive)
+		if err != nil {
+			// Keycloak 状态已修改，只是绑定未能停用，仍视为失败以便调用方重试
+			result.Error = "User disabled but failed to deactivate bindings: " + err.Error()
+			return result
+		}
+		result.BindingsDeactivated = deactivated
+	}
+	result.Success = true
+	return result
+}
+
+// GetUserFederatedIdentities 获取单个用户关联的联合身份
+// Keycloak 接口本身不分页，这里支持 first/max 参数在内存中切片，总数通过 X-Total-Count 返回
+func GetUserFederatedIdentities(c echo.Context) error {
+	userID := c.Param("id")
+
+	first, err := parseNonNegativeInt(c.QueryParam("first"), 0)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "first must be a non-negative integer")
+	}
+	max, err := parseNonNegativeInt(c.QueryParam("max"), 0)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "max must be a non-negative integer")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 10*time.Second)
+	defer cancel()
+
+	identities, err := keycloak.FetchUserFederatedIdentities(ctx, userID)
+	if err != nil {
+		if keycloak.IsNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound, "User not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch federated identities from Keycloak: "+err.Error())
+	}
+
+	c.Response().Header().Set("X-Total-Count", strconv.Itoa(len(identities)))
+	if first > len(identities) {
+		first = len(identities)
+	}
+	identities = identities[first:]
+	if max > 0 && max < len(identities) {
+		identities = identities[:max]
+	}
+	return c.JSON(http.StatusOK, identities)
+}
@@ -0,0 +1,107 @@
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go-agent-manager/logger"
+)
+
+// Status 单个后台任务的健康状态
+type Status struct {
+	Name        string     `json:"name"`
+	Interval    string     `json:"interval"`     // 预期运行间隔
+	LastSuccess *time.Time `json:"last_success"` // 最近一次成功运行时间，从未成功时为空
+	Healthy     bool       `json:"healthy"`
+}
+
+type job struct {
+	interval     time.Duration
+	registeredAt time.Time
+	lastSuccess  time.Time
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]*job{}
+)
+
+// staleFactor 超过 staleFactor 倍预期间隔仍未成功运行即视为不健康，留出抖动和重试的余量
+const staleFactor = 2
+
+// Register 登记一个后台任务及其预期运行间隔
+// 重复调用会更新间隔 (例如 token 刷新间隔由过期时间决定)，但保留已有的心跳
+func Register(name string, interval time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	if j, ok := registry[name]; ok {
+		j.interval = interval
+		return
+	}
+	registry[name] = &job{interval: interval, registeredAt: time.Now()}
+}
+
+// Beat 记录任务的一次成功运行
+func Beat(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if j, ok := registry[name]; ok {
+		j.lastSuccess = time.Now()
+	}
+}
+
+// Health 返回所有已登记任务的状态，按名称排序
+func Health() []Status {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	now := time.Now()
+	statuses := make([]Status, 0, len(registry))
+	for name, j := range registry {
+		// 从未成功运行过的任务从登记时间开始计算
+		reference := j.registeredAt
+		st := Status{Name: name, Interval: j.interval.String()}
+		if !j.lastSuccess.IsZero() {
+			last := j.lastSuccess
+			st.LastSuccess = &last
+			reference = last
+		}
+		st.Healthy = now.Sub(reference) <= staleFactor*j.interval
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, k int) bool { return statuses[i].Name < statuses[k].Name })
+	return statuses
+}
+
+// Run 在后台按固定间隔执行 fn，每次成功后记录心跳，ctx 取消时退出
+// fn 中的 panic 会被捕获并记录，避免任务协程静默退出
+func Run(ctx context.Context, name string, interval time.Duration, fn func(ctx context.Context) error) {
+	Register(name, interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runOnce(ctx, name, fn)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func runOnce(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Log.Error("background job panicked", "job", name, "panic", r)
+		}
+	}()
+	if err := fn(ctx); err != nil {
+		logger.Log.Error("background job failed", "job", name, "error", err)
+		return
+	}
+	Beat(name)
+}
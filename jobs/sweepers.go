@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go-agent-manager/archive"
+	"go-agent-manager/audit"
+	"go-agent-manager/bindings"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/logger"
+	"go-agent-manager/models"
+)
+
+// 后台任务名称
+const (
+	AutoUnbindJob    = "binding-auto-unbind"
+	AuditPruneJob    = "audit-prune"
+	DeviceArchiveJob = "device-archive"
+)
+
+// Start 启动所有写数据库的后台清理任务
+func Start(ctx context.Context) {
+	Run(ctx, AutoUnbindJob, time.Minute, autoUnbindExpired)
+	Run(ctx, AuditPruneJob, time.Hour, pruneAuditLogs)
+	if config.AppConfig.DeviceArchiveAfter > 0 {
+		Run(ctx, DeviceArchiveJob, time.Hour, archiveStaleDevices)
+	}
+}
+
+// autoUnbindExpired 将已过期 (ExpiresAt 早于当前时间) 的 active 绑定置为 inactive
+func autoUnbindExpired(ctx context.Context) error {
+	now := time.Now()
+	var expired []models.UserDeviceBinding
+	err := db.DB.WithContext(ctx).
+		Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", models.BindingStatusActive, now).
+		Find(&expired).Error
+	if err != nil {
+		return err
+	}
+
+	for _, b := range expired {
+		if err := bindings.Transition(ctx, db.DB, &b, models.BindingStatusInactive); err != nil {
+			if errors.Is(err, bindings.ErrConcurrentUpdate) {
+				continue // 已被其他请求修改
+			}
+			return err
+		}
+		audit.Record(ctx, audit.Entry{
+			Action:       "binding.expire",
+			ResourceType: "binding",
+			ResourceID:   b.ID,
+			Details: map[string]interface{}{
+				"device_id":        b.DeviceID,
+				"keycloak_user_id": b.KeycloakUserID,
+				"expires_at":       b.ExpiresAt,
+			},
+		})
+	}
+	if len(expired) > 0 {
+		logger.Log.Info("expired bindings unbound", "count", len(expired))
+	}
+	return nil
+}
+
+// pruneAuditLogs 物理删除超过保留期的审计记录
+func pruneAuditLogs(ctx context.Context) error {
+	retention := config.AppConfig.AuditRetention
+	if retention <= 0 {
+		return nil // 0 表示永久保留
+	}
+	result := db.DB.WithContext(ctx).Unscoped().
+		Where("created_at < ?", time.Now().Add(-retention)).
+		Delete(&models.AuditLog{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		logger.Log.Info("pruned audit logs", "count", result.RowsAffected, "retention", retention.String())
+	}
+	return nil
+}
+
+// archiveStaleDevices 将长期未上报的设备及其绑定移入归档表
+func archiveStaleDevices(ctx context.Context) error {
+	after := config.AppConfig.DeviceArchiveAfter
+	count, err := archive.ArchiveStale(ctx, time.Now().Add(-after))
+	if count > 0 {
+		logger.Log.Info("archived stale devices", "count", count, "archive_after", after.String())
+	}
+	return err
+}
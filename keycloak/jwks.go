@@ -0,0 +1,222 @@
+package keycloak
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"go-agent-manager/config"
+	"go-agent-manager/logger"
+	"go-agent-manager/metrics"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Realm 公钥 (JWKS) 缓存参数
+const (
+	jwksRefreshInterval = 10 * time.Minute // 定期重新获取，跟上 Keycloak 的密钥轮换
+	jwksMinRefetchDelay = 30 * time.Second // 遇到未知 kid 时提前刷新的最小间隔，避免伪造的 kid 打爆 Keycloak
+)
+
+// errLocalValidationInconclusive 本地无法判断 token 是否有效 (没有 exp、找不到签名密钥或 JWKS 不可用)，
+// 调用方应退回 introspection
+var errLocalValidationInconclusive = errors.New("local token validation inconclusive")
+
+// jwksSigningMethods 本地验签接受的算法 (Keycloak 的非对称签名算法)
+var jwksSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// jwksCache 缓存 Realm 的签名公钥，按 kid 索引
+type jwksCache struct {
+	fetch       func(ctx context.Context) (map[string]crypto.PublicKey, error) // 获取 JWKS，测试中可替换
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+var realmKeys = &jwksCache{fetch: fetchRealmKeys}
+
+// key 返回 kid 对应的公钥；缓存过期或 kid 未知时重新获取 JWKS
+// 获取失败且缓存中也没有该 kid 时返回 errLocalValidationInconclusive。
+// 请求 Keycloak 时不持有锁: 同一时刻只有一个请求去获取 (lastAttempt 在获取前更新)，
+// 其余请求直接使用现有缓存，找不到 kid 时退回 introspection，而不是排队等待网络请求
+func (j *jwksCache) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	now := time.Now()
+	key, ok := j.keys[kid]
+	stale := now.Sub(j.fetchedAt) > jwksRefreshInterval
+	refetch := (stale || !ok) && now.Sub(j.lastAttempt) > jwksMinRefetchDelay
+	if refetch {
+		j.lastAttempt = now
+	}
+	j.mu.Unlock()
+
+	if refetch {
+		if keys, err := j.fetch(ctx); err != nil {
+			logger.Log.Warn("failed to fetch Keycloak realm keys", "error", err)
+		} else {
+			j.mu.Lock()
+			// 较晚发起的获取可能已先完成，只保留最新的结果
+			if !j.fetchedAt.After(now) {
+				j.keys = keys
+				j.fetchedAt = now
+			}
+			key, ok = j.keys[kid]
+			j.mu.Unlock()
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: no signing key with kid %q", errLocalValidationInconclusive, kid)
+	}
+	return key, nil
+}
+
+// fetchRealmKeys 从 Keycloak 获取 Realm 的 JWKS 并解析出签名公钥
+// 不支持的密钥类型 (例如加密用途的密钥) 直接跳过
+func fetchRealmKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	start := time.Now()
+	certs, err := kcClient.GetCerts(ctx, config.AppConfig.Keycloak.Realm)
+	metrics.ObserveKeycloakRequest(opGetCerts, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	if certs.Keys == nil {
+		return keys, nil
+	}
+	for _, k := range *certs.Keys {
+		if k.Kid == nil || (k.Use != nil && *k.Use != "sig") {
+			continue
+		}
+		key, err := parseJWK(k)
+		if err != nil {
+			logger.Log.Warn("skipping Keycloak realm key", "kid", *k.Kid, "error", err)
+			continue
+		}
+		keys[*k.Kid] = key
+	}
+	return keys, nil
+}
+
+// parseJWK 把 JWK 转换为 RSA 或 EC 公钥
+func parseJWK(k gocloak.CertResponseKey) (crypto.PublicKey, error) {
+	if k.Kty == nil {
+		return nil, errors.New("missing kty")
+	}
+	switch *k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch {
+		case k.Crv == nil:
+			return nil, errors.New("missing crv")
+		case *k.Crv == "P-256":
+			curve = elliptic.P256()
+		case *k.Crv == "P-384":
+			curve = elliptic.P384()
+		case *k.Crv == "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", *k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", *k.Kty)
+	}
+}
+
+// decodeJWKInt 解码 JWK 中 base64url 编码的大整数
+func decodeJWKInt(v *string) (*big.Int, error) {
+	if v == nil || *v == "" {
+		return nil, errors.New("missing value")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*v, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// validateTokenLocally 使用缓存的 Realm 公钥校验 token 的签名、exp、iss 和 aud
+// 签名无效、已过期、签发方或受众不符时返回错误；无法判断时返回 errLocalValidationInconclusive
+func validateTokenLocally(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	// 没有 exp 的 token 无法在本地判断是否已被吊销或过期，交给 introspection
+	unverified := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, unverified); err != nil {
+		return nil, err
+	}
+	if exp, err := unverified.GetExpirationTime(); err != nil || exp == nil {
+		return nil, fmt.Errorf("%w: token has no exp claim", errLocalValidationInconclusive)
+	}
+
+	issuer := strings.TrimRight(config.AppConfig.Keycloak.AuthServerURL, "/") + "/realms/" + config.AppConfig.Keycloak.Realm
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(jwksSigningMethods),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return realmKeys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !audienceMatches(claims, config.AppConfig.Keycloak.FrontendClientID) {
+		return nil, fmt.Errorf("token was not issued for client %q", config.AppConfig.Keycloak.FrontendClientID)
+	}
+	return claims, nil
+}
+
+// audienceMatches 判断 token 是否签发给指定 client
+// Keycloak 的 Access Token 默认 aud 为 "account"，签发 token 的 client 记录在 azp 中，因此两者任一匹配即可
+func audienceMatches(claims jwt.MapClaims, clientID string) bool {
+	if azp, ok := claims["azp"].(string); ok && azp == clientID {
+		return true
+	}
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return false
+	}
+	for _, a := range aud {
+		if a == clientID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package keycloak
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testPublicKey(n int64) crypto.PublicKey {
+	return &rsa.PublicKey{N: big.NewInt(n), E: 65537}
+}
+
+func TestJWKSCacheFetchesWithoutHoldingLock(t *testing.T) {
+	oldKey, newKey := testPublicKey(1), testPublicKey(2)
+	started, release := make(chan struct{}), make(chan struct{})
+	cache := &jwksCache{
+		keys:      map[string]crypto.PublicKey{"old": oldKey},
+		fetchedAt: time.Now().Add(-2 * jwksRefreshInterval), // 已过期，下一次查询触发获取
+		fetch: func(ctx context.Context) (map[string]crypto.PublicKey, error) {
+			close(started)
+			<-release
+			return map[string]crypto.PublicKey{"new": newKey}, nil
+		},
+	}
+
+	type result struct {
+		key crypto.PublicKey
+		err error
+	}
+	refreshed := make(chan result, 1)
+	go func() {
+		key, err := cache.key(context.Background(), "new")
+		refreshed <- result{key, err}
+	}()
+	<-started
+
+	// 获取进行中: 其他请求不应等待网络请求，已缓存的 kid 直接返回，未知 kid 交给 introspection
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if key, err := cache.key(context.Background(), "old"); err != nil || key != oldKey {
+			t.Errorf("cached kid during fetch = %v, %v; want cached key", key, err)
+		}
+		if _, err := cache.key(context.Background(), "other"); !errors.Is(err, errLocalValidationInconclusive) {
+			t.Errorf("unknown kid during fetch: err = %v, want errLocalValidationInconclusive", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("key lookups blocked while JWKS fetch was in progress")
+	}
+
+	close(release)
+	got := <-refreshed
+	if got.err != nil || got.key != newKey {
+		t.Fatalf("refreshed lookup = %v, %v; want fetched key", got.key, got.err)
+	}
+	if _, ok := cache.keys["old"]; ok {
+		t.Error("fetched key set was not swapped in")
+	}
+}
+
+func TestJWKSCacheKeepsKeysOnFetchError(t *testing.T) {
+	oldKey := testPublicKey(1)
+	fetches := 0
+	cache := &jwksCache{
+		keys:      map[string]crypto.PublicKey{"old": oldKey},
+		fetchedAt: time.Now().Add(-2 * jwksRefreshInterval),
+		fetch: func(ctx context.Context) (map[string]crypto.PublicKey, error) {
+			fetches++
+			return nil, errors.New("keycloak unavailable")
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		if key, err := cache.key(context.Background(), "old"); err != nil || key != oldKey {
+			t.Fatalf("lookup %d = %v, %v; want cached key", i, key, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("fetched %d times, want 1 within jwksMinRefetchDelay", fetches)
+	}
+}
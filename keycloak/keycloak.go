@@ -3,47 +3,137 @@ package keycloak
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"go-agent-manager/config"
+	"go-agent-manager/jobs"
+	"go-agent-manager/metrics"
 	"go-agent-manager/models"
 
 	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 var (
-	kcClient      *gocloak.GoCloak
-	adminToken    *gocloak.JWT
-	tokenMutex    sync.RWMutex
-	tokenRefreshC chan bool
+	kcClient   *gocloak.GoCloak
+	adminToken *gocloak.JWT
+	// adminTokenExpiresAt / adminTokenRefreshedAt 由 setAdminToken 维护，受 tokenMutex 保护
+	adminTokenExpiresAt   time.Time
+	adminTokenRefreshedAt time.Time
+	tokenMutex            sync.RWMutex
+	tokenRefreshC         chan bool
+	// refresherStop 关闭后刷新协程退出，退出完成时关闭 refresherDone
+	refresherStop chan struct{}
+	refresherDone chan struct{}
+
+	// adminSlots 限制同时进行的 Admin API 调用数 (KEYCLOAK_MAX_CONCURRENCY)，对所有批量操作共享
+	adminSlots chan struct{}
+)
+
+// TokenRefreshJob 管理员 token 刷新协程在 jobs 健康检查中的名称
+const TokenRefreshJob = "keycloak-token-refresh"
+
+// Keycloak 调用在 keycloak_request_duration_seconds 中的 operation 标签
+const (
+	opLogin                  = "login"
+	opIntrospect             = "introspect"
+	opDecodeToken            = "decode_token"
+	opGetUsers               = "get_users"
+	opGetUserCount           = "get_user_count"
+	opGetUser                = "get_user"
+	opUpdateUser             = "update_user"
+	opGetFederatedIdentities = "get_federated_identities"
+	opGetUserRoles           = "get_user_roles"
+	opGetCerts               = "get_certs"
+	opGetRealmRole           = "get_realm_role"
+	opUpdateUserRoles        = "update_user_roles"
+	opGetEvents              = "get_events"
 )
 
 // InitKeycloak 初始化 Keycloak 客户端
+// 启动时先规范化并校验 KEYCLOAK_AUTH_SERVER_URL，再探测 Realm 以尽早提示 /auth 前缀配置错误
 func InitKeycloak() {
-	kcClient = gocloak.NewClient(config.AppConfig.Keycloak.AuthServerURL)
+	authServerURL, err := normalizeAuthServerURL(config.AppConfig.Keycloak.AuthServerURL)
+	if err != nil {
+		log.Fatalf("Invalid Keycloak configuration: %v", err)
+	}
+	config.AppConfig.Keycloak.AuthServerURL = authServerURL
+	diagnoseAuthServerURL(authServerURL, config.AppConfig.Keycloak.Realm)
+
+	kcClient = gocloak.NewClient(authServerURL)
+	maxConcurrency := config.AppConfig.Keycloak.MaxConcurrency
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	adminSlots = make(chan struct{}, maxConcurrency)
+	tokens.configure(config.AppConfig.Keycloak.TokenCacheTTL, config.AppConfig.Keycloak.TokenCacheMaxEntries)
 	tokenRefreshC = make(chan bool, 1)
+	refresherStop = make(chan struct{})
+	refresherDone = make(chan struct{})
+	jobs.Register(TokenRefreshJob, time.Minute)
 	go startAdminTokenRefresher()
 	tokenRefreshC <- true
 }
 
+// StopAdminTokenRefresher 停止管理员 token 刷新协程并等待其退出 (服务优雅退出时调用)
+// 已获取的 token 仍可继续使用，过期后由 getAdminAccessToken 按需重新登录
+func StopAdminTokenRefresher() {
+	if refresherStop == nil {
+		return
+	}
+	close(refresherStop)
+	<-refresherDone
+}
+
+// scheduleAdminTokenRefresh 在 d 之后触发一次刷新；刷新已在排队或协程已退出时不重复发送
+func scheduleAdminTokenRefresh(d time.Duration) {
+	time.AfterFunc(d, func() {
+		select {
+		case tokenRefreshC <- true:
+		default:
+		}
+	})
+}
+
+// acquireAdminSlot 在调用 Admin API 前获取一个并发名额，名额用尽时等待，ctx 结束时放弃
+// 调用方必须在调用结束后执行返回的 release
+func acquireAdminSlot(ctx context.Context) (release func(), err error) {
+	if adminSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case adminSlots <- struct{}{}:
+		return func() { <-adminSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// adminTokenMinValidity token 剩余有效期低于该值时视为过期，重新登录
+const adminTokenMinValidity = 30 * time.Second
+
 // getAdminAccessToken 获取管理员 Access Token
+// token 不存在、已过期或即将过期时就地重新登录，不依赖后台刷新协程是否仍在正常运行
 func getAdminAccessToken() (string, error) {
 	tokenMutex.RLock()
-	// 简单判断：如果 token 存在，直接返回
-	// 依赖后台协程定时刷新来保证有效性
-	if adminToken != nil {
+	if adminTokenUsable(time.Now()) {
+		token := adminToken.AccessToken
 		tokenMutex.RUnlock()
-		return adminToken.AccessToken, nil
+		return token, nil
 	}
 	tokenMutex.RUnlock()
 
 	tokenMutex.Lock()
 	defer tokenMutex.Unlock()
 
-	// 双重检查
-	if adminToken != nil {
+	// 双重检查: 等待写锁期间可能已有其他调用完成登录
+	if adminTokenUsable(time.Now()) {
 		return adminToken.AccessToken, nil
 	}
 
@@ -51,42 +141,66 @@ func getAdminAccessToken() (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var err error
 	// LoginClient 使用 Client Credentials Grant
-	adminToken, err = kcClient.LoginClient(
+	// 登录失败时保留原 token 不变 (过期的 token 不会再被返回)
+	start := time.Now()
+	token, err := kcClient.LoginClient(
 		ctx,
 		config.AppConfig.Keycloak.AdminClientID,
 		config.AppConfig.Keycloak.AdminClientSecret,
 		config.AppConfig.Keycloak.Realm,
 	)
+	metrics.ObserveKeycloakRequest(opLogin, start, err)
 	if err != nil {
 		return "", err
 	}
+	setAdminToken(token)
 	log.Println("Keycloak Admin Access Token acquired successfully.")
-	return adminToken.AccessToken, nil
+	return token.AccessToken, nil
+}
+
+// CheckAdminToken 检查能否获取管理员 token (用于就绪检查)
+// 当前 token 仍然有效时不会请求 Keycloak
+func CheckAdminToken() error {
+	_, err := getAdminAccessToken()
+	return err
+}
+
+// adminTokenUsable 判断当前管理员 token 是否存在且距离过期超过 adminTokenMinValidity (调用方持有 tokenMutex)
+func adminTokenUsable(now time.Time) bool {
+	return adminToken != nil && adminTokenExpiresAt.Sub(now) > adminTokenMinValidity
 }
 
 // startAdminTokenRefresher 启动一个协程定时刷新管理员 token
 func startAdminTokenRefresher() {
-	for range tokenRefreshC {
+	defer close(refresherDone)
+	for {
+		select {
+		case <-refresherStop:
+			return
+		case <-tokenRefreshC:
+		}
+
 		tokenMutex.Lock()
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		start := time.Now()
 		token, err := kcClient.LoginClient(
 			ctx,
 			config.AppConfig.Keycloak.AdminClientID,
 			config.AppConfig.Keycloak.AdminClientSecret,
 			config.AppConfig.Keycloak.Realm,
 		)
+		metrics.ObserveKeycloakRequest(opLogin, start, err)
 		cancel()
 
 		if err != nil {
 			tokenMutex.Unlock()
 			log.Printf("Failed to refresh Keycloak Admin token: %v. Retrying in 10 seconds...", err)
-			time.AfterFunc(10*time.Second, func() { tokenRefreshC <- true })
+			scheduleAdminTokenRefresh(10 * time.Second)
 			continue
 		}
 
-		adminToken = token
+		setAdminToken(token)
 		tokenMutex.Unlock()
 
 		// 计算下次刷新时间：提前 30 秒刷新
@@ -95,11 +209,71 @@ func startAdminTokenRefresher() {
 			expiresIn = 1
 		}
 		log.Printf("Keycloak Admin token will refresh in %d seconds.", expiresIn)
-		time.AfterFunc(time.Duration(expiresIn)*time.Second, func() { tokenRefreshC <- true })
+		// 刷新间隔由 token 有效期决定，每次成功后更新登记的间隔并记录心跳
+		jobs.Register(TokenRefreshJob, time.Duration(expiresIn)*time.Second)
+		jobs.Beat(TokenRefreshJob)
+		scheduleAdminTokenRefresh(time.Duration(expiresIn) * time.Second)
+	}
+}
+
+// setAdminToken 保存新的管理员 token 及其过期时间 (调用方持有 tokenMutex 写锁)
+func setAdminToken(token *gocloak.JWT) {
+	now := time.Now()
+	adminToken = token
+	adminTokenExpiresAt = accessTokenExpiry(token, now)
+	adminTokenRefreshedAt = now
+}
+
+// accessTokenExpiry 读取 Access Token 中的 exp claim 作为过期时间
+// 这是自己刚登录拿到的 token，只解析不验签；无法解析时退回到登录响应中的 expires_in
+func accessTokenExpiry(token *gocloak.JWT, issuedAt time.Time) time.Time {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token.AccessToken, claims); err == nil {
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			return exp.Time
+		}
+	}
+	return issuedAt.Add(time.Duration(token.ExpiresIn) * time.Second)
+}
+
+// ForceRefreshAdminToken 立即重新登录获取管理员 token，例如轮换 client secret 之后
+// 并发调用会被合并: 等待锁期间已有其他调用完成刷新时直接返回该结果
+// 登录失败时保留原 token 不变；返回新 token 的过期时间
+func ForceRefreshAdminToken(ctx context.Context) (time.Time, error) {
+	requestedAt := time.Now()
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+
+	if adminToken != nil && adminTokenRefreshedAt.After(requestedAt) {
+		return adminTokenExpiresAt, nil
+	}
+
+	start := time.Now()
+	token, err := kcClient.LoginClient(
+		ctx,
+		config.AppConfig.Keycloak.AdminClientID,
+		config.AppConfig.Keycloak.AdminClientSecret,
+		config.AppConfig.Keycloak.Realm,
+	)
+	metrics.ObserveKeycloakRequest(opLogin, start, err)
+	if err != nil {
+		return time.Time{}, err
 	}
+	setAdminToken(token)
+	jobs.Beat(TokenRefreshJob)
+	log.Println("Keycloak Admin Access Token force-refreshed.")
+	return adminTokenExpiresAt, nil
 }
 
+// ValidateAccessToken 返回的错误类型，调用方用 errors.Is 区分
+var (
+	ErrTokenInactive       = errors.New("token is not active")     // 已过期、已注销或已吊销
+	ErrTokenInvalid        = errors.New("token is invalid")        // 格式错误、签名无效或签发方/受众不符
+	ErrKeycloakUnavailable = errors.New("keycloak is unavailable") // 无法连接 Keycloak 或 Keycloak 返回服务端错误
+)
+
 // ValidateAccessToken 验证从前端传来的用户 Access Token
+// token 本身的问题返回 ErrTokenInactive / ErrTokenInvalid，Keycloak 不可用时返回 ErrKeycloakUnavailable
 func ValidateAccessToken(ctx context.Context, tokenString string) (string, []string, error) {
 	// 调用 getAdminAccessToken 主要是为了确保 Keycloak 服务本身是通的，或者 introspect 需要 token
 	// 但 v13 的 RetrospectToken 只需要 clientID/Secret，不需要 admin token。
@@ -109,7 +283,55 @@ func ValidateAccessToken(ctx context.Context, tokenString string) (string, []str
 	
 	// 如果您使用的是 Confidential Client (有 secret)，Retrospect 不需要 Admin Token。
 	
+	// 短时间内重复出现的 token 直接使用缓存结果，不再请求 Keycloak
+	cacheKey := hashToken(tokenString)
+	if entry, ok := tokens.get(cacheKey); ok {
+		return entry.sub, entry.roles, nil
+	}
+	startedAt := time.Now()
+
+	var claimsMap jwt.MapClaims
+	if config.AppConfig.Keycloak.TokenValidationMode == config.TokenValidationJWKS {
+		// 用缓存的 Realm 公钥在本地验签，只有本地无法得出结论时才退回 introspection
+		claims, err := validateTokenLocally(ctx, tokenString)
+		switch {
+		case err == nil:
+			claimsMap = claims
+		case errors.Is(err, jwt.ErrTokenExpired), errors.Is(err, jwt.ErrTokenNotValidYet):
+			return "", nil, fmt.Errorf("%w: %v", ErrTokenInactive, err)
+		case !errors.Is(err, errLocalValidationInconclusive):
+			return "", nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+		}
+	}
+	if claimsMap == nil {
+		var err error
+		if claimsMap, err = introspectToken(ctx, tokenString); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// 获取 User ID (sub)
+	sub, ok := claimsMap["sub"].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: sub claim not found or invalid", ErrTokenInvalid)
+	}
+
+	// 获取 Roles (位置由 ROLES_CLAIM_PATH 决定，默认 realm_access.roles)
+	roles := extractRoles(claimsMap, config.AppConfig.Keycloak.RolesClaimPath)
+
+	var tokenExp time.Time
+	if exp, ok := claimsMap["exp"].(float64); ok {
+		tokenExp = time.Unix(int64(exp), 0)
+	}
+	tokens.put(cacheKey, sub, roles, startedAt, tokenExp)
+
+	return sub, roles, nil
+}
+
+// introspectToken 通过 Keycloak introspection 校验 token 是否有效，并解码出 claims
+func introspectToken(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
 	// 1. 验证 Token 有效性 (Introspection)
+	start := time.Now()
 	result, err := kcClient.RetrospectToken(
 		ctx,
 		tokenString,
@@ -117,78 +339,369 @@ func ValidateAccessToken(ctx context.Context, tokenString string) (string, []str
 		config.AppConfig.Keycloak.AdminClientSecret,
 		config.AppConfig.Keycloak.Realm,
 	)
+	metrics.ObserveKeycloakRequest(opIntrospect, start, err)
 	if err != nil {
-		return "", nil, err
+		// 无效的 token 会得到 active=false 而不是错误，因此这里的错误都来自 Keycloak 本身或网络
+		return nil, fmt.Errorf("%w: %v", ErrKeycloakUnavailable, err)
 	}
 
-	if !*result.Active {
-		return "", nil, errors.New("token is not active")
+	if result.Active == nil || !*result.Active {
+		return nil, ErrTokenInactive
 	}
 
 	// 2. 解析 Token 获取用户信息 (Decode)
 	// DecodeAccessToken 不需要额外的权限，只需要 JWT 字符串
+	decodeStart := time.Now()
 	_, claims, err := kcClient.DecodeAccessToken(ctx, tokenString, config.AppConfig.Keycloak.Realm)
+	metrics.ObserveKeycloakRequest(opDecodeToken, decodeStart, err)
 	if err != nil {
-		return "", nil, err
+		// DecodeAccessToken 需要从 Keycloak 获取证书，区分网络/服务端错误和 token 本身的问题
+		if isUnavailable(err) {
+			return nil, fmt.Errorf("%w: %v", ErrKeycloakUnavailable, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
 	}
 
 	// claims 类型是 *jwt.MapClaims，解引用后就是 map[string]interface{}
-	claimsMap := *claims
+	return *claims, nil
+}
 
-	// 获取 User ID (sub)
-	sub, ok := claimsMap["sub"].(string)
-	if !ok {
-		return "", nil, errors.New("sub claim not found or invalid")
+// isUnavailable 判断错误是否由网络故障、超时或 Keycloak 服务端错误导致
+func isUnavailable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var apiErr *gocloak.APIError
+	return errors.As(err, &apiErr) && (apiErr.Code == 0 || apiErr.Code >= http.StatusInternalServerError)
+}
+
+// extractRoles 按点分隔路径 (例如 "resource_access.my-client.roles" 或 "groups") 从 claims 中提取角色
+// 路径末端支持以下形式:
+//   - 字符串数组: ["admin", "user"] (JSON 解码得到的 []interface{} 或直接构造的 []string)
+//   - 对象数组: [{"name": "admin"}, ...]，取每个对象的 name 字段
+//   - 单个字符串: "admin user"，按空格或逗号拆分 (例如 scope 风格的 claim)
+//
+// 路径不存在或类型不符合时返回空列表
+func extractRoles(claims map[string]interface{}, path string) []string {
+	var current interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if current, ok = m[key]; !ok {
+			return nil
+		}
 	}
 
-	// 获取 Roles
 	var roles []string
-	if realmAccess, ok := claimsMap["realm_access"].(map[string]interface{}); ok {
-		if realmRoles, ok := realmAccess["roles"].([]interface{}); ok {
-			for _, role := range realmRoles {
-				if rStr, ok := role.(string); ok {
-					roles = append(roles, rStr)
+	switch v := current.(type) {
+	case string:
+		roles = strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' })
+	case []string:
+		roles = append(roles, v...)
+	case []interface{}:
+		for _, item := range v {
+			switch role := item.(type) {
+			case string:
+				roles = append(roles, role)
+			case map[string]interface{}:
+				if name, ok := role["name"].(string); ok {
+					roles = append(roles, name)
 				}
 			}
 		}
 	}
+	return roles
+}
 
-	return sub, roles, nil
+// UserQuery 分页查询 Keycloak 用户的参数
+type UserQuery struct {
+	First  int
+	Max    int
+	Search string // 在用户名、邮箱、姓名中模糊搜索，为空表示不过滤
 }
 
-// FetchKeycloakUsers 从 Keycloak 获取所有用户
-func FetchKeycloakUsers(ctx context.Context) ([]models.KeycloakUser, error) {
+// FetchKeycloakUsers 从 Keycloak 分页获取用户，同时返回符合条件的用户总数
+func FetchKeycloakUsers(ctx context.Context, q UserQuery) ([]models.KeycloakUser, int, error) {
 	// 这里必须使用 Admin Token
 	adminAccessToken, err := getAdminAccessToken()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	params := gocloak.GetUsersParams{
-		// 默认获取所有
+		First: gocloak.IntP(q.First),
+		Max:   gocloak.IntP(q.Max),
+	}
+	countParams := gocloak.GetUsersParams{}
+	if q.Search != "" {
+		params.Search = gocloak.StringP(q.Search)
+		countParams.Search = gocloak.StringP(q.Search)
+	}
+
+	release, err := acquireAdminSlot(ctx)
+	if err != nil {
+		return nil, 0, err
 	}
+	defer release()
 
+	start := time.Now()
 	kcUsers, err := kcClient.GetUsers(ctx, adminAccessToken, config.AppConfig.Keycloak.Realm, params)
+	metrics.ObserveKeycloakRequest(opGetUsers, start, err)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	start = time.Now()
+	total, err := kcClient.GetUserCount(ctx, adminAccessToken, config.AppConfig.Keycloak.Realm, countParams)
+	metrics.ObserveKeycloakRequest(opGetUserCount, start, err)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	var users []models.KeycloakUser
+	users := make([]models.KeycloakUser, 0, len(kcUsers))
 	for _, kcu := range kcUsers {
-		user := models.KeycloakUser{
-			ID:            gocloak.PString(kcu.ID),
-			Username:      gocloak.PString(kcu.Username),
-			Email:         gocloak.PString(kcu.Email),
-			FirstName:     gocloak.PString(kcu.FirstName),
-			LastName:      gocloak.PString(kcu.LastName),
-			Enabled:       gocloak.PBool(kcu.Enabled),
-			EmailVerified: gocloak.PBool(kcu.EmailVerified),
+		users = append(users, toKeycloakUser(kcu))
+	}
+	return users, total, nil
+}
+
+// StreamKeycloakUsers 按页遍历 Keycloak 用户并逐个回调 fn，不在内存中缓存整个用户列表
+// 最多遍历 limit 个用户；ctx 取消或 fn 返回错误时立即停止
+func StreamKeycloakUsers(ctx context.Context, pageSize, limit int, fn func(models.KeycloakUser) error) error {
+	for first := 0; first < limit; first += pageSize {
+		// 每页重新获取 token，长时间的流式导出期间 token 可能已被刷新
+		adminAccessToken, err := getAdminAccessToken()
+		if err != nil {
+			return err
+		}
+
+		max := pageSize
+		if remaining := limit - first; remaining < max {
+			max = remaining
+		}
+		params := gocloak.GetUsersParams{
+			First: gocloak.IntP(first),
+			Max:   gocloak.IntP(max),
+		}
+		release, err := acquireAdminSlot(ctx)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		kcUsers, err := kcClient.GetUsers(ctx, adminAccessToken, config.AppConfig.Keycloak.Realm, params)
+		metrics.ObserveKeycloakRequest(opGetUsers, start, err)
+		release() // 回调 fn 期间不占用名额
+		if err != nil {
+			return err
+		}
+		for _, kcu := range kcUsers {
+			if err := fn(toKeycloakUser(kcu)); err != nil {
+				return err
+			}
+		}
+		if len(kcUsers) < max {
+			return nil // 最后一页
+		}
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		// 暂时忽略 FederatedIdentities 以简化
-		users = append(users, user)
 	}
+	return nil
+}
+
+// toKeycloakUser 将 gocloak 用户表示转换为前端使用的 DTO
+func toKeycloakUser(kcu *gocloak.User) models.KeycloakUser {
+	return models.KeycloakUser{
+		ID:               gocloak.PString(kcu.ID),
+		Username:         gocloak.PString(kcu.Username),
+		Email:            gocloak.PString(kcu.Email),
+		FirstName:        gocloak.PString(kcu.FirstName),
+		LastName:         gocloak.PString(kcu.LastName),
+		Enabled:          gocloak.PBool(kcu.Enabled),
+		EmailVerified:    gocloak.PBool(kcu.EmailVerified),
+		CreatedTimestamp: millisToTime(kcu.CreatedTimestamp),
+	}
+}
+
+// millisToTime 将 Keycloak 的毫秒时间戳转换为 UTC 时间，未设置时返回 nil
+func millisToTime(ms *int64) *time.Time {
+	if ms == nil || *ms <= 0 {
+		return nil
+	}
+	t := time.UnixMilli(*ms).UTC()
+	return &t
+}
 
-	return users, nil
+// FetchUserLastLogin 从 Keycloak 登录事件中获取用户最近一次登录时间
+// 依赖 Realm 开启保存 LOGIN 事件 (Realm settings → Events)，且事件会按过期时间清理；没有事件时返回 nil
+func FetchUserLastLogin(ctx context.Context, userID string) (*time.Time, error) {
+	adminAccessToken, err := getAdminAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := acquireAdminSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	max := int32(1) // Keycloak 按时间倒序返回事件
+	start := time.Now()
+	events, err := kcClient.GetEvents(ctx, adminAccessToken, config.AppConfig.Keycloak.Realm, gocloak.GetEventsParams{
+		Type:   []string{"LOGIN"},
+		UserID: gocloak.StringP(userID),
+		Max:    &max,
+	})
+	metrics.ObserveKeycloakRequest(opGetEvents, start, err)
+	release()
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+	return millisToTime(&events[0].Time), nil
+}
+
+// FetchUserRealmRoles 获取单个用户直接分配的 Realm 角色名
+func FetchUserRealmRoles(ctx context.Context, userID string) ([]string, error) {
+	adminAccessToken, err := getAdminAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := acquireAdminSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	kcRoles, err := kcClient.GetRealmRolesByUserID(ctx, adminAccessToken, config.AppConfig.Keycloak.Realm, userID)
+	metrics.ObserveKeycloakRequest(opGetUserRoles, start, err)
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]string, 0, len(kcRoles))
+	for _, r := range kcRoles {
+		roles = append(roles, gocloak.PString(r.Name))
+	}
+	return roles, nil
+}
+
+// FetchUserFederatedIdentities 获取单个用户关联的联合身份 (Google 等外部 IdP)
+func FetchUserFederatedIdentities(ctx context.Context, userID string) ([]models.FederatedIdentity, error) {
+	adminAccessToken, err := getAdminAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := acquireAdminSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	kcIdentities, err := kcClient.GetUserFederatedIdentities(ctx, adminAccessToken, config.AppConfig.Keycloak.Realm, userID)
+	metrics.ObserveKeycloakRequest(opGetFederatedIdentities, start, err)
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	// 没有关联任何外部身份时返回空数组而不是 null
+	identities := make([]models.FederatedIdentity, 0, len(kcIdentities))
+	for _, fi := range kcIdentities {
+		identities = append(identities, models.FederatedIdentity{
+			IdentityProvider: gocloak.PString(fi.IdentityProvider),
+			UserID:           gocloak.PString(fi.UserID),
+			UserName:         gocloak.PString(fi.UserName),
+		})
+	}
+	return identities, nil
+}
+
+// ResolveUsernames 批量解析用户 ID 对应的用户名
+// 用于展示类场景: 查询失败或用户已不存在的 ID 不会出现在结果中，不返回错误
+func ResolveUsernames(ctx context.Context, userIDs []string) map[string]string {
+	usernames := make(map[string]string, len(userIDs))
+	if len(userIDs) == 0 {
+		return usernames
+	}
+	adminAccessToken, err := getAdminAccessToken()
+	if err != nil {
+		log.Printf("Failed to resolve usernames: %v", err)
+		return usernames
+	}
+	for _, id := range userIDs {
+		release, err := acquireAdminSlot(ctx)
+		if err != nil {
+			log.Printf("Failed to resolve usernames: %v", err)
+			return usernames
+		}
+		start := time.Now()
+		user, err := kcClient.GetUserByID(ctx, adminAccessToken, config.AppConfig.Keycloak.Realm, id)
+		metrics.ObserveKeycloakRequest(opGetUser, start, err)
+		release()
+		if err != nil {
+			if !IsNotFound(err) {
+				log.Printf("Failed to resolve username for user %s: %v", id, err)
+			}
+			continue
+		}
+		usernames[id] = gocloak.PString(user.Username)
+	}
+	return usernames
+}
+
+// IsNotFound 判断 Keycloak Admin API 返回的错误是否为 404
+func IsNotFound(err error) bool {
+	var apiErr *gocloak.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// ErrRoleNotFound Realm 中不存在指定角色
+var ErrRoleNotFound = errors.New("realm role not found")
+
+// UpdateUserRealmRole 为用户分配 (assign 为 true) 或移除 Realm 角色
+// 先通过 GetRealmRole 解析角色，角色不存在时返回 ErrRoleNotFound；用户不存在时 IsNotFound(err) 为 true
+func UpdateUserRealmRole(ctx context.Context, userID, roleName string, assign bool) error {
+	adminAccessToken, err := getAdminAccessToken()
+	if err != nil {
+		return err
+	}
+
+	// 解析角色和修改分配作为一次操作占用同一个名额
+	release, err := acquireAdminSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	realm := config.AppConfig.Keycloak.Realm
+	start := time.Now()
+	role, err := kcClient.GetRealmRole(ctx, adminAccessToken, realm, roleName)
+	metrics.ObserveKeycloakRequest(opGetRealmRole, start, err)
+	if IsNotFound(err) {
+		return ErrRoleNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	start = time.Now()
+	if assign {
+		err = kcClient.AddRealmRoleToUser(ctx, adminAccessToken, realm, userID, []gocloak.Role{*role})
+	} else {
+		err = kcClient.DeleteRealmRoleFromUser(ctx, adminAccessToken, realm, userID, []gocloak.Role{*role})
+	}
+	metrics.ObserveKeycloakRequest(opUpdateUserRoles, start, err)
+	if err != nil {
+		return err
+	}
+	// 角色变化立即生效: 清除该用户已缓存的 token 校验结果 (其中包含角色)
+	tokens.evictUser(userID)
+	return nil
 }
 
 // UpdateKeycloakUserStatus 启用/禁用 Keycloak 用户
@@ -198,16 +711,31 @@ func UpdateKeycloakUserStatus(ctx context.Context, userID string, enable bool) e
 		return err
 	}
 
+	// 读取和更新作为一次操作占用同一个名额
+	release, err := acquireAdminSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	start := time.Now()
 	user, err := kcClient.GetUserByID(ctx, adminAccessToken, config.AppConfig.Keycloak.Realm, userID)
+	metrics.ObserveKeycloakRequest(opGetUser, start, err)
 	if err != nil {
 		return err
 	}
 
 	user.Enabled = gocloak.BoolP(enable)
 
+	start = time.Now()
 	err = kcClient.UpdateUser(ctx, adminAccessToken, config.AppConfig.Keycloak.Realm, *user)
+	metrics.ObserveKeycloakRequest(opUpdateUser, start, err)
 	if err != nil {
 		return err
 	}
+	if !enable {
+		// 禁用立即生效: 清除该用户已缓存的 token 校验结果
+		tokens.evictUser(userID)
+	}
 	return nil
 }
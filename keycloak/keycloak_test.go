@@ -0,0 +1,130 @@
+package keycloak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExtractRoles(t *testing.T) {
+	var claims map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+		"sub": "user-1",
+		"scope": "openid admin, operator",
+		"groups": ["admin", "viewer", 42],
+		"realm_access": {"roles": ["offline_access", "operator"]},
+		"resource_access": {"agent-manager": {"roles": [{"name": "admin"}, {"id": "x"}, {"name": "auditor"}]}},
+		"empty": [],
+		"count": 3
+	}`), &claims); err != nil {
+		t.Fatalf("unmarshal claims: %v", err)
+	}
+	claims["typed"] = []string{"admin", "operator"}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"scope", []string{"openid", "admin", "operator"}},
+		{"groups", []string{"admin", "viewer"}},
+		{"typed", []string{"admin", "operator"}},
+		{"realm_access.roles", []string{"offline_access", "operator"}},
+		{"resource_access.agent-manager.roles", []string{"admin", "auditor"}},
+		{"empty", nil},
+		{"missing", nil},
+		{"realm_access.missing", nil},
+		{"resource_access.other-client.roles", nil},
+		{"sub.roles", nil},    // 中间节点不是对象
+		{"count", nil},        // 末端类型不支持
+		{"realm_access", nil}, // 末端是对象
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := extractRoles(claims, tt.path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractRoles(%q) = %#v, want %#v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcquireAdminSlotCapsConcurrency(t *testing.T) {
+	const limit, callers = 3, 20
+	prev := adminSlots
+	t.Cleanup(func() { adminSlots = prev })
+	adminSlots = make(chan struct{}, limit)
+
+	var active, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := acquireAdminSlot(context.Background())
+			if err != nil {
+				t.Errorf("acquireAdminSlot: %v", err)
+				return
+			}
+			defer release()
+			n := atomic.AddInt32(&active, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond) // 模拟一次 Admin API 调用
+			atomic.AddInt32(&active, -1)
+		}()
+	}
+	wg.Wait()
+
+	if peak > limit {
+		t.Errorf("peak concurrency = %d, want at most %d", peak, limit)
+	}
+	if peak < limit {
+		t.Errorf("peak concurrency = %d, want the limit %d to be reached", peak, limit)
+	}
+	if len(adminSlots) != 0 {
+		t.Errorf("%d slots still held after all callers released", len(adminSlots))
+	}
+}
+
+func TestAcquireAdminSlotRespectsContext(t *testing.T) {
+	prev := adminSlots
+	t.Cleanup(func() { adminSlots = prev })
+	adminSlots = make(chan struct{}, 1)
+
+	release, err := acquireAdminSlot(context.Background())
+	if err != nil {
+		t.Fatalf("acquireAdminSlot: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := acquireAdminSlot(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquireAdminSlot with all slots taken = %v, want context.DeadlineExceeded", err)
+	}
+
+	release()
+	release, err = acquireAdminSlot(context.Background())
+	if err != nil {
+		t.Fatalf("acquireAdminSlot after release: %v", err)
+	}
+	release()
+}
+
+func TestAcquireAdminSlotUninitialised(t *testing.T) {
+	prev := adminSlots
+	t.Cleanup(func() { adminSlots = prev })
+	adminSlots = nil
+
+	release, err := acquireAdminSlot(context.Background())
+	if err != nil {
+		t.Fatalf("acquireAdminSlot before InitKeycloak: %v", err)
+	}
+	release()
+}
@@ -0,0 +1,91 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// wellKnownProbeTimeout 启动时探测 Realm well-known 端点的超时时间
+const wellKnownProbeTimeout = 5 * time.Second
+
+// normalizeAuthServerURL 规范化 KEYCLOAK_AUTH_SERVER_URL: 去除首尾空白和末尾的 "/"，
+// 并要求是不带查询参数的 http(s) 绝对地址
+func normalizeAuthServerURL(raw string) (string, error) {
+	trimmed := strings.TrimRight(strings.TrimSpace(raw), "/")
+	if trimmed == "" {
+		return "", fmt.Errorf("KEYCLOAK_AUTH_SERVER_URL is not set")
+	}
+	u, err := url.Parse(trimmed)
+	if err != nil {
+		return "", fmt.Errorf("KEYCLOAK_AUTH_SERVER_URL %q is not a valid URL: %w", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("KEYCLOAK_AUTH_SERVER_URL %q must start with http:// or https://", raw)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("KEYCLOAK_AUTH_SERVER_URL %q has no host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("KEYCLOAK_AUTH_SERVER_URL %q must not contain a query string or fragment", raw)
+	}
+	return trimmed, nil
+}
+
+// alternateAuthServerURL 返回切换 "/auth" 后缀后的地址
+// Keycloak 17 起默认不再带 /auth 前缀，旧版本 (以及开启了 http-relative-path=/auth 的新版本) 则需要
+func alternateAuthServerURL(base string) string {
+	if strings.HasSuffix(base, "/auth") {
+		return strings.TrimSuffix(base, "/auth")
+	}
+	return base + "/auth"
+}
+
+// probeRealm 请求 Realm 的 OpenID well-known 端点，返回 HTTP 状态码
+func probeRealm(ctx context.Context, base, realm string) (int, error) {
+	endpoint := base + "/realms/" + url.PathEscape(realm) + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// diagnoseAuthServerURL 启动时检查 Keycloak 地址与 Realm 是否匹配
+// 只输出诊断日志不终止启动: Keycloak 可能晚于本服务就绪，管理员 token 刷新协程会持续重试
+func diagnoseAuthServerURL(base, realm string) {
+	ctx, cancel := context.WithTimeout(context.Background(), wellKnownProbeTimeout)
+	defer cancel()
+
+	status, err := probeRealm(ctx, base, realm)
+	if err != nil {
+		log.Printf("Could not reach Keycloak at %s to verify realm %q: %v", base, realm, err)
+		return
+	}
+	if status == http.StatusOK {
+		return
+	}
+	if status != http.StatusNotFound {
+		log.Printf("Keycloak realm %q well-known endpoint at %s returned HTTP %d", realm, base, status)
+		return
+	}
+
+	// 404: 最常见的原因是 /auth 前缀与 Keycloak 版本不匹配
+	alternate := alternateAuthServerURL(base)
+	if altStatus, err := probeRealm(ctx, alternate, realm); err == nil && altStatus == http.StatusOK {
+		log.Printf("Keycloak realm %q was not found under %s but is served under %s; "+
+			"set KEYCLOAK_AUTH_SERVER_URL=%s (Keycloak 17+ dropped the /auth path prefix)", realm, base, alternate, alternate)
+		return
+	}
+	log.Printf("Keycloak realm %q was not found under %s (also tried %s); check KEYCLOAK_AUTH_SERVER_URL and KEYCLOAK_REALM",
+		realm, base, alternate)
+}
@@ -0,0 +1,140 @@
+package keycloak
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// tokenCacheEntry 一次成功校验的结果
+type tokenCacheEntry struct {
+	sub       string
+	roles     []string
+	expiresAt time.Time // min(缓存时间 + TTL, token exp)
+}
+
+// tokenCache 以 token 哈希为键缓存校验结果，并按用户建立索引以便禁用用户时立即失效
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]tokenCacheEntry
+	byUser  map[string]map[string]struct{}
+	// evictedAt 记录用户最近一次被驱逐的时间，在此之前开始的校验结果不再写入缓存，
+	// 避免与禁用操作并发的请求把旧结果重新写回
+	evictedAt map[string]time.Time
+
+	ttl        time.Duration // 同一 token 在该时间内不重复请求 Keycloak (TOKEN_CACHE_TTL)
+	maxEntries int           // 缓存条目上限，防止大量不同 token 导致内存无限增长 (TOKEN_CACHE_MAX_ENTRIES)
+}
+
+var tokens = &tokenCache{
+	ttl:        30 * time.Second,
+	maxEntries: 10000,
+	entries:    make(map[string]tokenCacheEntry),
+	byUser:     make(map[string]map[string]struct{}),
+	evictedAt:  make(map[string]time.Time),
+}
+
+// configure 设置缓存 TTL 和条目上限，任一不大于 0 时关闭缓存 (在处理请求前调用)
+func (c *tokenCache) configure(ttl time.Duration, maxEntries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	c.maxEntries = maxEntries
+}
+
+// hashToken 缓存键使用 token 的 SHA-256，内存中不保存 token 明文
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// get 返回未过期的缓存结果
+func (c *tokenCache) get(key string) (tokenCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return tokenCacheEntry{}, false
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		c.remove(key, entry.sub)
+		return tokenCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// put 缓存校验结果；startedAt 为本次校验开始的时间，tokenExp 为 token 的 exp (零值表示未知)
+func (c *tokenCache) put(key, sub string, roles []string, startedAt, tokenExp time.Time) {
+	if c.ttl <= 0 || c.maxEntries <= 0 {
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(c.ttl)
+	if !tokenExp.IsZero() && tokenExp.Before(expiresAt) {
+		expiresAt = tokenExp
+	}
+	if !now.Before(expiresAt) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if evicted, ok := c.evictedAt[sub]; ok && !startedAt.After(evicted) {
+		return
+	}
+	if len(c.entries) >= c.maxEntries {
+		c.makeRoom(now)
+	}
+	c.entries[key] = tokenCacheEntry{sub: sub, roles: roles, expiresAt: expiresAt}
+	if c.byUser[sub] == nil {
+		c.byUser[sub] = make(map[string]struct{})
+	}
+	c.byUser[sub][key] = struct{}{}
+}
+
+// evictUser 删除用户的所有缓存 token，用于禁用用户后立即生效
+func (c *tokenCache) evictUser(sub string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.byUser[sub] {
+		delete(c.entries, key)
+	}
+	delete(c.byUser, sub)
+
+	now := time.Now()
+	c.evictedAt[sub] = now
+	// 超过 TTL 的驱逐记录已无意义 (此前开始的校验不可能仍在进行)
+	for user, at := range c.evictedAt {
+		if now.Sub(at) > c.ttl {
+			delete(c.evictedAt, user)
+		}
+	}
+}
+
+// makeRoom 缓存已满时先清理过期条目，仍然不够则随机淘汰一条 (调用方持有锁)
+func (c *tokenCache) makeRoom(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			c.remove(key, entry.sub)
+		}
+	}
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+	for key, entry := range c.entries {
+		c.remove(key, entry.sub)
+		return
+	}
+}
+
+// remove 删除单条缓存及其用户索引 (调用方持有锁)
+func (c *tokenCache) remove(key, sub string) {
+	delete(c.entries, key)
+	if keys := c.byUser[sub]; keys != nil {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.byUser, sub)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package keycloak
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestTokenCache(ttl time.Duration, maxEntries int) *tokenCache {
+	return &tokenCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]tokenCacheEntry),
+		byUser:     make(map[string]map[string]struct{}),
+		evictedAt:  make(map[string]time.Time),
+	}
+}
+
+func TestTokenCacheEvictUser(t *testing.T) {
+	c := newTestTokenCache(time.Minute, 100)
+	started := time.Now()
+	c.put("a1", "alice", []string{"admin"}, started, time.Time{})
+	c.put("a2", "alice", []string{"admin"}, started, time.Time{})
+	c.put("b1", "bob", []string{"viewer"}, started, time.Time{})
+
+	c.evictUser("alice")
+
+	for _, key := range []string{"a1", "a2"} {
+		if _, ok := c.get(key); ok {
+			t.Errorf("token %s of evicted user still cached", key)
+		}
+	}
+	if entry, ok := c.get("b1"); !ok || entry.sub != "bob" {
+		t.Errorf("token of another user was evicted: %+v, %v", entry, ok)
+	}
+	if _, ok := c.byUser["alice"]; ok {
+		t.Error("user index of evicted user not removed")
+	}
+}
+
+func TestTokenCacheIgnoresValidationStartedBeforeEviction(t *testing.T) {
+	c := newTestTokenCache(time.Minute, 100)
+
+	// 校验在禁用之前开始、在驱逐之后才完成，结果不能写回缓存
+	startedBefore := time.Now()
+	time.Sleep(time.Millisecond)
+	c.evictUser("alice")
+	c.put("stale", "alice", []string{"admin"}, startedBefore, time.Time{})
+	if _, ok := c.get("stale"); ok {
+		t.Error("validation that started before the eviction was cached")
+	}
+
+	// 驱逐之后开始的校验 (例如用户重新启用后) 正常缓存
+	time.Sleep(time.Millisecond)
+	c.put("fresh", "alice", []string{"admin"}, time.Now(), time.Time{})
+	if _, ok := c.get("fresh"); !ok {
+		t.Error("validation that started after the eviction was not cached")
+	}
+
+	// 驱逐只影响被驱逐的用户
+	c.put("other", "bob", []string{"viewer"}, startedBefore, time.Time{})
+	if _, ok := c.get("other"); !ok {
+		t.Error("eviction of one user blocked caching for another user")
+	}
+}
+
+func TestTokenCacheEvictionRecordsExpire(t *testing.T) {
+	c := newTestTokenCache(10*time.Millisecond, 100)
+	c.evictUser("alice")
+	time.Sleep(20 * time.Millisecond)
+	c.evictUser("bob") // 顺带清理超过 TTL 的驱逐记录
+
+	if _, ok := c.evictedAt["alice"]; ok {
+		t.Error("eviction record older than the TTL was kept")
+	}
+	if _, ok := c.evictedAt["bob"]; !ok {
+		t.Error("latest eviction record was dropped")
+	}
+}
+
+func TestTokenCacheExpiry(t *testing.T) {
+	c := newTestTokenCache(time.Minute, 100)
+	now := time.Now()
+
+	c.put("short", "alice", nil, now, now.Add(10*time.Millisecond))
+	if entry, ok := c.get("short"); !ok || !entry.expiresAt.Equal(now.Add(10*time.Millisecond)) {
+		t.Fatalf("entry = %+v, %v; want cached until the token exp", entry, ok)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.get("short"); ok {
+		t.Error("entry still returned after the token expired")
+	}
+
+	c.put("expired", "alice", nil, now, now.Add(-time.Second))
+	if _, ok := c.get("expired"); ok {
+		t.Error("already expired token was cached")
+	}
+}
+
+func TestTokenCacheDisabledAndFull(t *testing.T) {
+	disabled := newTestTokenCache(0, 100)
+	disabled.put("k", "alice", nil, time.Now(), time.Time{})
+	if _, ok := disabled.get("k"); ok {
+		t.Error("token cached with TTL 0")
+	}
+
+	full := newTestTokenCache(time.Minute, 2)
+	for _, key := range []string{"k1", "k2", "k3"} {
+		full.put(key, "alice", nil, time.Now(), time.Time{})
+	}
+	if len(full.entries) != 2 {
+		t.Errorf("%d entries cached, want the limit 2", len(full.entries))
+	}
+	if _, ok := full.get("k3"); !ok {
+		t.Error("newest entry was not cached when the cache was full")
+	}
+	if n := len(full.byUser["alice"]); n != 2 {
+		t.Errorf("user index has %d keys, want 2", n)
+	}
+}
+
+func TestValidateAccessTokenUsesCache(t *testing.T) {
+	prevTokens, prevClient := tokens, kcClient
+	t.Cleanup(func() { tokens, kcClient = prevTokens, prevClient })
+	tokens = newTestTokenCache(time.Minute, 100)
+	kcClient = nil // 命中缓存时不应访问 Keycloak
+
+	tokens.put(hashToken("token-a"), "user-1", []string{"admin"}, time.Now(), time.Time{})
+	sub, roles, err := ValidateAccessToken(context.Background(), "token-a")
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if sub != "user-1" || !reflect.DeepEqual(roles, []string{"admin"}) {
+		t.Errorf("ValidateAccessToken = %q, %q; want cached user-1, [admin]", sub, roles)
+	}
+
+	// 禁用用户后缓存失效，下一次校验必须重新请求 Keycloak
+	tokens.evictUser("user-1")
+	if _, ok := tokens.get(hashToken("token-a")); ok {
+		t.Error("cached result survived evictUser")
+	}
+}
@@ -0,0 +1,41 @@
+package logger
+
+import (
+	"log/slog"
+	"os"
+
+	"go-agent-manager/config"
+
+	"github.com/labstack/echo/v4"
+)
+
+// level 全局日志级别，由 SetLevel 根据 LOG_LEVEL 设置
+var level = new(slog.LevelVar)
+
+// Log 全局结构化日志记录器 (JSON 格式输出到标准输出)
+var Log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+
+// Access 访问日志记录器，输出格式与 Log 相同但不受 LOG_LEVEL 限制 (由 ACCESS_LOG 决定是否使用)
+var Access = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// levelSilent 高于所有内置级别，用于 LOG_LEVEL=silent
+const levelSilent = slog.LevelError + 4
+
+// SetLevel 按 LOG_LEVEL (silent / error / warn / info) 设置全局日志级别，未知值按 warn 处理
+func SetLevel(name string) {
+	switch name {
+	case config.LogLevelSilent:
+		level.Set(levelSilent)
+	case config.LogLevelError:
+		level.Set(slog.LevelError)
+	case config.LogLevelInfo:
+		level.Set(slog.LevelInfo)
+	default:
+		level.Set(slog.LevelWarn)
+	}
+}
+
+// For 返回附带当前请求 ID 的日志记录器，便于按请求追踪日志
+func For(c echo.Context) *slog.Logger {
+	return Log.With("request_id", c.Response().Header().Get(echo.HeaderXRequestID))
+}
@@ -1,53 +1,141 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	// "path/filepath" // 移除了未使用的导入
 
 	"go-agent-manager/config"
 	"go-agent-manager/db"
+	"go-agent-manager/events"
+	"go-agent-manager/frontend"
 	"go-agent-manager/handlers"
+	"go-agent-manager/jobs"
 	"go-agent-manager/keycloak"
+	"go-agent-manager/logger"
+	"go-agent-manager/metrics"
 	"go-agent-manager/middleware"
+	"go-agent-manager/seed"
+	"go-agent-manager/signing"
 
 	"github.com/labstack/echo/v4"
 	e_middleware "github.com/labstack/echo/v4/middleware"
 )
 
+// shutdownTimeout 收到退出信号后等待进行中请求完成的最长时间
+const shutdownTimeout = 20 * time.Second
+
 func main() {
+	// 命令行参数: -seed 写入示例数据后退出，不启动服务器
+	seedData := flag.Bool("seed", false, "insert sample devices and rules (skipping existing ones), then exit")
+	seedBindingUser := flag.String("seed-binding-user", "", "with -seed, also bind the first sample device to this Keycloak user ID")
+	flag.Parse()
+
+	// 收到 SIGINT / SIGTERM 时取消 ctx: 后台任务随之停止，服务器开始优雅退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// 1. 加载配置
 	config.LoadConfig()
+	logger.SetLevel(config.AppConfig.LogLevel)
+
+	// 加载规则签名私钥 (可选)
+	if err := signing.Init(); err != nil {
+		log.Fatalf("Invalid rule signing key: %v", err)
+	}
 
 	// 2. 初始化数据库
 	db.InitDB()
+	if config.AppConfig.MetricsEnabled {
+		if err := metrics.InstrumentDB(db.DB); err != nil {
+			log.Fatalf("Failed to instrument database metrics: %v", err)
+		}
+	}
+
+	if *seedData {
+		if config.AppConfig.ReadOnlyMode {
+			log.Fatalf("Cannot seed the database while READ_ONLY_MODE is enabled")
+		}
+		res, err := seed.Run(ctx, db.DB, seed.Options{BindingUserID: *seedBindingUser})
+		if err != nil {
+			log.Fatalf("Failed to seed database: %v", err)
+		}
+		log.Printf("Seed data applied: %d created, %d already present.", res.Created, res.Skipped)
+		return
+	}
 
 	// 3. 初始化 Keycloak 客户端
 	keycloak.InitKeycloak()
 
+	// 启动后台清理任务 (绑定过期、审计清理)，只读模式下这些写库任务不启动
+	if config.AppConfig.ReadOnlyMode {
+		logger.Log.Warn("READ_ONLY_MODE is enabled: write requests will be rejected with 503 and background write jobs are disabled")
+	} else {
+		jobs.Start(ctx)
+	}
+	metrics.StartDeviceCollector(ctx)
+	metrics.StartInventoryCollector(ctx)
+	events.StartOfflineDetector(ctx)
+
 	// 4. 创建 Echo 实例
 	e := echo.New()
 
+	// 统一错误响应格式
+	e.HTTPErrorHandler = middleware.HTTPErrorHandler
+	// 客户端 IP 只从可信代理 (TRUSTED_PROXIES) 的 X-Forwarded-For 中读取，防止伪造绕过限流或污染审计记录
+	e.IPExtractor = middleware.IPExtractor()
+
 	// 5. 注册全局中间件
-	e.Use(e_middleware.Logger())       // 请求日志
-	e.Use(e_middleware.Recover())      // 崩溃恢复
-	e.Use(middleware.CORSMiddleware()) // CORS 允许跨域
+	e.Use(e_middleware.RequestID()) // 请求 ID (X-Request-Id)
+	if config.AppConfig.MetricsEnabled {
+		e.Use(metrics.HTTPMiddleware()) // HTTP 请求次数和耗时指标，需在请求日志 (错误处理) 之外
+	}
+	e.Use(middleware.RequestLogMiddleware()) // 结构化请求日志 (JSON，包含请求 ID 和用户 ID)
+	e.Use(middleware.RecoverMiddleware())    // 崩溃恢复 (结构化日志 + 审计)
+	e.Use(middleware.CORSMiddleware())       // CORS 允许跨域
+	if config.AppConfig.ReadOnlyMode {
+		// 只读模式: 拒绝写请求，仅放行不修改数据的 POST 接口
+		e.Use(middleware.ReadOnlyMiddleware(
+			"POST /api/admin/devices/batch-get",
+			"POST /api/admin/devices/:id/simulate",
+			"POST /api/admin/rules/test",
+		))
+	}
 
 	// 6. 静态文件服务 (前端构建后的 dist 目录)
 	// 在生产环境中，Go 后端会托管前端静态文件
+	// FRONTEND_EMBED=true 时使用编译进二进制的文件 (需要 -tags embedfrontend 构建)，无需挂载目录
 	frontendPath := config.AppConfig.FrontendStaticPath
-	
-	// 简单检查目录是否存在
-	if info, err := os.Stat(frontendPath); err == nil && info.IsDir() {
+
+	if config.AppConfig.FrontendEmbed {
+		if frontend.Assets == nil {
+			log.Fatalf("FRONTEND_EMBED is enabled but this binary was built without embedded frontend assets (build with -tags embedfrontend)")
+		}
 		// 路由任何不匹配 API 的请求都由 ServeFrontend 处理
-		// 注意：ServeFrontend 内部可能会用到 filepath，但那是 handlers 包的事
-		e.GET("/*", handlers.ServeFrontend())
+		e.GET("/*", handlers.ServeFrontend(frontend.Assets))
+		log.Printf("Frontend static file serving enabled from embedded assets")
+	} else if info, err := os.Stat(frontendPath); err == nil && info.IsDir() { // 简单检查目录是否存在
+		e.GET("/*", handlers.ServeFrontend(os.DirFS(frontendPath)))
 		log.Printf("Frontend static file serving enabled from: %s", frontendPath)
 	} else {
 		log.Printf("Frontend static path %s not found or inaccessible. Static file serving disabled.", frontendPath)
 	}
 
+	// 存活/就绪检查 (供 Kubernetes 探针使用，不经过认证)
+	e.GET("/healthz", handlers.Healthz)
+	e.GET("/readyz", handlers.Readyz)
+
+	// Prometheus 指标 (不经过认证，建议在网络层限制访问)
+	if config.AppConfig.MetricsEnabled {
+		e.GET("/metrics", metrics.Handler())
+	}
+
 	// 7. API 路由组
 	apiGroup := e.Group("/api")
 
@@ -56,34 +144,129 @@ func main() {
 
 	// 定义需要管理员角色的路由
 	adminGroup := apiGroup.Group("/admin")
-	// 注意：确保您的 Keycloak 用户拥有 'admin' 角色，否则这里会返回 403
-	// 如果还在开发调试阶段，可以暂时注释掉 RBACMiddleware
-	adminGroup.Use(middleware.RBACMiddleware("admin")) 
+	// 注意：确保您的 Keycloak 用户拥有 ADMIN_ROLE (默认 'admin') 角色，否则这里会返回 403
+	// ROUTE_ROLES 可以为单个路由指定更细粒度的角色，未配置的路由回退到 ADMIN_ROLE
+	routeRoles, err := middleware.ParseRouteRoles(config.AppConfig.RouteRoles)
+	if err != nil {
+		log.Fatalf("Invalid ROUTE_ROLES configuration: %v", err)
+	}
+	adminGroup.Use(middleware.RouteRBACMiddleware(routeRoles, config.AppConfig.AdminRole)) 
 
 	// --- 设备管理 (需要管理员角色) ---
 	adminGroup.GET("/devices", handlers.GetDevices)
+	adminGroup.GET("/devices/by-hardware-id/:hwid", handlers.GetDeviceByHardwareID)
+	adminGroup.GET("/devices/duplicate-hardware", handlers.GetDuplicateHardwareDevices)
+	adminGroup.GET("/devices/archived", handlers.GetArchivedDevices)
+	adminGroup.GET("/devices/events", handlers.StreamDeviceEvents)
+	adminGroup.GET("/devices/export", handlers.ExportDevices)
+	adminGroup.GET("/devices/deleted", handlers.GetDeletedDevices)
+	adminGroup.GET("/devices/:id", handlers.GetDevice)
 	adminGroup.POST("/devices", handlers.CreateDevice)
+	adminGroup.POST("/devices/batch-get", handlers.BatchGetDevices)
 	adminGroup.PUT("/devices/:id", handlers.UpdateDevice)
 	adminGroup.DELETE("/devices/:id", handlers.DeleteDevice)
+	adminGroup.POST("/devices/:id/decommission", handlers.DecommissionDevice)
+	adminGroup.POST("/devices/:id/block", handlers.BlockDevice)
+	adminGroup.POST("/devices/:id/unblock", handlers.UnblockDevice)
+	adminGroup.POST("/devices/:id/restore", handlers.RestoreDevice)
+	adminGroup.POST("/devices/:id/simulate", handlers.SimulateDeviceTraffic)
+	adminGroup.GET("/devices/:id/timeline", handlers.GetDeviceTimeline)
 
 	// --- 用户管理 (需要管理员角色) ---
 	adminGroup.GET("/users", handlers.GetUsers)
+	adminGroup.GET("/users/stream", handlers.StreamUsers)
 	adminGroup.PUT("/users/:id/status", handlers.UpdateUserStatus)
+	adminGroup.POST("/users/status/bulk", handlers.BulkUpdateUserStatus)
+	adminGroup.POST("/users/bulk-status", handlers.BulkUpdateUserStatus) // 与 /users/status/bulk 相同
+	adminGroup.GET("/users/:id/federated-identities", handlers.GetUserFederatedIdentities)
+	adminGroup.POST("/users/:id/roles", handlers.AssignUserRole)
+	adminGroup.DELETE("/users/:id/roles", handlers.RemoveUserRole)
 
 	// --- 绑定管理 (需要管理员角色) ---
 	adminGroup.GET("/bindings", handlers.GetBindings)
 	adminGroup.POST("/bindings", handlers.CreateBinding)
+	adminGroup.GET("/bindings/:id", handlers.GetBinding)
 	adminGroup.DELETE("/bindings/:id", handlers.DeleteBinding)
+	adminGroup.POST("/bindings/:id/extend", handlers.ExtendBinding)
+	adminGroup.PUT("/bindings/:id/status", handlers.UpdateBindingStatus)
+	adminGroup.POST("/bindings/:id/approve", handlers.ApproveBinding)
+	adminGroup.POST("/bindings/:id/reject", handlers.RejectBinding)
 
 	// --- 规则管理 (需要管理员角色) ---
 	adminGroup.GET("/rules", handlers.GetRules)
+	adminGroup.GET("/rules/search", handlers.SearchRules)
+	adminGroup.GET("/rules/conflicts", handlers.GetRuleConflicts)
+	adminGroup.POST("/rules/test", handlers.TestRules)
 	adminGroup.POST("/rules", handlers.CreateRule)
+	adminGroup.GET("/rules/export", handlers.ExportRules)
+	adminGroup.POST("/rules/import", handlers.ImportRules)
+	adminGroup.POST("/rules/import-from-url", handlers.ImportRulesFromURL)
+	adminGroup.POST("/rules/reorder", handlers.ReorderRules)
+	adminGroup.GET("/rules/:id", handlers.GetRule)
 	adminGroup.PUT("/rules/:id", handlers.UpdateRule)
 	adminGroup.DELETE("/rules/:id", handlers.DeleteRule)
+	adminGroup.PATCH("/rules/:id/toggle", handlers.ToggleRule)
+	adminGroup.GET("/rules/:id/application-status", handlers.GetRuleApplicationStatus)
+
+	// --- 仪表盘统计 (需要管理员角色) ---
+	adminGroup.GET("/stats", handlers.GetStats)
+
+	// --- 后台任务状态 (需要管理员角色) ---
+	adminGroup.GET("/jobs/status", handlers.GetJobsStatus)
+
+	// --- 生效配置 (已脱敏，需要管理员角色) ---
+	adminGroup.GET("/config", handlers.GetEffectiveConfig)
+
+	// --- Keycloak 运维 (需要管理员角色) ---
+	adminGroup.POST("/keycloak/refresh-token", handlers.RefreshKeycloakAdminToken)
+
+	// --- Agent API Key 管理 (需要管理员角色) ---
+	adminGroup.GET("/agent-keys", handlers.GetAgentKeys)
+	adminGroup.POST("/agent-keys", handlers.CreateAgentKey)
+	adminGroup.DELETE("/agent-keys/:id", handlers.RevokeAgentKey)
+
+	// --- Agent 接口 (使用 X-Agent-Key 认证，不经过 Keycloak) ---
+	// 限流在认证之前，无效 Key 的请求同样受限 (AGENT_RATE_LIMIT)
+	agentRateLimit := middleware.AgentRateLimitMiddleware()
+	agentGroup := e.Group("/api/agent", agentRateLimit, middleware.APIKeyMiddleware)
+	// REQUIRE_BINDING_FOR_RULES 开启时，只有存在有效绑定的设备才能获取规则
+	agentGroup.GET("/rules", handlers.GetAgentRules, middleware.RequireBindingMiddleware)
+	agentGroup.GET("/rules/diff", handlers.GetAgentRulesDiff, middleware.RequireBindingMiddleware)
+	agentGroup.GET("/rules/subscribe", handlers.SubscribeAgentRules, middleware.RequireBindingMiddleware)
+	agentGroup.GET("/rules/public-key", handlers.GetRuleSigningPublicKey)
+	agentGroup.GET("/config-bundle", handlers.GetAgentConfigBundle)
+	agentGroup.POST("/rules/report", handlers.ReportRuleApplication)
+	// 设备心跳同样使用 Agent Key 认证；路径比 /api 组的通配路由更具体，不会经过 Keycloak 中间件
+	e.POST("/api/devices/heartbeat", handlers.DeviceHeartbeat, agentRateLimit, middleware.APIKeyMiddleware)
 
 	// 8. 启动服务器
 	log.Printf("Server starting on port %s", config.AppConfig.ServerPort)
-	if err := e.Start(":" + config.AppConfig.ServerPort); err != nil && err != http.ErrServerClosed {
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := e.Start(":" + config.AppConfig.ServerPort); err != nil && err != http.ErrServerClosed {
+			serverErr <- err
+		}
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatalf("Server stopped with error: %v", err)
+	case <-ctx.Done():
+	}
+
+	// 9. 优雅退出: 停止接收新连接并等待进行中的请求完成，超时后强制关闭剩余连接 (例如规则订阅的长连接)
+	stop()
+	log.Println("Shutdown signal received, draining in-flight requests...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Graceful shutdown timed out, closing remaining connections: %v", err)
+		_ = e.Close()
+	}
+
+	keycloak.StopAdminTokenRefresher()
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database connection pool: %v", err)
 	}
+	log.Println("Server stopped.")
 }
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+var dbQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "db_query_errors_total",
+	Help: "Number of failed database statements, by operation (create/query/update/delete/row/raw).",
+}, []string{"operation"})
+
+// InstrumentDB 在 GORM 的各类回调之后统计数据库错误
+// 未找到记录 (gorm.ErrRecordNotFound) 是正常的业务结果，不计入错误
+func InstrumentDB(db *gorm.DB) error {
+	callbacks := db.Callback()
+	registrations := []struct {
+		operation string
+		register  func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().After("gorm:raw").Register},
+	}
+	for _, r := range registrations {
+		operation := r.operation
+		err := r.register("metrics:"+operation+"_errors", func(tx *gorm.DB) {
+			if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				dbQueryErrors.WithLabelValues(operation).Inc()
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
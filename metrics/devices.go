@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/jobs"
+	"go-agent-manager/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DeviceMetricsJob 单设备指标刷新任务在 jobs 健康检查中的名称
+const DeviceMetricsJob = "device-metrics-refresh"
+
+var (
+	// 每个设备一条时间序列，标签基数与设备数量成正比，因此默认关闭并设有数量上限
+	deviceLastSeenAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "device_last_seen_age_seconds",
+		Help: "Seconds since each device last reported. Only exported when PER_DEVICE_METRICS is enabled; capped at PER_DEVICE_METRICS_MAX series.",
+	}, []string{"device_id", "hostname"})
+
+	deviceLastSeenOmitted = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "device_last_seen_age_omitted_devices",
+		Help: "Number of devices left out of device_last_seen_age_seconds because of the PER_DEVICE_METRICS_MAX cap.",
+	})
+)
+
+// StartDeviceCollector 在启用 PER_DEVICE_METRICS 时定期从数据库刷新单设备最后上报时长
+func StartDeviceCollector(ctx context.Context) {
+	if !config.AppConfig.MetricsEnabled || !config.AppConfig.PerDeviceMetrics {
+		return
+	}
+	jobs.Run(ctx, DeviceMetricsJob, config.AppConfig.PerDeviceMetricsInterval, refreshDeviceMetrics)
+}
+
+// refreshDeviceMetrics 按最近上报时间取前 PER_DEVICE_METRICS_MAX 台设备重建指标
+// 超出上限的设备不导出，只计入 device_last_seen_age_omitted_devices，避免标签基数失控
+func refreshDeviceMetrics(ctx context.Context) error {
+	limit := config.AppConfig.PerDeviceMetricsMax
+
+	var total int64
+	if err := db.DB.WithContext(ctx).Model(&models.Device{}).Count(&total).Error; err != nil {
+		return err
+	}
+
+	var devices []models.Device
+	err := db.DB.WithContext(ctx).
+		Select("id", "hostname", "last_seen_at").
+		Order("last_seen_at DESC").
+		Limit(limit).
+		Find(&devices).Error
+	if err != nil {
+		return err
+	}
+
+	// 重建整个向量，已删除设备对应的序列随之消失
+	now := time.Now()
+	deviceLastSeenAge.Reset()
+	for _, d := range devices {
+		deviceLastSeenAge.WithLabelValues(d.ID, d.Hostname).Set(now.Sub(d.LastSeenAt).Seconds())
+	}
+	deviceLastSeenOmitted.Set(float64(total - int64(len(devices))))
+	return nil
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests handled, by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of HTTP requests, by method and route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// HTTPMiddleware 记录每个请求的次数和耗时
+// route 标签使用 Echo 的路由模式 (例如 /api/admin/devices/:id)，而不是实际路径，避免标签基数失控
+// 应挂在请求日志中间件之前 (外层)，这样错误已由全局错误处理器转换为实际的响应状态码
+func HTTPMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			status := c.Response().Status
+			if err != nil && !c.Response().Committed {
+				status = http.StatusInternalServerError
+				var httpErr *echo.HTTPError
+				if errors.As(err, &httpErr) {
+					status = httpErr.Code
+				}
+			}
+
+			method := c.Request().Method
+			httpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+			httpRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/jobs"
+	"go-agent-manager/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// InventoryMetricsJob 资源总数指标刷新任务在 jobs 健康检查中的名称
+const InventoryMetricsJob = "inventory-metrics-refresh"
+
+// inventoryMetricsInterval 资源总数的刷新间隔，抓取时不直接查库
+const inventoryMetricsInterval = 30 * time.Second
+
+var (
+	devicesTotal = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "devices_total",
+		Help: "Number of registered (not deleted) devices.",
+	})
+
+	bindingsTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bindings_total",
+		Help: "Number of user-device bindings, by status.",
+	}, []string{"status"})
+
+	rulesTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rules_total",
+		Help: "Number of rules, by enabled state.",
+	}, []string{"enabled"})
+)
+
+// StartInventoryCollector 定期从数据库刷新设备、绑定、规则的总数
+func StartInventoryCollector(ctx context.Context) {
+	if !config.AppConfig.MetricsEnabled {
+		return
+	}
+	jobs.Run(ctx, InventoryMetricsJob, inventoryMetricsInterval, refreshInventoryMetrics)
+}
+
+// refreshInventoryMetrics 查询各资源总数并更新指标
+func refreshInventoryMetrics(ctx context.Context) error {
+	var devices int64
+	if err := db.DB.WithContext(ctx).Model(&models.Device{}).Count(&devices).Error; err != nil {
+		return err
+	}
+
+	var bindings []struct {
+		Status string
+		Count  int64
+	}
+	err := db.DB.WithContext(ctx).Model(&models.UserDeviceBinding{}).
+		Select("status, COUNT(*) AS count").Group("status").Scan(&bindings).Error
+	if err != nil {
+		return err
+	}
+
+	var rules []struct {
+		Enabled bool
+		Count   int64
+	}
+	err = db.DB.WithContext(ctx).Model(&models.Rule{}).
+		Select("enabled, COUNT(*) AS count").Group("enabled").Scan(&rules).Error
+	if err != nil {
+		return err
+	}
+
+	devicesTotal.Set(float64(devices))
+	// 重建向量，已不存在的状态随之消失
+	bindingsTotal.Reset()
+	for _, b := range bindings {
+		bindingsTotal.WithLabelValues(b.Status).Set(float64(b.Count))
+	}
+	rulesTotal.Reset()
+	rulesTotal.WithLabelValues("true").Set(0)
+	rulesTotal.WithLabelValues("false").Set(0)
+	for _, r := range rules {
+		rulesTotal.WithLabelValues(strconv.FormatBool(r.Enabled)).Set(float64(r.Count))
+	}
+	return nil
+}
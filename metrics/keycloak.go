@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"time"
+
+	"go-agent-manager/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	keycloakRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "keycloak_request_duration_seconds",
+		Help:    "Latency of calls from this service to Keycloak, by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	keycloakRequestFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "keycloak_request_failures_total",
+		Help: "Number of failed calls to Keycloak, by operation.",
+	}, []string{"operation"})
+)
+
+// ObserveKeycloakRequest 记录一次 Keycloak 调用的耗时，err 不为空时同时计入失败次数
+// METRICS_ENABLED 关闭时不记录
+func ObserveKeycloakRequest(operation string, start time.Time, err error) {
+	if !config.AppConfig.MetricsEnabled {
+		return
+	}
+	keycloakRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		keycloakRequestFailures.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,11 @@
+package metrics
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler 返回暴露默认 Prometheus 注册表的 /metrics 处理器
+func Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.Handler())
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequireBindingMiddleware 在开启 REQUIRE_BINDING_FOR_RULES 时，要求 Agent Key 关联的设备存在有效绑定才能获取规则
+// 有效绑定: 状态为 active 且未过期；设备本身不能已停用、删除或封禁
+// 必须挂在 APIKeyMiddleware 之后 (依赖上下文中的设备 ID)
+func RequireBindingMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !config.AppConfig.RequireBindingForRules {
+			return next(c)
+		}
+
+		deviceID, _ := c.Get(AgentDeviceID).(string)
+		if deviceID == "" {
+			return deviceNotAuthorized("Agent key is not associated with a device")
+		}
+
+		var device models.Device
+		if err := db.DB.Select("id", "decommissioned_at", "blocked_at").First(&device, "id = ?", deviceID).Error; err != nil ||
+			device.DecommissionedAt != nil || device.BlockedAt != nil {
+			return deviceNotAuthorized("Device is unknown, deleted, decommissioned or blocked")
+		}
+
+		var count int64
+		err := db.DB.Model(&models.UserDeviceBinding{}).
+			Where("device_id = ? AND status = ? AND (expires_at IS NULL OR expires_at > ?)",
+				deviceID, models.BindingStatusActive, time.Now()).
+			Count(&count).Error
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Binding lookup failed")
+		}
+		if count == 0 {
+			return deviceNotAuthorized("Device has no active binding")
+		}
+		return next(c)
+	}
+}
+
+// deviceNotAuthorized 未授权设备的响应，附带空规则集以便 Agent 清空本地规则
+func deviceNotAuthorized(msg string) error {
+	return apierror.New(http.StatusForbidden, "device_not_authorized", msg).
+		WithDetails(map[string]interface{}{"rules": []models.AgentRule{}})
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/db/dbtest"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// runRequireBinding 以给定的 Agent 设备 ID 执行 RequireBindingMiddleware，返回是否放行以及拒绝时的错误
+func runRequireBinding(deviceID string) (bool, error) {
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/agent/rules", nil), httptest.NewRecorder())
+	if deviceID != "" {
+		c.Set(AgentDeviceID, deviceID)
+	}
+	passed := false
+	err := RequireBindingMiddleware(func(echo.Context) error {
+		passed = true
+		return nil
+	})(c)
+	return passed, err
+}
+
+func TestRequireBindingMiddlewareDisabled(t *testing.T) {
+	prev := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = prev })
+	config.AppConfig.RequireBindingForRules = false
+
+	if passed, err := runRequireBinding(""); !passed || err != nil {
+		t.Errorf("disabled middleware: passed=%v err=%v, want request passed through", passed, err)
+	}
+}
+
+func TestRequireBindingMiddleware(t *testing.T) {
+	dbtest.Open(t, "middleware_test")
+	config.AppConfig.RequireBindingForRules = true
+
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name       string
+		device     func(*models.Device) // 为 nil 时不创建设备
+		deleted    bool
+		binding    *models.UserDeviceBinding
+		authorized bool
+	}{
+		{"active binding", func(*models.Device) {}, false,
+			&models.UserDeviceBinding{Status: models.BindingStatusActive}, true},
+		{"active binding expiring later", func(*models.Device) {}, false,
+			&models.UserDeviceBinding{Status: models.BindingStatusActive, ExpiresAt: &future}, true},
+		{"expired binding", func(*models.Device) {}, false,
+			&models.UserDeviceBinding{Status: models.BindingStatusActive, ExpiresAt: &past}, false},
+		{"inactive binding", func(*models.Device) {}, false,
+			&models.UserDeviceBinding{Status: models.BindingStatusInactive}, false},
+		{"pending binding", func(*models.Device) {}, false,
+			&models.UserDeviceBinding{Status: models.BindingStatusPendingApproval}, false},
+		{"no binding", func(*models.Device) {}, false, nil, false},
+		{"unknown device", nil, false, nil, false},
+		{"deleted device", func(*models.Device) {}, true,
+			&models.UserDeviceBinding{Status: models.BindingStatusActive}, false},
+		{"decommissioned device", func(d *models.Device) { d.DecommissionedAt = &now }, false,
+			&models.UserDeviceBinding{Status: models.BindingStatusActive}, false},
+		{"blocked device", func(d *models.Device) { d.BlockedAt = &now }, false,
+			&models.UserDeviceBinding{Status: models.BindingStatusActive}, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deviceID := "9a8b7c6d-5e4f-4a3b-9c2d-1e0f9a8b7c6d"
+			if tt.device != nil {
+				device := models.Device{UniqueHardwareID: fmt.Sprintf("hw-%d", i), LastSeenAt: now}
+				tt.device(&device)
+				if err := db.DB.Create(&device).Error; err != nil {
+					t.Fatalf("create device: %v", err)
+				}
+				deviceID = device.ID
+				if tt.binding != nil {
+					binding := *tt.binding
+					binding.KeycloakUserID, binding.DeviceID, binding.BoundAt = "user-1", device.ID, now
+					if err := db.DB.Create(&binding).Error; err != nil {
+						t.Fatalf("create binding: %v", err)
+					}
+				}
+				if tt.deleted {
+					if err := db.DB.Delete(&device).Error; err != nil {
+						t.Fatalf("delete device: %v", err)
+					}
+				}
+			}
+
+			passed, err := runRequireBinding(deviceID)
+			if tt.authorized {
+				if !passed || err != nil {
+					t.Fatalf("passed=%v err=%v, want authorized", passed, err)
+				}
+				return
+			}
+			assertDeviceNotAuthorized(t, passed, err)
+		})
+	}
+
+	t.Run("no device in context", func(t *testing.T) {
+		passed, err := runRequireBinding("")
+		assertDeviceNotAuthorized(t, passed, err)
+	})
+}
+
+// assertDeviceNotAuthorized 检查请求被拒绝，且响应为附带空规则集的 403 device_not_authorized
+func assertDeviceNotAuthorized(t *testing.T, passed bool, err error) {
+	t.Helper()
+	if passed {
+		t.Fatal("request passed through, want 403 device_not_authorized")
+	}
+	var apiErr *apierror.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusForbidden || apiErr.Code != "device_not_authorized" {
+		t.Fatalf("error = %v, want 403 device_not_authorized", err)
+	}
+	if rules, ok := apiErr.Details.(map[string]interface{})["rules"].([]models.AgentRule); !ok || len(rules) != 0 {
+		t.Errorf("details = %#v, want an empty rules list", apiErr.Details)
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/logger"
+	"go-agent-manager/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Agent API Key 相关的请求头和上下文键
+const (
+	HeaderAgentKey = "X-Agent-Key"
+	AgentKeyID     = "agentKeyID"
+	AgentDeviceID  = "agentDeviceID"
+)
+
+// agentKeyTouchInterval LastUsedAt 的最小更新间隔，避免 Agent 的每个请求都写库
+const agentKeyTouchInterval = time.Minute
+
+// HashAgentKey 计算 API Key 的存储哈希
+func HashAgentKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyMiddleware 校验 X-Agent-Key 请求头，并将 Key ID 和关联的设备 ID 写入上下文
+// 已吊销 (软删除) 或已过期的 Key 一律返回 401
+func APIKeyMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(HeaderAgentKey)
+		if key == "" {
+			return apierror.New(http.StatusUnauthorized, "agent_key_required", HeaderAgentKey+" header is required")
+		}
+
+		var agentKey models.AgentKey
+		err := db.DB.First(&agentKey, "key_hash = ?", HashAgentKey(key)).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return apierror.New(http.StatusUnauthorized, "invalid_agent_key", "Agent key is invalid or revoked")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Agent key lookup failed")
+		}
+		if agentKey.ExpiresAt != nil && !agentKey.ExpiresAt.After(time.Now()) {
+			return apierror.New(http.StatusUnauthorized, "agent_key_expired", "Agent key has expired")
+		}
+
+		touchAgentKey(c, agentKey)
+
+		c.Set(AgentKeyID, agentKey.ID)
+		c.Set(AgentDeviceID, agentKey.DeviceID)
+		return next(c)
+	}
+}
+
+// touchAgentKey 更新 Key 的 LastUsedAt，距上次更新不足 agentKeyTouchInterval 时跳过
+// 更新失败不影响本次请求；只读模式下不写库
+func touchAgentKey(c echo.Context, agentKey models.AgentKey) {
+	now := time.Now()
+	if config.AppConfig.ReadOnlyMode || (agentKey.LastUsedAt != nil && now.Sub(*agentKey.LastUsedAt) < agentKeyTouchInterval) {
+		return
+	}
+	err := db.DB.WithContext(c.Request().Context()).Model(&models.AgentKey{}).
+		Where("id = ?", agentKey.ID).Update("last_used_at", now).Error
+	if err != nil {
+		logger.For(c).Warn("failed to update agent key last use", "key_id", agentKey.ID, "error", err)
+	}
+}
@@ -1,10 +1,12 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
 	"go-agent-manager/keycloak"
+	"go-agent-manager/logger"
 
 	"github.com/labstack/echo/v4"
 )
@@ -37,9 +39,13 @@ func KeycloakAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		// c.Request().Context() 是 http.Request 的上下文，会被 ValidateAccessToken 使用
 		userID, roles, err := keycloak.ValidateAccessToken(c.Request().Context(), tokenString)
 		if err != nil {
-			// 根据错误类型返回不同的状态码
-			if strings.Contains(err.Error(), "token is not active") {
+			// 根据错误类型返回不同的状态码: token 本身的问题是 401，Keycloak 不可用是 503
+			switch {
+			case errors.Is(err, keycloak.ErrTokenInactive), errors.Is(err, keycloak.ErrTokenInvalid):
 				return echo.NewHTTPError(http.StatusUnauthorized, "Token expired or invalid")
+			case errors.Is(err, keycloak.ErrKeycloakUnavailable):
+				logger.For(c).Warn("token validation failed: Keycloak unavailable", "error", err)
+				return echo.NewHTTPError(http.StatusServiceUnavailable, "Authentication service unavailable")
 			}
 			return echo.NewHTTPError(http.StatusInternalServerError, "Token validation failed: "+err.Error())
 		}
@@ -52,34 +58,70 @@ func KeycloakAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-// RBACMiddleware 检查用户角色
+// RoleRequirement 访问某个路由所需的角色
+type RoleRequirement struct {
+	Roles []string
+	All   bool // true: 必须拥有 Roles 中的全部角色；false: 拥有任一即可
+}
+
+// SatisfiedBy 判断 userRoles 是否满足要求；Roles 为空时一律不满足
+func (r RoleRequirement) SatisfiedBy(userRoles []string) bool {
+	if len(r.Roles) == 0 {
+		return false
+	}
+	for _, requiredRole := range r.Roles {
+		has := hasRole(userRoles, requiredRole)
+		if has && !r.All {
+			return true
+		}
+		if !has && r.All {
+			return false
+		}
+	}
+	return r.All
+}
+
+// RBACMiddleware 要求用户拥有 requiredRoles 中的至少一个角色
 func RBACMiddleware(requiredRoles ...string) echo.MiddlewareFunc {
+	return requireRoles(RoleRequirement{Roles: requiredRoles})
+}
+
+// RBACMiddlewareAll 要求用户拥有 requiredRoles 中的全部角色
+func RBACMiddlewareAll(requiredRoles ...string) echo.MiddlewareFunc {
+	return requireRoles(RoleRequirement{Roles: requiredRoles, All: true})
+}
+
+// requireRoles 按固定的角色要求检查用户角色
+func requireRoles(required RoleRequirement) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			userRoles, ok := c.Get(UserRoles).([]string)
-			if !ok {
-				// 如果之前的 Auth 中间件成功了，这里理论上不应该发生，除非是逻辑错误
-				return echo.NewHTTPError(http.StatusForbidden, "User roles not found context")
+			if err := checkRoles(c, required); err != nil {
+				return err
 			}
+			return next(c)
+		}
+	}
+}
 
-			// 检查用户是否拥有所需的所有角色中的至少一个
-			hasRole := false
-			for _, requiredRole := range requiredRoles {
-				for _, userRole := range userRoles {
-					if userRole == requiredRole {
-						hasRole = true
-						break
-					}
-				}
-				if hasRole {
-					break // 找到了一个匹配的角色，跳出循环
-				}
-			}
+// checkRoles 从上下文取出 KeycloakAuthMiddleware 写入的用户角色并检查是否满足要求，不满足时返回 403
+func checkRoles(c echo.Context, required RoleRequirement) error {
+	userRoles, ok := c.Get(UserRoles).([]string)
+	if !ok {
+		// 如果之前的 Auth 中间件成功了，这里理论上不应该发生，除非是逻辑错误
+		return echo.NewHTTPError(http.StatusForbidden, "User roles not found context")
+	}
+	if !required.SatisfiedBy(userRoles) {
+		return echo.NewHTTPError(http.StatusForbidden, "Forbidden: insufficient roles")
+	}
+	return nil
+}
 
-			if !hasRole {
-				return echo.NewHTTPError(http.StatusForbidden, "Forbidden: insufficient roles")
-			}
-			return next(c)
+// hasRole 判断 userRoles 中是否包含 role
+func hasRole(userRoles []string, role string) bool {
+	for _, userRole := range userRoles {
+		if userRole == role {
+			return true
 		}
 	}
+	return false
 }
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRoleRequirementSatisfiedBy(t *testing.T) {
+	tests := []struct {
+		name      string
+		required  RoleRequirement
+		userRoles []string
+		want      bool
+	}{
+		{"any: has one", RoleRequirement{Roles: []string{"admin", "operator"}}, []string{"operator"}, true},
+		{"any: has all", RoleRequirement{Roles: []string{"admin", "operator"}}, []string{"admin", "operator"}, true},
+		{"any: has none", RoleRequirement{Roles: []string{"admin", "operator"}}, []string{"viewer"}, false},
+		{"all: has all", RoleRequirement{Roles: []string{"admin", "operator"}, All: true}, []string{"operator", "viewer", "admin"}, true},
+		{"all: missing one", RoleRequirement{Roles: []string{"admin", "operator"}, All: true}, []string{"admin"}, false},
+		{"all: has none", RoleRequirement{Roles: []string{"admin", "operator"}, All: true}, []string{"viewer"}, false},
+		{"any: user has no roles", RoleRequirement{Roles: []string{"admin"}}, nil, false},
+		{"all: user has no roles", RoleRequirement{Roles: []string{"admin"}, All: true}, nil, false},
+		{"any: no required roles", RoleRequirement{}, []string{"admin"}, false},
+		{"all: no required roles", RoleRequirement{All: true}, []string{"admin"}, false},
+		{"role names are case sensitive", RoleRequirement{Roles: []string{"admin"}}, []string{"Admin"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.required.SatisfiedBy(tt.userRoles); got != tt.want {
+				t.Errorf("%+v.SatisfiedBy(%q) = %v, want %v", tt.required, tt.userRoles, got, tt.want)
+			}
+		})
+	}
+}
+
+// runRBAC 以给定的上下文角色执行中间件，返回错误的 HTTP 状态码，放行时返回 200
+func runRBAC(t *testing.T, mw echo.MiddlewareFunc, roles interface{}) int {
+	t.Helper()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	if roles != nil {
+		c.Set(UserRoles, roles)
+	}
+	err := mw(func(echo.Context) error { return nil })(c)
+	if err == nil {
+		return http.StatusOK
+	}
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("unexpected error type %T: %v", err, err)
+	}
+	return httpErr.Code
+}
+
+func TestRBACMiddleware(t *testing.T) {
+	tests := []struct {
+		name  string
+		mw    echo.MiddlewareFunc
+		roles interface{}
+		want  int
+	}{
+		{"any: one of the roles", RBACMiddleware("admin", "operator"), []string{"operator"}, http.StatusOK},
+		{"any: none of the roles", RBACMiddleware("admin", "operator"), []string{"viewer"}, http.StatusForbidden},
+		{"all: every role", RBACMiddlewareAll("admin", "operator"), []string{"admin", "operator"}, http.StatusOK},
+		{"all: only one role", RBACMiddlewareAll("admin", "operator"), []string{"operator"}, http.StatusForbidden},
+		{"any: roles missing from context", RBACMiddleware("admin"), nil, http.StatusForbidden},
+		{"all: roles missing from context", RBACMiddlewareAll("admin"), nil, http.StatusForbidden},
+		{"roles of the wrong type", RBACMiddleware("admin"), "admin", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runRBAC(t, tt.mw, tt.roles); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
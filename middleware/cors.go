@@ -1,15 +1,46 @@
 package middleware
 
 import (
+	"strings"
+
+	"go-agent-manager/config"
+
 	"github.com/labstack/echo/v4"
 	e_middleware "github.com/labstack/echo/v4/middleware"
 )
 
 // CORSMiddleware 配置 CORS
+// 允许的来源由 CORS_ALLOWED_ORIGINS 决定，未配置时允许任意来源 (生产环境中应限制为前端域名)；
+// 配置了具体来源时允许携带凭据 (Cookie / Authorization)，通配符 "*" 与凭据不能同时使用
 func CORSMiddleware() echo.MiddlewareFunc {
+	origins := allowedOrigins(config.AppConfig.CORSAllowedOrigins)
+	allowCredentials := true
+	for _, origin := range origins {
+		if origin == "*" {
+			allowCredentials = false
+			break
+		}
+	}
 	return e_middleware.CORSWithConfig(e_middleware.CORSConfig{
-		AllowOrigins: []string{"*"}, // 生产环境中应限制为前端域名
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
-		AllowMethods: []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
+		AllowOrigins:     origins,
+		AllowCredentials: allowCredentials,
+		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+		AllowMethods:     []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
+		// 分页等信息通过响应头返回，需要显式暴露给浏览器端脚本
+		ExposeHeaders: []string{"X-Total-Count", "X-Next-Page-Token", echo.HeaderXRequestID},
 	})
 }
+
+// allowedOrigins 解析逗号分隔的来源列表，为空时返回 ["*"]
+func allowedOrigins(spec string) []string {
+	var origins []string
+	for _, origin := range strings.Split(spec, ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return []string{"*"}
+	}
+	return origins
+}
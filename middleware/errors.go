@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/logger"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HTTPErrorHandler 将所有错误统一渲染为标准错误信封
+// {"error": {"code": "...", "message": "...", "details": ..., "request_id": "..."}}
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	body := apierror.Body{
+		Code:    "internal_error",
+		Message: "Internal server error",
+	}
+
+	var apiErr *apierror.Error
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.Status
+		body.Code = apiErr.Code
+		body.Message = apiErr.Message
+		body.Details = apiErr.Details
+	case errors.As(err, &httpErr):
+		status = httpErr.Code
+		body.Code = apierror.CodeForStatus(status)
+		body.Message = fmt.Sprint(httpErr.Message)
+	default:
+		// 未知错误不向客户端暴露内部细节
+		logger.For(c).Error("unhandled error", "method", c.Request().Method, "path", c.Request().URL.Path, "error", err)
+	}
+	body.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+
+	var respErr error
+	if c.Request().Method == http.MethodHead {
+		respErr = c.NoContent(status)
+	} else {
+		respErr = c.JSON(status, apierror.Envelope{Error: body})
+	}
+	if respErr != nil {
+		logger.For(c).Error("failed to write error response", "error", respErr)
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/config"
+
+	"github.com/labstack/echo/v4"
+	e_middleware "github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+)
+
+// agentRateLimiterExpiry 客户端 IP 超过该时长没有请求时释放其限流状态
+const agentRateLimiterExpiry = 3 * time.Minute
+
+// AgentRateLimitMiddleware 按客户端 IP 限制 Agent 接口 (/api/agent、设备心跳) 的请求速率
+// 每个 IP 每分钟最多 AGENT_RATE_LIMIT 个请求 (令牌桶，允许一分钟额度内的突发)，超出时返回 429 和 Retry-After；
+// 为 0 时不限流。挂在 APIKeyMiddleware 之前，无效 Key 的请求同样计数，不会打到数据库。
+// 共用出口 IP (NAT) 的 Agent 共享同一额度，按出口后的 Agent 数量设置；限流状态保存在进程内，多实例各自计数
+func AgentRateLimitMiddleware() echo.MiddlewareFunc {
+	perMinute := config.AppConfig.AgentRateLimit
+	if perMinute <= 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+
+	// 恢复一个令牌所需的时间，作为 Retry-After 的秒数
+	retryAfter := strconv.Itoa(int(math.Ceil(60 / float64(perMinute))))
+	return e_middleware.RateLimiterWithConfig(e_middleware.RateLimiterConfig{
+		Store: e_middleware.NewRateLimiterMemoryStoreWithConfig(e_middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(float64(perMinute) / 60),
+			Burst:     perMinute,
+			ExpiresIn: agentRateLimiterExpiry,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return c.RealIP(), nil
+		},
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			c.Response().Header().Set(echo.HeaderRetryAfter, retryAfter)
+			return apierror.New(http.StatusTooManyRequests, "rate_limited", "Too many requests; retry later").
+				WithDetails(map[string]interface{}{"limit_per_minute": perMinute})
+		},
+	})
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go-agent-manager/apierror"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ReadOnlyMiddleware 在只读模式下拒绝所有写请求 (POST/PUT/PATCH/DELETE)，返回 503
+// exemptRoutes 为不修改数据的 POST 路由 (例如批量查询、模拟)，格式为 "METHOD /route"，与 ROUTE_ROLES 的键一致
+func ReadOnlyMiddleware(exemptRoutes ...string) echo.MiddlewareFunc {
+	exempt := make(map[string]bool, len(exemptRoutes))
+	for _, r := range exemptRoutes {
+		exempt[r] = true
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := c.Request().Method
+			switch method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				return next(c)
+			}
+			if exempt[method+" "+c.Path()] {
+				return next(c)
+			}
+			return apierror.New(http.StatusServiceUnavailable, "read_only_mode",
+				"This instance is running in read-only mode; write requests are not accepted")
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net"
+	"strings"
+
+	"go-agent-manager/config"
+
+	"github.com/labstack/echo/v4"
+)
+
+// IPExtractor 返回 c.RealIP() 使用的客户端 IP 提取方式
+// 未配置 TRUSTED_PROXIES 时直接使用连接的对端地址，忽略客户端可以伪造的 X-Forwarded-For / X-Real-IP；
+// 配置后只信任来自这些代理的 X-Forwarded-For，限流、审计和请求日志中的 IP 都以此为准
+func IPExtractor() echo.IPExtractor {
+	proxies := trustedProxies(config.AppConfig.TrustedProxies)
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	// 关闭 Echo 默认对回环、链路本地和私有网段的信任，只信任显式配置的代理
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, network := range proxies {
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// trustedProxies 解析逗号分隔的 IP 或 CIDR 列表，单个 IP 视为 /32 (IPv6 为 /128)
+// 无法解析的项已在配置校验时报错，这里直接跳过
+func trustedProxies(spec string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return networks
+}
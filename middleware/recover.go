@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"go-agent-manager/apierror"
+	"go-agent-manager/audit"
+	"go-agent-manager/logger"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RecoverMiddleware 捕获处理链中的 panic
+// 堆栈只写入结构化日志，客户端仅收到标准错误信封，同时记录一条安全审计事件
+func RecoverMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				// http.ErrAbortHandler 是有意中断连接，保持标准库语义
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+
+				req := c.Request()
+				requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+				actorID, _ := c.Get(UserKeycloakID).(string)
+
+				logger.For(c).Error("panic recovered",
+					"panic", fmt.Sprint(r),
+					"method", req.Method,
+					"path", req.URL.Path,
+					"stack", string(debug.Stack()),
+				)
+
+				// 请求上下文可能已取消，审计写入使用独立的 context
+				audit.Record(context.Background(), audit.Entry{
+					ActorID:      actorID,
+					Action:       "request.panic",
+					ResourceType: "http_request",
+					ResourceID:   c.Path(),
+					RequestID:    requestID,
+					RemoteIP:     c.RealIP(),
+					Details: map[string]interface{}{
+						"method": req.Method,
+						"uri":    req.RequestURI,
+						"panic":  fmt.Sprint(r),
+					},
+				})
+
+				err = apierror.New(http.StatusInternalServerError, "internal_error", "Internal server error")
+			}()
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+
+	"go-agent-manager/config"
+	"go-agent-manager/logger"
+
+	"github.com/labstack/echo/v4"
+	e_middleware "github.com/labstack/echo/v4/middleware"
+)
+
+// RequestLogMiddleware 每个请求输出一行 JSON 结构化日志 (是否受 LOG_LEVEL 过滤见 ACCESS_LOG)
+// 包含请求 ID (同时通过 X-Request-Id 响应头返回给客户端)、已认证的 Keycloak 用户 ID、方法、路径、状态码和耗时
+// 必须挂在 RequestID 中间件之后
+func RequestLogMiddleware() echo.MiddlewareFunc {
+	return e_middleware.RequestLoggerWithConfig(e_middleware.RequestLoggerConfig{
+		// 先交给全局错误处理器生成响应，日志中的状态码才是客户端实际收到的
+		HandleError:  true,
+		LogRequestID: true,
+		LogMethod:    true,
+		LogURIPath:   true,
+		LogRoutePath: true,
+		LogStatus:    true,
+		LogLatency:   true,
+		LogRemoteIP:  true,
+		LogError:     true,
+		LogValuesFunc: func(c echo.Context, v e_middleware.RequestLoggerValues) error {
+			attrs := []slog.Attr{
+				slog.String("request_id", v.RequestID),
+				slog.String("method", v.Method),
+				slog.String("path", v.URIPath),
+				slog.String("route", v.RoutePath),
+				slog.Int("status", v.Status),
+				slog.Int64("latency_ms", v.Latency.Milliseconds()),
+				slog.String("remote_ip", v.RemoteIP),
+			}
+			// 认证中间件在路由组内执行，到这里时已经写入上下文
+			if userID, ok := c.Get(UserKeycloakID).(string); ok && userID != "" {
+				attrs = append(attrs, slog.String("keycloak_user_id", userID))
+			}
+			if v.Error != nil {
+				attrs = append(attrs, slog.String("error", v.Error.Error()))
+			}
+
+			logRequest(v.Status, attrs)
+			return nil
+		},
+	})
+}
+
+// logRequest 按状态码选择级别输出请求日志: 5xx 为 error，4xx 为 warn，其余为 info
+// 开启 ACCESS_LOG 时写入不受 LOG_LEVEL 限制的访问日志；关闭时按 LOG_LEVEL 过滤，默认 warn 下仍保留 4xx 和 5xx
+func logRequest(status int, attrs []slog.Attr) {
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case status >= 400:
+		level = slog.LevelWarn
+	}
+	log := logger.Log
+	if config.AppConfig.AccessLog {
+		log = logger.Access
+	}
+	log.LogAttrs(context.Background(), level, "request", attrs...)
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-agent-manager/config"
+	"go-agent-manager/logger"
+
+	"github.com/labstack/echo/v4"
+	e_middleware "github.com/labstack/echo/v4/middleware"
+)
+
+// captureLogs 将应用日志 (按 warn 过滤，对应默认 LOG_LEVEL) 和访问日志分别重定向到缓冲区
+func captureLogs(t *testing.T) (app, access *bytes.Buffer) {
+	t.Helper()
+	prevLog, prevAccess, prevConfig := logger.Log, logger.Access, config.AppConfig
+	t.Cleanup(func() {
+		logger.Log, logger.Access, config.AppConfig = prevLog, prevAccess, prevConfig
+	})
+	app, access = new(bytes.Buffer), new(bytes.Buffer)
+	logger.Log = slog.New(slog.NewJSONHandler(app, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	logger.Access = slog.New(slog.NewJSONHandler(access, nil))
+	return app, access
+}
+
+// serveLogged 经过 RequestID 和 RequestLogMiddleware 处理一个返回 status 的请求
+func serveLogged(status int) {
+	e := echo.New()
+	e.Use(e_middleware.RequestID(), RequestLogMiddleware())
+	e.GET("/", func(c echo.Context) error {
+		if status >= 400 {
+			return echo.NewHTTPError(status)
+		}
+		return c.NoContent(status)
+	})
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+// logLevel 返回缓冲区中唯一一条日志的级别，没有日志时返回空字符串
+func logLevel(t *testing.T, buf *bytes.Buffer) string {
+	t.Helper()
+	if buf.Len() == 0 {
+		return ""
+	}
+	var entry struct {
+		Level  string `json:"level"`
+		Msg    string `json:"msg"`
+		Status int    `json:"status"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decode log line %q: %v", buf.String(), err)
+	}
+	if entry.Msg != "request" {
+		t.Fatalf("unexpected log message %q", entry.Msg)
+	}
+	return entry.Level
+}
+
+func TestRequestLogLevels(t *testing.T) {
+	tests := []struct {
+		status    int
+		accessLog bool
+		wantApp   string // 应用日志中的级别，空表示被 LOG_LEVEL 过滤
+		wantAcc   string // 访问日志中的级别
+	}{
+		{http.StatusOK, true, "", "INFO"},
+		{http.StatusNotFound, true, "", "WARN"},
+		{http.StatusInternalServerError, true, "", "ERROR"},
+		{http.StatusOK, false, "", ""},
+		{http.StatusForbidden, false, "WARN", ""},
+		{http.StatusServiceUnavailable, false, "ERROR", ""},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/access_log=%v", tt.status, tt.accessLog), func(t *testing.T) {
+			app, access := captureLogs(t)
+			config.AppConfig.AccessLog = tt.accessLog
+			serveLogged(tt.status)
+			if got := logLevel(t, app); got != tt.wantApp {
+				t.Errorf("ACCESS_LOG=%v: application log level %q, want %q", tt.accessLog, got, tt.wantApp)
+			}
+			if got := logLevel(t, access); got != tt.wantAcc {
+				t.Errorf("ACCESS_LOG=%v: access log level %q, want %q", tt.accessLog, got, tt.wantAcc)
+			}
+		})
+	}
+}
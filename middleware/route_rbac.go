@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RouteRoles 路由到所需角色的映射，键为 "METHOD /route/pattern"
+type RouteRoles map[string]RoleRequirement
+
+// ParseRouteRoles 解析 ROUTE_ROLES 配置
+//
+// 格式: 多条映射用 ";" 分隔，每条为 "<METHOD> <路由>=<角色1>|<角色2>"
+//   - METHOD 为 HTTP 方法，"*" 表示任意方法
+//   - 路由使用 Echo 注册时的路由模式 (包含 :id 等参数占位符)，而不是实际请求路径
+//   - 角色之间用 "|" 分隔表示"任一即可"，用 "&" 分隔表示"必须全部拥有"，同一条映射中不能混用
+//
+// 示例: "POST /api/admin/devices=devices:write|admin; * /api/admin/rules/:id=rules:write; DELETE /api/admin/devices/:id=admin&superadmin"
+func ParseRouteRoles(spec string) (RouteRoles, error) {
+	mapping := RouteRoles{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, roles, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("route role mapping %q is missing '='", entry)
+		}
+		fields := strings.Fields(route)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("route role mapping %q must start with \"<METHOD> <route>\"", entry)
+		}
+		all := strings.Contains(roles, "&")
+		if all && strings.Contains(roles, "|") {
+			return nil, fmt.Errorf("route role mapping %q mixes '|' and '&'", entry)
+		}
+		separator := "|"
+		if all {
+			separator = "&"
+		}
+		required := RoleRequirement{All: all}
+		for _, role := range strings.Split(roles, separator) {
+			if role = strings.TrimSpace(role); role != "" {
+				required.Roles = append(required.Roles, role)
+			}
+		}
+		if len(required.Roles) == 0 {
+			return nil, fmt.Errorf("route role mapping %q has no roles", entry)
+		}
+		mapping[strings.ToUpper(fields[0])+" "+fields[1]] = required
+	}
+	return mapping, nil
+}
+
+// RouteRBACMiddleware 按路由映射检查用户角色
+// 优先匹配 "METHOD 路由"，其次 "* 路由"，都未配置时要求 fallbackRoles 中的任一角色
+func RouteRBACMiddleware(mapping RouteRoles, fallbackRoles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			required, ok := mapping[c.Request().Method+" "+c.Path()]
+			if !ok {
+				required, ok = mapping["* "+c.Path()]
+			}
+			if !ok {
+				required = RoleRequirement{Roles: fallbackRoles}
+			}
+
+			if err := checkRoles(c, required); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
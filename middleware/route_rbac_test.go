@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestParseRouteRoles(t *testing.T) {
+	got, err := ParseRouteRoles(" post /api/admin/devices=devices:write|admin ;; * /api/admin/rules/:id = rules:write ; DELETE /api/admin/devices/:id=admin & superadmin ")
+	if err != nil {
+		t.Fatalf("ParseRouteRoles: %v", err)
+	}
+	want := RouteRoles{
+		"POST /api/admin/devices":       {Roles: []string{"devices:write", "admin"}},
+		"* /api/admin/rules/:id":        {Roles: []string{"rules:write"}},
+		"DELETE /api/admin/devices/:id": {Roles: []string{"admin", "superadmin"}, All: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRouteRoles = %#v, want %#v", got, want)
+	}
+
+	for _, bad := range []string{
+		"POST /api/admin/devices",             // 缺少 "="
+		"/api/admin/devices=admin",            // 缺少方法
+		"POST /api/admin/devices=",            // 没有角色
+		"POST /api/admin/devices=| &",         // 只有分隔符
+		"POST /api/admin/devices=admin|ops&x", // 混用 | 和 &
+	} {
+		if _, err := ParseRouteRoles(bad); err == nil {
+			t.Errorf("ParseRouteRoles(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestRouteRBACMiddleware(t *testing.T) {
+	mapping, err := ParseRouteRoles("DELETE /api/admin/devices/:id=admin&superadmin; * /api/admin/devices/:id=devices:read|admin")
+	if err != nil {
+		t.Fatalf("ParseRouteRoles: %v", err)
+	}
+	mw := RouteRBACMiddleware(mapping, "admin")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		roles  []string
+		want   int
+	}{
+		{"method mapping requires all roles", http.MethodDelete, "/api/admin/devices/:id", []string{"admin", "superadmin"}, http.StatusOK},
+		{"method mapping missing one role", http.MethodDelete, "/api/admin/devices/:id", []string{"admin"}, http.StatusForbidden},
+		{"wildcard mapping any role", http.MethodGet, "/api/admin/devices/:id", []string{"devices:read"}, http.StatusOK},
+		{"wildcard mapping no role", http.MethodGet, "/api/admin/devices/:id", []string{"viewer"}, http.StatusForbidden},
+		{"fallback role", http.MethodGet, "/api/admin/rules", []string{"admin"}, http.StatusOK},
+		{"fallback without role", http.MethodGet, "/api/admin/rules", []string{"devices:read"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := echo.New().NewContext(httptest.NewRequest(tt.method, "/", nil), httptest.NewRecorder())
+			c.SetPath(tt.path)
+			c.Set(UserRoles, tt.roles)
+			got := http.StatusOK
+			if err := mw(func(echo.Context) error { return nil })(c); err != nil {
+				var httpErr *echo.HTTPError
+				if !errors.As(err, &httpErr) {
+					t.Fatalf("unexpected error type %T: %v", err, err)
+				}
+				got = httpErr.Code
+			}
+			if got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
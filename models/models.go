@@ -9,50 +9,216 @@ import (
 // Device 客户端 Agent 上报的设备信息
 type Device struct {
 	gorm.Model
-	ID               string `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"` // 使用 UUID 作为主键
-	UniqueHardwareID string `gorm:"uniqueIndex;not null" json:"unique_hardware_id"`          // 设备的唯一硬件ID (BIOS UUID, Serial Number等)
-	OS               string `json:"os"`                                                        // 操作系统
-	Hostname         string `json:"hostname"`                                                  // 主机名
-	LastSeenAt       time.Time `json:"last_seen_at"`                                             // 最后一次 Agent 上报时间
+	ID               string            `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`                               // 使用 UUID 作为主键
+	UniqueHardwareID string            `gorm:"uniqueIndex;not null" json:"unique_hardware_id"`                                         // 设备的唯一硬件ID (BIOS UUID, Serial Number等)
+	OS               string            `gorm:"index:idx_devices_os" json:"os"`                                                         // 操作系统 (索引: 按 os 精确过滤)
+	Hostname         string            `gorm:"index:idx_devices_hostname" json:"hostname"`                                             // 主机名 (索引: 按 hostname 精确/前缀过滤)
+	AgentVersion     string            `gorm:"index:idx_devices_agent_version" json:"agent_version"`                                   // Agent 上报的版本号，如 1.2.3 (索引: 按版本过滤及统计升级进度)
+	LastSeenAt       time.Time         `gorm:"index:idx_devices_last_seen_at" json:"last_seen_at"`                                     // 最后一次 Agent 上报时间 (索引: 在线/离线过滤及按时间排序)
+	DecommissionedAt *time.Time        `json:"decommissioned_at"`                                                                      // 停用时间，停用的设备不再参与管理，查询时返回 410
+	Capabilities     []string          `gorm:"type:jsonb;serializer:json;index:idx_devices_capabilities,type:gin" json:"capabilities"` // Agent 上报的能力列表，用于过滤下发的规则 (GIN 索引: 按能力包含过滤)
+	BlockedAt        *time.Time        `json:"blocked_at"`                                                                             // 封禁时间，封禁的设备不能被绑定
+	BlockReason      string            `json:"block_reason,omitempty"`                                                                 // 封禁原因
+	Tags             map[string]string `gorm:"type:jsonb;serializer:json;index:idx_devices_tags,type:gin" json:"tags"`                 // 管理员维护的标签，如 dept=finance、env=prod (GIN 索引: 按标签过滤)
 	// 其他可以采集的设备信息...
 }
 
+// ArchivedDevice 长期未上报而被归档的设备 (archived_devices 表)，连同其绑定一起移出热表
+// 设备以相同硬件 ID 重新注册时恢复回 devices 表
+type ArchivedDevice struct {
+	ID               string              `gorm:"primaryKey;type:uuid" json:"id"` // 原设备 ID，恢复时沿用
+	UniqueHardwareID string              `gorm:"uniqueIndex;not null" json:"unique_hardware_id"`
+	OS               string              `json:"os"`
+	Hostname         string              `json:"hostname"`
+	AgentVersion     string              `json:"agent_version"`
+	LastSeenAt       time.Time           `json:"last_seen_at"`
+	DecommissionedAt *time.Time          `json:"decommissioned_at"`
+	Capabilities     []string            `gorm:"type:jsonb;serializer:json" json:"capabilities"`
+	BlockedAt        *time.Time          `json:"blocked_at"`
+	BlockReason      string              `json:"block_reason,omitempty"`
+	Tags             map[string]string   `gorm:"type:jsonb;serializer:json" json:"tags"`
+	DeviceCreatedAt  time.Time           `json:"device_created_at"`                          // 设备最初注册时间
+	Bindings         []UserDeviceBinding `gorm:"type:jsonb;serializer:json" json:"bindings"` // 归档时该设备的绑定
+	CreatedAt        time.Time           `json:"archived_at"`                                // 归档时间
+}
+
+// 设备在线状态 (根据 LastSeenAt 计算，不落库)
+const (
+	DeviceStatusOnline  = "online"
+	DeviceStatusOffline = "offline"
+)
+
 // UserDeviceBinding 用户与设备的绑定关系
 type UserDeviceBinding struct {
 	gorm.Model
-	ID           string `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	KeycloakUserID string `gorm:"uniqueIndex:idx_user_device_binding;not null" json:"keycloak_user_id"` // Keycloak 中用户的 ID (sub)
-	DeviceID     string `gorm:"uniqueIndex:idx_user_device_binding;not null" json:"device_id"`          // 关联的设备 ID
-	Status       string `gorm:"default:'active';not null" json:"status"`                            // 绑定状态: active, inactive, pending_approval
-	BoundAt      time.Time `json:"bound_at"`
-	UnboundAt    *time.Time `json:"unbound_at"` // 解绑时间，可为空
-	// Device         Device `gorm:"foreignKey:DeviceID"` // 可选，如果需要GORM自动加载关联
+	ID             string     `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	KeycloakUserID string     `gorm:"uniqueIndex:idx_user_device_binding_live,where:deleted_at IS NULL;not null" json:"keycloak_user_id"`                       // Keycloak 中用户的 ID (sub)，复合唯一索引的首列，按用户过滤时可直接使用；唯一索引只覆盖未删除的绑定，解绑后可以重新绑定
+	DeviceID       string     `gorm:"uniqueIndex:idx_user_device_binding_live,where:deleted_at IS NULL;index:idx_bindings_device_id;not null" json:"device_id"` // 关联的设备 ID (单独索引: 按设备查询绑定，复合唯一索引以 keycloak_user_id 开头无法覆盖)
+	Status         string     `gorm:"default:'active';not null;index:idx_bindings_status" json:"status"`                                                        // 绑定状态: active, inactive, pending_approval, rejected，转换规则见 bindings 包 (索引: 按状态过滤、过期扫描)
+	BoundAt        time.Time  `json:"bound_at"`
+	UnboundAt      *time.Time `json:"unbound_at"`                                // 解绑时间，可为空
+	ExpiresAt      *time.Time `json:"expires_at"`                                // 绑定过期时间，为空表示永久有效
+	Device         *Device    `gorm:"foreignKey:DeviceID;constraint:-" json:"-"` // 关联设备，仅在 Preload 时填充；不创建外键约束 (归档会先删绑定再删设备)
 }
 
+// 绑定状态
+const (
+	BindingStatusActive          = "active"
+	BindingStatusInactive        = "inactive"
+	BindingStatusPendingApproval = "pending_approval"
+	BindingStatusRejected        = "rejected"
+)
+
 // Rule 代理规则
 type Rule struct {
 	gorm.Model
-	ID          string `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	Name        string `gorm:"uniqueIndex;not null" json:"name"` // 规则名称
-	Type        string `gorm:"not null" json:"type"`             // 规则类型: http-proxy, tcp-proxy
-	Match       string `gorm:"not null" json:"match"`            // 匹配条件: 域名, IP:Port
-	Action      string `gorm:"not null" json:"action"`           // 动作: proxy, block, direct
-	Description string `json:"description"`
+	ID                   string   `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name                 string   `gorm:"uniqueIndex;not null" json:"name"`                            // 规则名称
+	Type                 string   `gorm:"not null;index:idx_rules_type" json:"type"`                   // 规则类型: http-proxy, tcp-proxy (索引: 按 type 过滤)
+	Match                string   `gorm:"not null" json:"match"`                                       // 匹配条件: 域名, IP:Port
+	Action               string   `gorm:"not null" json:"action"`                                      // 动作: proxy, block, direct
+	Enabled              *bool    `gorm:"not null;default:true" json:"enabled"`                        // 是否启用，停用的规则保留但不下发给 Agent；未指定时为 true (指针类型，保证 false 不被 GORM 当作零值替换为默认值)
+	Priority             int      `gorm:"not null;default:0;index:idx_rules_priority" json:"priority"` // 优先级，数值越小越先匹配；相同优先级按创建时间排序
+	Description          string   `json:"description"`
+	ActiveSchedule       string   `json:"active_schedule"`                                         // 生效时间窗口，例如 "Mon-Fri 09:00-18:00"，为空表示始终生效
+	RequiredCapabilities []string `gorm:"type:jsonb;serializer:json" json:"required_capabilities"` // 执行该规则所需的 Agent 能力，不具备的设备不会收到该规则
+}
+
+// IsEnabled 规则是否启用，未设置时视为启用
+func (r Rule) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// 规则类型
+const (
+	RuleTypeHTTPProxy = "http-proxy"
+	RuleTypeTCPProxy  = "tcp-proxy"
+)
+
+// 规则动作
+const (
+	RuleActionProxy  = "proxy"  // 经代理转发
+	RuleActionBlock  = "block"  // 拒绝访问
+	RuleActionDirect = "direct" // 直连，不经过代理
+)
+
+// RuleTypes / RuleActions 规则类型和动作的允许值，服务端校验、Agent 和管理界面共用
+var (
+	RuleTypes   = []string{RuleTypeHTTPProxy, RuleTypeTCPProxy}
+	RuleActions = []string{RuleActionProxy, RuleActionBlock, RuleActionDirect}
+)
+
+// Agent 能力 (规则可声明依赖，设备上报自身支持的能力)
+const (
+	CapabilityCIDRMatch     = "cidr-match"     // 按 CIDR 网段匹配
+	CapabilityWildcardMatch = "wildcard-match" // 通配域名匹配 (*.example.com)
+	CapabilityPortMatch     = "port-match"     // 按端口匹配
+	CapabilitySchedule      = "schedule"       // 按生效时间窗口启用规则
+	CapabilityTCPProxy      = "tcp-proxy"      // TCP 代理
+)
+
+// KnownCapabilities 规则可声明的全部能力
+var KnownCapabilities = []string{
+	CapabilityCIDRMatch,
+	CapabilityWildcardMatch,
+	CapabilityPortMatch,
+	CapabilitySchedule,
+	CapabilityTCPProxy,
+}
+
+// AgentRule 下发给 Agent 的精简规则格式
+type AgentRule struct {
+	ID                   string   `json:"id"`
+	Name                 string   `json:"name"`
+	Type                 string   `json:"type"`
+	Match                string   `json:"match"`
+	Action               string   `json:"action"`
+	Priority             int      `json:"priority"`
+	ActiveSchedule       string   `json:"active_schedule,omitempty"`
+	RequiredCapabilities []string `json:"required_capabilities,omitempty"`
+}
+
+// Agent 无法连接服务端时的处理策略 (AGENT_OFFLINE_POLICY)
+const (
+	// OfflinePolicyProxyAll 全部流量走代理 (fail-open): 不中断业务，但离线期间不再执行 block 规则
+	OfflinePolicyProxyAll = "proxy-all"
+	// OfflinePolicyBlockAll 阻断全部流量 (fail-closed): 最安全，但服务端故障会导致所有终端断网
+	OfflinePolicyBlockAll = "block-all"
+	// OfflinePolicyLastKnown 继续执行本地缓存的最近一次规则集: 折中方案，离线期间的规则变更 (含撤销) 不会生效
+	OfflinePolicyLastKnown = "last-known"
+)
+
+// RuleSnapshot 规则集快照，每个能力分组按 ETag 保存最近若干个版本，用于计算增量差异
+type RuleSnapshot struct {
+	gorm.Model
+	ID            string      `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ETag          string      `gorm:"column:etag;uniqueIndex:idx_rule_snapshots_etag_key,priority:1;not null" json:"etag"`                                                   // 按 ETag 查找快照时使用复合唯一索引的首列
+	CapabilityKey string      `gorm:"uniqueIndex:idx_rule_snapshots_etag_key,priority:2;not null;default:'*';index:idx_rule_snapshots_capability_key" json:"capability_key"` // 规则集所属的能力分组 (见 ruleset 包)，保留数量按分组计算 (索引: 按分组清理旧快照)
+	Rules         []AgentRule `gorm:"type:jsonb;serializer:json" json:"rules"`
+}
+
+// RuleApplication 设备上报的规则应用结果，每个 (设备, 规则) 只保留最近一次
+type RuleApplication struct {
+	gorm.Model
+	ID         string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	DeviceID   string    `gorm:"uniqueIndex:idx_rule_application;not null" json:"device_id"`
+	RuleID     string    `gorm:"uniqueIndex:idx_rule_application;index;not null" json:"rule_id"` // 单独索引: 按规则查询应用状态
+	Status     string    `gorm:"not null" json:"status"`                                         // applied / failed
+	Error      string    `json:"error,omitempty"`                                                // 失败原因
+	ReportedAt time.Time `json:"reported_at"`                                                    // Agent 上报时间 (服务端接收时间)
+}
+
+// 规则应用状态
+const (
+	RuleApplicationApplied = "applied"
+	RuleApplicationFailed  = "failed"
+)
+
+// AgentKey Agent 使用的 API Key，只保存哈希值，明文仅在签发时返回一次
+type AgentKey struct {
+	gorm.Model
+	ID         string     `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name       string     `gorm:"not null" json:"name"`             // 便于识别的名称
+	KeyHash    string     `gorm:"uniqueIndex;not null" json:"-"`    // SHA-256(明文 key)
+	KeyPrefix  string     `gorm:"not null" json:"key_prefix"`       // 明文前缀，用于在列表中辨认
+	DeviceID   string     `gorm:"index" json:"device_id,omitempty"` // 关联的设备 ID，可为空
+	CreatedBy  string     `json:"created_by"`                       // 签发者 Keycloak 用户 ID
+	ExpiresAt  *time.Time `json:"expires_at"`                       // 过期时间，为空表示永不过期
+	LastUsedAt *time.Time `json:"last_used_at"`                     // 最近一次通过认证的时间 (按分钟粒度更新)，为空表示从未使用
+}
+
+// AuditLog 审计日志，记录管理操作和安全相关事件
+type AuditLog struct {
+	gorm.Model
+	ID           string                 `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	ActorID      string                 `gorm:"index" json:"actor_id"`                     // 操作者 Keycloak 用户 ID
+	Action       string                 `gorm:"index;not null" json:"action"`              // 动作，例如 request.panic
+	ResourceType string                 `json:"resource_type"`                             // 资源类型
+	ResourceID   string                 `gorm:"index" json:"resource_id"`                  // 资源 ID
+	RequestID    string                 `json:"request_id"`                                // 关联的请求 ID
+	RemoteIP     string                 `json:"remote_ip"`                                 // 客户端 IP
+	Details      map[string]interface{} `gorm:"type:jsonb;serializer:json" json:"details"` // 附加信息
 }
 
 // KeycloakUser 用于前端显示 Keycloak 用户信息 (简化 DTO)
 type KeycloakUser struct {
-	ID                 string `json:"id"`
-	Username           string `json:"username"`
-	Email              string `json:"email"`
-	FirstName          string `json:"firstName"`
-	LastName           string `json:"lastName"`
-	Enabled            bool   `json:"enabled"`
-	EmailVerified      bool   `json:"emailVerified"`
-	FederatedIdentities []struct { // 联合身份，例如 Google
-		IdentityProvider string `json:"identityProvider"`
-		UserID           string `json:"userId"`
-		UserName         string `json:"userName"`
-	} `json:"federatedIdentities"`
+	ID                  string              `json:"id"`
+	Username            string              `json:"username"`
+	Email               string              `json:"email"`
+	FirstName           string              `json:"firstName"`
+	LastName            string              `json:"lastName"`
+	Enabled             bool                `json:"enabled"`
+	EmailVerified       bool                `json:"emailVerified"`
+	FederatedIdentities []FederatedIdentity `json:"federatedIdentities"` // 联合身份，例如 Google
+	Roles               []string            `json:"roles"`               // Realm 角色，仅在 GetUsers 指定 with_roles=true 时填充
+	CreatedTimestamp    *time.Time          `json:"createdTimestamp"`    // 账号创建时间 (Keycloak 返回毫秒时间戳，这里转换为 RFC3339)
+	LastLogin           *time.Time          `json:"lastLogin,omitempty"` // 最近一次登录时间，仅在 with_last_login=true 时查询；Realm 未开启登录事件时为空
 	// ... 其他您可能需要的 Keycloak 用户字段
 }
+
+// FederatedIdentity Keycloak 用户关联的外部身份提供者账号
+type FederatedIdentity struct {
+	IdentityProvider string `json:"identityProvider"`
+	UserID           string `json:"userId"`
+	UserName         string `json:"userName"`
+}
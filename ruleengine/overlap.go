@@ -0,0 +1,126 @@
+package ruleengine
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// patternKind 规则 Match 中主机部分的写法
+type patternKind int
+
+const (
+	kindDomain patternKind = iota
+	kindWildcard
+	kindIP
+	kindCIDR
+	kindInvalid // 无法解析的 CIDR，不匹配任何目标
+)
+
+// parsedPattern 解析后的 Match 条件
+type parsedPattern struct {
+	kind    patternKind
+	host    string // 域名 (通配域名不含 "*.")
+	ip      net.IP
+	network *net.IPNet
+	port    int // 0 表示任意端口
+}
+
+// parsePattern 解析规则的 Match 条件，MatchTarget 与 Overlap 共用
+func parsePattern(pattern string) parsedPattern {
+	host, port := splitPattern(pattern)
+	p := parsedPattern{host: host, port: port}
+	switch {
+	case strings.Contains(host, "/"):
+		if _, network, err := net.ParseCIDR(host); err == nil {
+			p.kind, p.network = kindCIDR, network
+		} else {
+			p.kind = kindInvalid
+		}
+	case net.ParseIP(host) != nil:
+		p.kind, p.ip = kindIP, net.ParseIP(host)
+	case strings.HasPrefix(host, "*."):
+		p.kind, p.host = kindWildcard, host[2:]
+	}
+	return p
+}
+
+// matches 判断目标是否命中该条件；只有条件和目标都带端口时才比较端口
+func (p parsedPattern) matches(target Target) bool {
+	if p.port != 0 && target.Port != 0 && p.port != target.Port {
+		return false
+	}
+	switch p.kind {
+	case kindCIDR:
+		return target.IP != nil && p.network.Contains(target.IP)
+	case kindIP:
+		return target.IP != nil && p.ip.Equal(target.IP)
+	case kindWildcard:
+		return target.IP == nil && strings.HasSuffix(target.Host, "."+p.host)
+	case kindDomain:
+		return p.host == target.Host
+	default:
+		return false
+	}
+}
+
+// Overlap 判断两个 Match 条件是否可能命中同一个目标，命中时返回原因说明
+// 与 MatchTarget 的语义一致: 不带端口的条件匹配任意端口；通配域名只匹配子域名；域名与 IP 之间不做解析，视为不重叠
+func Overlap(a, b string) (bool, string) {
+	pa, pb := parsePattern(a), parsePattern(b)
+	if pa.port != 0 && pb.port != 0 && pa.port != pb.port {
+		return false, ""
+	}
+	if pa.kind == pb.kind && pa.host == pb.host && pa.port == pb.port {
+		return true, "identical match"
+	}
+	// 统一顺序，减少下面需要处理的组合
+	if pa.kind > pb.kind {
+		pa, pb = pb, pa
+	}
+
+	var reason string
+	switch {
+	case pa.kind == kindDomain && pb.kind == kindDomain:
+		if pa.host == pb.host {
+			reason = "same host"
+		}
+	case pa.kind == kindDomain && pb.kind == kindWildcard:
+		if strings.HasSuffix(pa.host, "."+pb.host) {
+			reason = fmt.Sprintf("%s is covered by *.%s", pa.host, pb.host)
+		}
+	case pa.kind == kindWildcard && pb.kind == kindWildcard:
+		switch {
+		case pa.host == pb.host:
+			reason = "same wildcard domain"
+		case strings.HasSuffix(pa.host, "."+pb.host):
+			reason = fmt.Sprintf("*.%s is covered by *.%s", pa.host, pb.host)
+		case strings.HasSuffix(pb.host, "."+pa.host):
+			reason = fmt.Sprintf("*.%s is covered by *.%s", pb.host, pa.host)
+		}
+	case pa.kind == kindIP && pb.kind == kindIP:
+		if pa.ip.Equal(pb.ip) {
+			reason = "same IP address"
+		}
+	case pa.kind == kindIP && pb.kind == kindCIDR:
+		if pb.network.Contains(pa.ip) {
+			reason = fmt.Sprintf("%s is inside %s", pa.ip, pb.network)
+		}
+	case pa.kind == kindCIDR && pb.kind == kindCIDR:
+		if pa.network.Contains(pb.network.IP) || pb.network.Contains(pa.network.IP) {
+			reason = fmt.Sprintf("%s and %s overlap", pa.network, pb.network)
+		}
+	}
+	if reason == "" {
+		return false, ""
+	}
+	if pa.port != pb.port {
+		// 一方不限端口，另一方限定端口，仍在该端口上重叠
+		port := pa.port
+		if port == 0 {
+			port = pb.port
+		}
+		reason += fmt.Sprintf(" (on port %d)", port)
+	}
+	return true, reason
+}
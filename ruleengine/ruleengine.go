@@ -0,0 +1,107 @@
+// Package ruleengine 规则匹配引擎，与 Agent 判断请求命中哪条规则的逻辑保持一致
+// 匹配测试、冲突检测、流量模拟等功能都应调用这里，而不是各自实现匹配
+package ruleengine
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-agent-manager/models"
+	"go-agent-manager/schedule"
+)
+
+// DefaultAction 没有任何规则命中时 Agent 采取的动作
+const DefaultAction = models.RuleActionDirect
+
+// ErrEmptyInput 待匹配的输入为空
+var ErrEmptyInput = errors.New("input must not be empty")
+
+// Target 待匹配的目标，Port 为 0 表示未指定端口
+type Target struct {
+	Host string
+	IP   net.IP // Host 为 IP 地址时非空
+	Port int
+}
+
+// ParseTarget 解析 "host"、"host:port"、"1.2.3.4:80"、"[::1]:22" 形式的输入
+func ParseTarget(input string) Target {
+	input = strings.TrimSpace(input)
+	host, port := input, 0
+	if h, p, err := net.SplitHostPort(input); err == nil {
+		if n, err := strconv.Atoi(p); err == nil {
+			host, port = h, n
+		}
+	}
+	host = normalizeHost(host)
+	return Target{Host: host, IP: net.ParseIP(host), Port: port}
+}
+
+// Match 判断规则的 Match 条件是否命中输入
+// 支持的规则写法:
+//   - 精确域名: example.com
+//   - 通配域名: *.example.com (仅匹配子域名，不匹配 example.com 本身)
+//   - IP 地址: 10.0.0.1
+//   - CIDR: 10.0.0.0/8
+//   - 以上任一形式加端口: example.com:443, *.example.com:443, 10.0.0.1:22
+//
+// 只有规则和输入都带端口时才比较端口，因此 "example.com" 可以查到 "example.com:443" 规则
+func Match(rule models.Rule, input string) bool {
+	return MatchTarget(rule.Match, ParseTarget(input))
+}
+
+// MatchTarget 使用已解析的目标进行匹配，便于批量匹配时复用解析结果
+func MatchTarget(pattern string, target Target) bool {
+	return parsePattern(pattern).matches(target)
+}
+
+// Evaluate 按给定顺序返回第一条命中输入且当前处于生效时间窗口内的规则，没有命中时返回 nil
+// rules 应该已经按照 Agent 的评估顺序排好
+func Evaluate(rules []models.Rule, input string) (*models.Rule, error) {
+	return EvaluateAt(rules, input, time.Now())
+}
+
+// EvaluateAt 与 Evaluate 相同，但使用指定时间判断规则的生效时间窗口
+func EvaluateAt(rules []models.Rule, input string, at time.Time) (*models.Rule, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, ErrEmptyInput
+	}
+	target := ParseTarget(input)
+	for i := range rules {
+		if !ActiveAt(rules[i], at) {
+			continue
+		}
+		if MatchTarget(rules[i].Match, target) {
+			return &rules[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// ActiveAt 判断规则在指定时间是否处于生效时间窗口内
+// 未设置时间窗口的规则始终生效；时间窗口无法解析时视为不生效 (写入时已校验，正常不会发生)
+func ActiveAt(rule models.Rule, at time.Time) bool {
+	if rule.ActiveSchedule == "" {
+		return true
+	}
+	s, err := schedule.Parse(rule.ActiveSchedule)
+	return err == nil && s.ActiveAt(at)
+}
+
+// splitPattern 拆分规则中的主机部分与可选端口
+func splitPattern(pattern string) (string, int) {
+	pattern = strings.TrimSpace(pattern)
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		if n, err := strconv.Atoi(p); err == nil {
+			return normalizeHost(h), n
+		}
+	}
+	return normalizeHost(pattern), 0
+}
+
+func normalizeHost(host string) string {
+	host = strings.TrimPrefix(strings.TrimSuffix(host, "]"), "[")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
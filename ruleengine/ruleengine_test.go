@@ -0,0 +1,170 @@
+package ruleengine
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go-agent-manager/models"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		input   string
+		want    bool
+	}{
+		// 精确域名
+		{"exact domain", "example.com", "example.com", true},
+		{"exact domain is case-insensitive", "Example.COM", "example.com.", true},
+		{"exact domain does not match subdomain", "example.com", "ads.example.com", false},
+		{"exact domain does not match other domain", "example.com", "example.org", false},
+
+		// 通配域名
+		{"wildcard matches subdomain", "*.example.com", "ads.example.com", true},
+		{"wildcard matches nested subdomain", "*.example.com", "a.b.example.com", true},
+		{"wildcard does not match apex", "*.example.com", "example.com", false},
+		{"wildcard does not match suffix lookalike", "*.example.com", "badexample.com", false},
+		{"wildcard does not match IP", "*.example.com", "10.0.0.1", false},
+
+		// host:port
+		{"port must match when both given", "example.com:443", "example.com:443", true},
+		{"different port does not match", "example.com:443", "example.com:80", false},
+		{"input without port matches ported rule", "example.com:443", "example.com", true},
+		{"rule without port matches any port", "example.com", "example.com:8443", true},
+		{"wildcard with port", "*.example.com:443", "api.example.com:443", true},
+		{"wildcard with other port", "*.example.com:443", "api.example.com:80", false},
+		{"IPv6 with port", "[::1]:22", "[::1]:22", true},
+
+		// IP 与 CIDR
+		{"exact IP", "10.0.0.1", "10.0.0.1:22", true},
+		{"other IP", "10.0.0.1", "10.0.0.2", false},
+		{"CIDR contains IP", "10.0.0.0/8", "10.1.2.3", true},
+		{"CIDR contains IP with port", "10.0.0.0/8", "10.1.2.3:5432", true},
+		{"CIDR does not contain IP", "10.0.0.0/8", "11.0.0.1", false},
+		{"CIDR with port", "10.0.0.0/8:5432", "10.1.2.3:5432", true},
+		{"CIDR with other port", "10.0.0.0/8:5432", "10.1.2.3:22", false},
+		{"CIDR does not match hostname", "10.0.0.0/8", "db.internal", false},
+		{"IPv6 CIDR", "fd00::/8", "fd12::1", true},
+		{"invalid CIDR matches nothing", "10.0.0.0/33", "10.0.0.0/33", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Match(models.Rule{Match: tt.pattern}, tt.input); got != tt.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	// 已按 ruleset.RuleOrder (priority ASC) 排好序
+	rules := []models.Rule{
+		{ID: "block-ads", Match: "ads.example.com", Action: models.RuleActionBlock, Priority: 10},
+		{ID: "proxy-example", Match: "*.example.com", Action: models.RuleActionProxy, Priority: 20},
+		{ID: "direct-lan", Match: "10.0.0.0/8", Action: models.RuleActionDirect, Priority: 30},
+		{ID: "proxy-db", Match: "10.0.0.5:5432", Action: models.RuleActionProxy, Priority: 40},
+	}
+
+	tests := []struct {
+		input  string
+		wantID string // 为空表示没有规则命中
+	}{
+		{"ads.example.com", "block-ads"}, // 同时命中通配规则，优先级高的胜出
+		{"cdn.example.com", "proxy-example"},
+		{"10.0.0.5:5432", "direct-lan"}, // CIDR 规则优先级更高
+		{"192.168.1.1", ""},
+		{"example.com", ""},
+	}
+	for _, tt := range tests {
+		rule, err := Evaluate(rules, tt.input)
+		if err != nil {
+			t.Fatalf("Evaluate(%q) error: %v", tt.input, err)
+		}
+		gotID := ""
+		if rule != nil {
+			gotID = rule.ID
+		}
+		if gotID != tt.wantID {
+			t.Errorf("Evaluate(%q) = %q, want %q", tt.input, gotID, tt.wantID)
+		}
+	}
+
+	if _, err := Evaluate(rules, "  "); !errors.Is(err, ErrEmptyInput) {
+		t.Errorf("Evaluate(blank) error = %v, want ErrEmptyInput", err)
+	}
+}
+
+func TestEvaluateAtSkipsInactiveSchedules(t *testing.T) {
+	rules := []models.Rule{
+		{ID: "office-hours", Match: "*.example.com", Action: models.RuleActionBlock, ActiveSchedule: "Mon-Fri 09:00-18:00"},
+		{ID: "always", Match: "*.example.com", Action: models.RuleActionProxy},
+	}
+	monday10 := time.Date(2024, time.January, 1, 10, 0, 0, 0, time.Local)
+	sunday10 := time.Date(2024, time.January, 7, 10, 0, 0, 0, time.Local)
+
+	for at, wantID := range map[time.Time]string{monday10: "office-hours", sunday10: "always"} {
+		rule, err := EvaluateAt(rules, "a.example.com", at)
+		if err != nil || rule == nil || rule.ID != wantID {
+			t.Errorf("EvaluateAt(%s) = %v, %v; want %q", at.Weekday(), rule, err, wantID)
+		}
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "Example.com.", true},
+		{"a.example.com", "*.example.com", true},
+		{"*.example.com", "a.example.com", true},
+		{"example.com", "*.example.com", false},
+		{"*.a.example.com", "*.example.com", true},
+		{"*.example.com", "*.example.org", false},
+		{"example.com:443", "example.com", true},
+		{"example.com:443", "example.com:80", false},
+		{"10.0.0.1", "10.0.0.0/8", true},
+		{"10.0.0.0/16", "10.0.0.0/8", true},
+		{"10.0.0.0/16", "11.0.0.0/16", false},
+		{"10.0.0.1", "example.com", false},
+	}
+	for _, tt := range tests {
+		got, reason := Overlap(tt.a, tt.b)
+		if got != tt.want {
+			t.Errorf("Overlap(%q, %q) = %v (%q), want %v", tt.a, tt.b, got, reason, tt.want)
+		}
+		if got && reason == "" {
+			t.Errorf("Overlap(%q, %q) returned no reason", tt.a, tt.b)
+		}
+	}
+}
+
+// TestOverlapConsistentWithMatch 重叠检测与匹配共用同一个模式解析: 只要存在同时匹配两条规则的连接目标，Overlap 必须报告重叠
+// 目标都带端口: 实际连接总有端口，而不带端口的输入会匹配任意端口的规则，端口不同的规则并不会同时生效
+func TestOverlapConsistentWithMatch(t *testing.T) {
+	patterns := []string{
+		"example.com", "Example.com.", "example.com:443", "*.example.com", "*.a.example.com", "*.example.com:443",
+		"a.example.com", "example.org", "10.0.0.1", "10.0.0.1:22", "10.0.0.0/8", "10.0.0.0/16", "10.0.0.0/8:5432",
+		"11.0.0.0/16", "fd00::/8", "[fd12::1]:22",
+	}
+	targets := []string{
+		"example.com:443", "example.com:80", "a.example.com:443", "b.a.example.com:8080", "example.org:443",
+		"10.0.0.1:22", "10.0.0.1:5432", "10.1.2.3:443", "11.0.0.1:80", "[fd12::1]:22", "[fd12::1]:443",
+	}
+	for _, a := range patterns {
+		for _, b := range patterns {
+			for _, target := range targets {
+				if !Match(models.Rule{Match: a}, target) || !Match(models.Rule{Match: b}, target) {
+					continue
+				}
+				if overlap, _ := Overlap(a, b); !overlap {
+					t.Errorf("%q and %q both match %q but Overlap reports no overlap", a, b, target)
+				}
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package ruleengine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go-agent-manager/models"
+)
+
+// hostnameLabel 单个域名标签: 字母数字开头结尾，中间可包含连字符，最长 63 个字符
+var hostnameLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidatePattern 按规则类型校验 Match 写法，返回的错误说明期望的格式
+//   - http-proxy: 域名或通配域名，可带端口，如 example.com、*.example.com:443
+//   - tcp-proxy: 带端口的主机 (域名、通配域名或 IP)，或 CIDR (端口可选)，如 db.internal:5432、10.0.0.0/8
+func ValidatePattern(ruleType, pattern string) error {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return errors.New("match is required")
+	}
+	host, hasPort, err := splitPatternStrict(pattern)
+	if err != nil {
+		return err
+	}
+
+	switch ruleType {
+	case models.RuleTypeHTTPProxy:
+		if !isDomainPattern(host) {
+			return errors.New("expected a hostname or wildcard domain such as example.com or *.example.com (optionally with :port)")
+		}
+	case models.RuleTypeTCPProxy:
+		if strings.Contains(host, "/") {
+			if _, _, err := net.ParseCIDR(host); err != nil {
+				return fmt.Errorf("expected a CIDR such as 10.0.0.0/8: %v", err)
+			}
+			return nil
+		}
+		if !hasPort {
+			return errors.New("expected host:port (e.g. db.internal:5432 or 10.0.0.1:22) or a CIDR such as 10.0.0.0/8")
+		}
+		if net.ParseIP(host) == nil && !isDomainPattern(host) {
+			return errors.New("expected host:port with a hostname, wildcard domain or IP address as host")
+		}
+	default:
+		return fmt.Errorf("unknown rule type %q", ruleType)
+	}
+	return nil
+}
+
+// splitPatternStrict 与 splitPattern 相同，但端口不合法时返回错误而不是把整个字符串当作主机
+func splitPatternStrict(pattern string) (host string, hasPort bool, err error) {
+	h, p, splitErr := net.SplitHostPort(pattern)
+	if splitErr != nil {
+		// 没有端口 (或是不带方括号的 IPv6 地址)
+		return normalizeHost(pattern), false, nil
+	}
+	if n, convErr := strconv.Atoi(p); convErr != nil || n < 1 || n > 65535 {
+		return "", false, fmt.Errorf("port %q must be a number between 1 and 65535", p)
+	}
+	return normalizeHost(h), true, nil
+}
+
+// isDomainPattern 判断是否为合法域名或通配域名 (*.example.com)
+func isDomainPattern(host string) bool {
+	host = strings.TrimPrefix(host, "*.")
+	if host == "" || len(host) > 253 || net.ParseIP(host) != nil {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package ruleset
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-agent-manager/logger"
+)
+
+// 进程内的规则变更通知: 订阅者按能力集合分组，规则变更后每组只重新计算一次 ETag，
+// ETag 变化时向组内所有订阅者推送新值。多实例部署时每个实例只通知连接到自己的 Agent。
+
+// subscriberGroup 能力集合相同的一组订阅者
+type subscriberGroup struct {
+	capabilities []string // nil 表示不过滤 (完整规则集)
+	etag         string   // 最近一次推送的 ETag
+	subs         map[chan string]struct{}
+}
+
+var (
+	hubMu  sync.Mutex
+	groups = make(map[string]*subscriberGroup)
+
+	// notifyMu 串行化重新计算，避免并发的变更通知以错误的顺序推送 ETag
+	notifyMu sync.Mutex
+)
+
+// capabilityKey 能力集合的分组键，与顺序无关；nil 与空集合区分开
+func capabilityKey(capabilities []string) string {
+	if capabilities == nil {
+		return "*"
+	}
+	sorted := append([]string(nil), capabilities...)
+	sort.Strings(sorted)
+	return "caps:" + strings.Join(sorted, ",")
+}
+
+// Subscribe 订阅指定能力集合的规则集变更，etag 为订阅者当前持有的版本
+// 返回的通道在规则集 ETag 变化时收到新 ETag (只保留最新一个)；调用方必须执行 unsubscribe
+func Subscribe(capabilities []string, etag string) (updates <-chan string, unsubscribe func()) {
+	ch := make(chan string, 1)
+	key := capabilityKey(capabilities)
+
+	hubMu.Lock()
+	g, ok := groups[key]
+	if !ok {
+		g = &subscriberGroup{capabilities: capabilities, etag: etag, subs: make(map[chan string]struct{})}
+		groups[key] = g
+	}
+	g.subs[ch] = struct{}{}
+	hubMu.Unlock()
+
+	return ch, func() {
+		hubMu.Lock()
+		defer hubMu.Unlock()
+		delete(g.subs, ch)
+		if len(g.subs) == 0 && groups[key] == g {
+			delete(groups, key)
+		}
+	}
+}
+
+// NotifyChanged 在规则被创建、修改、删除或导入后调用
+// 异步为每个订阅组重新计算规则集，ETag 变化时推送给组内订阅者；并为本进程已知的所有能力分组保存新快照
+func NotifyChanged() {
+	go func() {
+		notifyMu.Lock()
+		defer notifyMu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		hubMu.Lock()
+		pending := make([]*subscriberGroup, 0, len(groups))
+		for _, g := range groups {
+			pending = append(pending, g)
+		}
+		hubMu.Unlock()
+
+		covered := make(map[string]bool, len(pending))
+		for _, g := range pending {
+			covered[capabilityKey(g.capabilities)] = true
+			snap, err := CurrentFor(ctx, g.capabilities)
+			if err != nil {
+				logger.Log.Error("failed to rebuild rule set for subscribers", "error", err)
+				continue
+			}
+			hubMu.Lock()
+			if snap.ETag != g.etag {
+				g.etag = snap.ETag
+				for ch := range g.subs {
+					// 丢弃尚未被读取的旧 ETag，只保留最新值
+					select {
+					case <-ch:
+					default:
+					}
+					ch <- snap.ETag
+				}
+			}
+			hubMu.Unlock()
+		}
+
+		// 只轮询、没有订阅者的能力分组同样保存新版本快照，之后的增量差异才能基于它计算
+		for key, capabilities := range storedCapabilities() {
+			if covered[key] {
+				continue
+			}
+			if _, err := CurrentFor(ctx, capabilities); err != nil {
+				logger.Log.Error("failed to store rule set snapshot", "capability_key", key, "error", err)
+			}
+		}
+	}()
+}
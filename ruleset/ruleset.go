@@ -0,0 +1,241 @@
+package ruleset
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrSnapshotNotFound 指定 ETag 的快照不存在 (从未存在或已被清理)
+var ErrSnapshotNotFound = errors.New("rule set snapshot not found")
+
+// Snapshot 某一时刻下发给 Agent 的规则集
+type Snapshot struct {
+	ETag  string
+	Rules []models.AgentRule
+}
+
+// Change 同一规则在两个版本之间的变化
+type Change struct {
+	Before models.AgentRule `json:"before"`
+	After  models.AgentRule `json:"after"`
+}
+
+// Diff 两个规则集版本之间的差异
+type Diff struct {
+	Added   []models.AgentRule `json:"added"`
+	Removed []models.AgentRule `json:"removed"`
+	Changed []Change           `json:"changed"`
+}
+
+// RuleOrder 规则的评估顺序: 优先级数值小的先匹配，相同优先级按创建时间，最后以 ID 保证顺序稳定
+const RuleOrder = "priority ASC, created_at ASC, id ASC"
+
+// Rules 返回 Agent 需要执行的规则 (仅启用的规则)，按评估顺序排列
+func Rules(ctx context.Context) ([]models.Rule, error) {
+	var rules []models.Rule
+	err := db.DB.WithContext(ctx).Where("enabled = ?", true).Order(RuleOrder).Find(&rules).Error
+	return rules, err
+}
+
+// Current 构建当前完整规则集并保存快照，供之后的增量差异计算使用
+func Current(ctx context.Context) (*Snapshot, error) {
+	return CurrentFor(ctx, nil)
+}
+
+// CurrentFor 构建具备指定能力的 Agent 可执行的规则集，规则集与该能力分组上次保存的快照不同时保存新快照
+// capabilities 为 nil 时不过滤；不同能力组合得到的规则集有各自的 ETag，增量差异同样适用
+func CurrentFor(ctx context.Context, capabilities []string) (*Snapshot, error) {
+	rules, err := Rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make([]models.AgentRule, 0, len(rules))
+	for _, r := range rules {
+		if capabilities != nil && !Supports(r, capabilities) {
+			continue
+		}
+		exported = append(exported, ToAgentRule(r))
+	}
+	etag, err := computeETag(exported)
+	if err != nil {
+		return nil, err
+	}
+	// 只读模式下不写快照，增量差异只能基于主实例已写入的快照
+	if !config.AppConfig.ReadOnlyMode {
+		if err := storeIfChanged(ctx, capabilities, etag, exported); err != nil {
+			return nil, err
+		}
+	}
+	return &Snapshot{ETag: etag, Rules: exported}, nil
+}
+
+// Supports 判断具备 capabilities 的 Agent 是否能执行该规则 (规则所需能力均已具备)
+func Supports(r models.Rule, capabilities []string) bool {
+	for _, required := range r.RequiredCapabilities {
+		found := false
+		for _, c := range capabilities {
+			if c == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ToAgentRule 将规则模型转换为下发给 Agent 的精简格式
+func ToAgentRule(r models.Rule) models.AgentRule {
+	rule := models.AgentRule{
+		ID:             r.ID,
+		Name:           r.Name,
+		Type:           r.Type,
+		Match:          r.Match,
+		Action:         r.Action,
+		Priority:       r.Priority,
+		ActiveSchedule: r.ActiveSchedule,
+	}
+	// 空列表统一为 nil，保证与快照 (JSON 中省略该字段) 比较时一致
+	if len(r.RequiredCapabilities) > 0 {
+		rule.RequiredCapabilities = r.RequiredCapabilities
+	}
+	return rule
+}
+
+// Load 读取指定 ETag 的历史快照
+func Load(ctx context.Context, etag string) (*Snapshot, error) {
+	var snap models.RuleSnapshot
+	// 快照可能刚刚写入，从主库读取避免副本延迟
+	err := db.Primary().WithContext(ctx).First(&snap, "etag = ?", NormalizeETag(etag)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{ETag: snap.ETag, Rules: snap.Rules}, nil
+}
+
+// Compare 计算从 from 到 to 的差异，按规则 ID 对齐
+func Compare(from, to []models.AgentRule) Diff {
+	diff := Diff{
+		Added:   []models.AgentRule{},
+		Removed: []models.AgentRule{},
+		Changed: []Change{},
+	}
+	before := make(map[string]models.AgentRule, len(from))
+	for _, r := range from {
+		before[r.ID] = r
+	}
+	for _, r := range to {
+		old, ok := before[r.ID]
+		if !ok {
+			diff.Added = append(diff.Added, r)
+			continue
+		}
+		if !reflect.DeepEqual(old, r) {
+			diff.Changed = append(diff.Changed, Change{Before: old, After: r})
+		}
+		delete(before, r.ID)
+	}
+	for _, r := range from {
+		if _, removed := before[r.ID]; removed {
+			diff.Removed = append(diff.Removed, r)
+		}
+	}
+	return diff
+}
+
+// NormalizeETag 去掉 ETag 的引号和弱校验前缀，便于比较
+func NormalizeETag(etag string) string {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	return strings.Trim(etag, `"`)
+}
+
+func computeETag(rules []models.AgentRule) (string, error) {
+	payload, err := json.Marshal(rules)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// storedGroup 某个能力分组最近一次保存快照时的能力集合和 ETag
+type storedGroup struct {
+	capabilities []string
+	etag         string
+}
+
+var (
+	storedMu sync.Mutex
+	// stored 本进程已为各能力分组保存过的最新 ETag (键为 capabilityKey)
+	// Agent 轮询时规则集未变化则不再写库；规则变更后由 NotifyChanged 为这些分组保存新版本
+	stored = make(map[string]storedGroup)
+)
+
+// storeIfChanged 规则集与该能力分组上次保存的 ETag 不同时保存快照 (进程启动后首次构建或规则已变更)
+func storeIfChanged(ctx context.Context, capabilities []string, etag string, rules []models.AgentRule) error {
+	key := capabilityKey(capabilities)
+	storedMu.Lock()
+	last, ok := stored[key]
+	storedMu.Unlock()
+	if ok && last.etag == etag {
+		return nil
+	}
+	if err := store(ctx, key, etag, rules); err != nil {
+		return err
+	}
+	storedMu.Lock()
+	stored[key] = storedGroup{capabilities: capabilities, etag: etag}
+	storedMu.Unlock()
+	return nil
+}
+
+// storedCapabilities 返回本进程保存过快照的所有能力分组
+func storedCapabilities() map[string][]string {
+	storedMu.Lock()
+	defer storedMu.Unlock()
+	groups := make(map[string][]string, len(stored))
+	for key, g := range stored {
+		groups[key] = g.capabilities
+	}
+	return groups
+}
+
+// store 保存能力分组的快照 (已存在则忽略)，新增快照时清理该分组超出保留数量的旧快照
+// 保留数量按分组计算，能力组合较多时也不会互相挤掉仍在使用的版本
+func store(ctx context.Context, key, etag string, rules []models.AgentRule) error {
+	snap := models.RuleSnapshot{ETag: etag, CapabilityKey: key, Rules: rules}
+	result := db.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "etag"}, {Name: "capability_key"}}, DoNothing: true}).
+		Create(&snap)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	keep := config.AppConfig.RuleSnapshotRetention
+	if keep <= 0 {
+		return nil
+	}
+	recent := db.DB.Model(&models.RuleSnapshot{}).Select("id").Where("capability_key = ?", key).Order("created_at DESC").Limit(keep)
+	return db.DB.WithContext(ctx).Unscoped().
+		Where("capability_key = ? AND id NOT IN (?)", key, recent).
+		Delete(&models.RuleSnapshot{}).Error
+}
@@ -0,0 +1,138 @@
+package ruleset
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go-agent-manager/config"
+	"go-agent-manager/db"
+	"go-agent-manager/db/dbtest"
+	"go-agent-manager/models"
+)
+
+func TestCapabilityKey(t *testing.T) {
+	if a, b := capabilityKey([]string{"tcp-proxy", "cidr-match"}), capabilityKey([]string{"cidr-match", "tcp-proxy"}); a != b {
+		t.Errorf("capabilityKey depends on order: %q != %q", a, b)
+	}
+	if capabilityKey(nil) == capabilityKey([]string{}) {
+		t.Error("nil (unfiltered) and empty capability sets share a key")
+	}
+}
+
+// openSnapshotDB 连接测试数据库并清空本进程记录的已保存 ETag
+func openSnapshotDB(t *testing.T) {
+	t.Helper()
+	dbtest.Open(t, "ruleset_test")
+	config.AppConfig.ReadOnlyMode = false
+	storedMu.Lock()
+	stored = make(map[string]storedGroup)
+	storedMu.Unlock()
+}
+
+func createRule(t *testing.T, name string, required ...string) {
+	t.Helper()
+	rule := models.Rule{Name: name, Type: models.RuleTypeHTTPProxy, Match: name + ".example.com",
+		Action: models.RuleActionProxy, RequiredCapabilities: required}
+	if err := db.DB.Create(&rule).Error; err != nil {
+		t.Fatalf("create rule %s: %v", name, err)
+	}
+}
+
+func countSnapshots(t *testing.T, capabilities []string) int64 {
+	t.Helper()
+	var n int64
+	if err := db.DB.Model(&models.RuleSnapshot{}).Where("capability_key = ?", capabilityKey(capabilities)).Count(&n).Error; err != nil {
+		t.Fatalf("count snapshots: %v", err)
+	}
+	return n
+}
+
+func TestCurrentForStoresSnapshotOnlyWhenRulesChange(t *testing.T) {
+	openSnapshotDB(t)
+	config.AppConfig.RuleSnapshotRetention = 50
+	ctx := context.Background()
+	createRule(t, "first")
+
+	first, err := CurrentFor(ctx, nil)
+	if err != nil {
+		t.Fatalf("CurrentFor: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		snap, err := CurrentFor(ctx, nil)
+		if err != nil {
+			t.Fatalf("CurrentFor: %v", err)
+		}
+		if snap.ETag != first.ETag {
+			t.Fatalf("ETag changed without a rule change: %s -> %s", first.ETag, snap.ETag)
+		}
+	}
+	if n := countSnapshots(t, nil); n != 1 {
+		t.Errorf("%d snapshots after repeated polls, want 1", n)
+	}
+
+	createRule(t, "second")
+	second, err := CurrentFor(ctx, nil)
+	if err != nil {
+		t.Fatalf("CurrentFor: %v", err)
+	}
+	if n := countSnapshots(t, nil); n != 2 {
+		t.Errorf("%d snapshots after a rule change, want 2", n)
+	}
+	if _, err := Load(ctx, first.ETag); err != nil {
+		t.Errorf("Load previous version: %v", err)
+	}
+	if _, err := Load(ctx, second.ETag); err != nil {
+		t.Errorf("Load current version: %v", err)
+	}
+}
+
+func TestSnapshotRetentionPerCapabilityKey(t *testing.T) {
+	openSnapshotDB(t)
+	config.AppConfig.RuleSnapshotRetention = 2
+	ctx := context.Background()
+	basic := []string{} // 不具备任何能力，只能看到无依赖的规则
+
+	createRule(t, "base")
+	basicSnap, err := CurrentFor(ctx, basic)
+	if err != nil {
+		t.Fatalf("CurrentFor(basic): %v", err)
+	}
+	// 只对完整规则集可见的规则不断变更，完整规则集的快照数量受保留数量限制
+	for i := 0; i < 4; i++ {
+		createRule(t, fmt.Sprintf("cidr-%d", i), models.CapabilityCIDRMatch)
+		if _, err := CurrentFor(ctx, nil); err != nil {
+			t.Fatalf("CurrentFor(nil): %v", err)
+		}
+	}
+
+	if n := countSnapshots(t, nil); n != 2 {
+		t.Errorf("%d full rule set snapshots, want retention limit 2", n)
+	}
+	if _, err := Load(ctx, basicSnap.ETag); err != nil {
+		t.Errorf("snapshot of another capability set was trimmed: %v", err)
+	}
+}
+
+func TestNotifyChangedStoresSnapshotsForPolledCapabilitySets(t *testing.T) {
+	openSnapshotDB(t)
+	config.AppConfig.RuleSnapshotRetention = 50
+	ctx := context.Background()
+	polled := []string{models.CapabilityTCPProxy}
+
+	createRule(t, "first")
+	if _, err := CurrentFor(ctx, polled); err != nil {
+		t.Fatalf("CurrentFor: %v", err)
+	}
+	createRule(t, "second")
+	NotifyChanged()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for countSnapshots(t, polled) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("NotifyChanged did not store a snapshot for a capability set without subscribers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,151 @@
+// Package schedule 解析和计算规则的每周生效时间窗口 (Rule.ActiveSchedule)
+// 规则校验和规则引擎 (ruleengine.EvaluateAt) 共用这里的实现，语义与 Agent 按窗口启用规则的行为保持一致
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule 每周重复的生效时间窗口集合
+//
+// 格式: 多个窗口用 ";" 分隔，每个窗口为 "<星期> <开始>-<结束>"
+//   - 星期: Mon..Sun，支持范围 "Mon-Fri"、列表 "Sat,Sun" 以及 "*" (每天)
+//   - 时间: 24 小时制 HH:MM，结束时间可以是 24:00
+//   - 结束早于开始表示跨越午夜，例如 "Fri 22:00-06:00" 覆盖周五晚到周六早
+//
+// 示例: "Mon-Fri 09:00-18:00; Sat 10:00-14:00"
+// 时间按服务器本地时区计算
+type Schedule struct {
+	windows []window
+}
+
+type window struct {
+	days  [7]bool // 以 time.Weekday 为下标
+	start int     // 自 00:00 起的分钟数
+	end   int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Parse 解析时间窗口表达式
+func Parse(spec string) (*Schedule, error) {
+	s := &Schedule{}
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		w, err := parseWindow(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule window %q: %w", part, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+	if len(s.windows) == 0 {
+		return nil, fmt.Errorf("schedule must contain at least one window")
+	}
+	return s, nil
+}
+
+// ActiveAt 判断给定时间是否落在任一窗口内
+func (s *Schedule) ActiveAt(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	for _, w := range s.windows {
+		if w.start < w.end {
+			if w.days[today] && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		// 跨午夜窗口: 开始日的晚间部分 + 次日的凌晨部分
+		if (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseWindow(part string) (window, error) {
+	var w window
+	fields := strings.Fields(part)
+	if len(fields) != 2 {
+		return w, fmt.Errorf("expected \"<days> <HH:MM>-<HH:MM>\"")
+	}
+
+	if err := parseDays(fields[0], &w.days); err != nil {
+		return w, err
+	}
+
+	times := strings.Split(fields[1], "-")
+	if len(times) != 2 {
+		return w, fmt.Errorf("time range must be HH:MM-HH:MM")
+	}
+	var err error
+	if w.start, err = parseClock(times[0]); err != nil {
+		return w, err
+	}
+	if w.start == 24*60 {
+		return w, fmt.Errorf("start time cannot be 24:00")
+	}
+	if w.end, err = parseClock(times[1]); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("start and end time must differ")
+	}
+	return w, nil
+}
+
+func parseDays(spec string, days *[7]bool) error {
+	if spec == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		bounds := strings.Split(item, "-")
+		if len(bounds) > 2 {
+			return fmt.Errorf("invalid day range %q", item)
+		}
+		from, ok := weekdays[strings.ToLower(bounds[0])]
+		if !ok {
+			return fmt.Errorf("unknown day %q", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[strings.ToLower(bounds[1])]; !ok {
+				return fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+		// 范围可以跨周末，例如 Fri-Mon
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseClock(v string) (int, error) {
+	hm := strings.Split(v, ":")
+	if len(hm) != 2 || len(hm[0]) != 2 || len(hm[1]) != 2 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", v)
+	}
+	h, errH := strconv.Atoi(hm[0])
+	m, errM := strconv.Atoi(hm[1])
+	if errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", v)
+	}
+	return h*60 + m, nil
+}
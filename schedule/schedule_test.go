@@ -0,0 +1,91 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// at 返回 2024-01-01 (周一) 所在周中指定星期和时刻的时间
+func at(day time.Weekday, hour, minute, second int) time.Time {
+	offset := (int(day) + 6) % 7 // 周一为 0
+	return time.Date(2024, 1, 1+offset, hour, minute, second, 0, time.UTC)
+}
+
+func TestActiveAt(t *testing.T) {
+	tests := []struct {
+		spec string
+		at   time.Time
+		want bool
+	}{
+		// 普通窗口: 包含开始，不包含结束
+		{"Mon-Fri 09:00-18:00", at(time.Monday, 9, 0, 0), true},
+		{"Mon-Fri 09:00-18:00", at(time.Monday, 8, 59, 59), false},
+		{"Mon-Fri 09:00-18:00", at(time.Friday, 17, 59, 59), true},
+		{"Mon-Fri 09:00-18:00", at(time.Friday, 18, 0, 0), false},
+		{"Mon-Fri 09:00-18:00", at(time.Saturday, 12, 0, 0), false},
+
+		// 结束于 24:00 时覆盖当天最后一分钟，但不延伸到次日
+		{"Mon 20:00-24:00", at(time.Monday, 23, 59, 59), true},
+		{"Mon 20:00-24:00", at(time.Tuesday, 0, 0, 0), false},
+
+		// 跨午夜窗口: 开始日晚间 + 次日凌晨
+		{"Fri 22:00-06:00", at(time.Friday, 21, 59, 0), false},
+		{"Fri 22:00-06:00", at(time.Friday, 22, 0, 0), true},
+		{"Fri 22:00-06:00", at(time.Friday, 23, 59, 59), true},
+		{"Fri 22:00-06:00", at(time.Saturday, 0, 0, 0), true},
+		{"Fri 22:00-06:00", at(time.Saturday, 5, 59, 59), true},
+		{"Fri 22:00-06:00", at(time.Saturday, 6, 0, 0), false},
+		{"Fri 22:00-06:00", at(time.Saturday, 22, 0, 0), false},
+		{"Fri 22:00-06:00", at(time.Friday, 3, 0, 0), false}, // 周四未启用，周五凌晨不在窗口内
+
+		// 跨午夜且结束于 00:00: 只覆盖开始日晚间
+		{"Fri 22:00-00:00", at(time.Friday, 23, 59, 0), true},
+		{"Fri 22:00-00:00", at(time.Saturday, 0, 0, 0), false},
+
+		// 跨周末的跨午夜窗口: 周日晚延续到周一凌晨
+		{"Sun 23:00-01:00", at(time.Monday, 0, 30, 0), true},
+		{"Sun 23:00-01:00", at(time.Sunday, 0, 30, 0), false},
+
+		// 星期范围跨周末、列表和 "*"
+		{"Fri-Mon 10:00-11:00", at(time.Sunday, 10, 30, 0), true},
+		{"Fri-Mon 10:00-11:00", at(time.Wednesday, 10, 30, 0), false},
+		{"Sat,Sun 10:00-11:00", at(time.Saturday, 10, 0, 0), true},
+		{"* 00:00-24:00", at(time.Wednesday, 0, 0, 0), true},
+
+		// 多个窗口任一命中即可
+		{"Mon-Fri 09:00-12:00; Mon-Fri 13:00-18:00", at(time.Tuesday, 12, 30, 0), false},
+		{"Mon-Fri 09:00-12:00; Mon-Fri 13:00-18:00", at(time.Tuesday, 13, 0, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec+"@"+tt.at.Format("Mon15:04:05"), func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.spec, err)
+			}
+			if got := s.ActiveAt(tt.at); got != tt.want {
+				t.Errorf("ActiveAt(%s) = %v, want %v", tt.at.Format(time.RFC1123), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		" ; ",
+		"Mon",
+		"Mon 09:00",
+		"Mon 09:00-09:00",
+		"Mon 24:00-06:00",
+		"Mon 09:00-24:01",
+		"Mon 9:00-18:00",
+		"Mon 09:60-18:00",
+		"Funday 09:00-18:00",
+		"Mon-Tue-Wed 09:00-18:00",
+		"Mon 09:00-12:00-18:00",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", spec)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package seed 向数据库写入示例设备、规则和绑定，用于本地开发和冒烟测试
+// 通过 "go-agent-manager -seed" 运行；已存在的记录 (包括已软删除的) 会被跳过，可以重复执行
+package seed
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go-agent-manager/models"
+	"go-agent-manager/ruleengine"
+
+	"gorm.io/gorm"
+)
+
+// Options 种子数据选项
+type Options struct {
+	BindingUserID string // 非空时为第一台示例设备创建该 Keycloak 用户的 active 绑定
+}
+
+// Result 本次写入和跳过的记录数
+type Result struct {
+	Created int
+	Skipped int
+}
+
+// sampleDevices 示例设备，按 UniqueHardwareID 判断是否已存在
+var sampleDevices = []models.Device{
+	{UniqueHardwareID: "seed-device-001", Hostname: "dev-laptop-01", OS: "windows", Capabilities: []string{models.CapabilityWildcardMatch, models.CapabilitySchedule, models.CapabilityTCPProxy}, Tags: map[string]string{"env": "dev", "seed": "true"}},
+	{UniqueHardwareID: "seed-device-002", Hostname: "dev-laptop-02", OS: "macos", Capabilities: []string{models.CapabilityWildcardMatch}, Tags: map[string]string{"env": "dev", "seed": "true"}},
+	{UniqueHardwareID: "seed-device-003", Hostname: "dev-server-01", OS: "linux", Capabilities: []string{models.CapabilityCIDRMatch, models.CapabilityWildcardMatch, models.CapabilityPortMatch, models.CapabilitySchedule, models.CapabilityTCPProxy}, Tags: map[string]string{"env": "staging", "seed": "true"}},
+}
+
+// sampleRules 示例规则，按 Name (不区分大小写) 判断是否已存在
+var sampleRules = []models.Rule{
+	{Name: "seed-proxy-example", Type: models.RuleTypeHTTPProxy, Match: "*.example.com", Action: models.RuleActionProxy, Priority: 10, Description: "Demo rule created by -seed"},
+	{Name: "seed-block-ads", Type: models.RuleTypeHTTPProxy, Match: "ads.example.net", Action: models.RuleActionBlock, Priority: 20, Description: "Demo rule created by -seed"},
+}
+
+// Run 在一个事务中写入种子数据
+func Run(ctx context.Context, db *gorm.DB, opts Options) (Result, error) {
+	var res Result
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var firstDeviceID string
+		for _, d := range sampleDevices {
+			device := d
+			device.LastSeenAt = now
+			created, err := createIfMissing(tx, &device, &models.Device{}, "unique_hardware_id = ?", device.UniqueHardwareID)
+			if err != nil {
+				return fmt.Errorf("seed device %s: %w", device.UniqueHardwareID, err)
+			}
+			res.count(created)
+			if firstDeviceID == "" {
+				firstDeviceID = device.ID
+			}
+		}
+
+		for _, r := range sampleRules {
+			rule := r
+			if err := ruleengine.ValidatePattern(rule.Type, rule.Match); err != nil {
+				return fmt.Errorf("seed rule %s: %w", rule.Name, err)
+			}
+			enabled := true
+			rule.Enabled = &enabled
+			created, err := createIfMissing(tx, &rule, &models.Rule{}, "lower(name) = lower(?)", rule.Name)
+			if err != nil {
+				return fmt.Errorf("seed rule %s: %w", rule.Name, err)
+			}
+			res.count(created)
+		}
+
+		if opts.BindingUserID != "" {
+			binding := models.UserDeviceBinding{
+				KeycloakUserID: opts.BindingUserID,
+				DeviceID:       firstDeviceID,
+				Status:         models.BindingStatusActive,
+				BoundAt:        now,
+			}
+			created, err := createIfMissing(tx, &binding, &models.UserDeviceBinding{},
+				"keycloak_user_id = ? AND device_id = ?", binding.KeycloakUserID, binding.DeviceID)
+			if err != nil {
+				return fmt.Errorf("seed binding: %w", err)
+			}
+			res.count(created)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// createIfMissing 不存在符合条件的记录 (包括已软删除的) 时创建 record
+// 已存在时把现有记录读入 record，以便后续种子数据引用其 ID
+func createIfMissing(tx *gorm.DB, record interface{}, model interface{}, query string, args ...interface{}) (bool, error) {
+	var count int64
+	if err := tx.Unscoped().Model(model).Where(query, args...).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, tx.Unscoped().Where(query, args...).First(record).Error
+	}
+	return true, tx.Create(record).Error
+}
+
+func (r *Result) count(created bool) {
+	if created {
+		r.Created++
+	} else {
+		r.Skipped++
+	}
+}
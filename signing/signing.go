@@ -0,0 +1,71 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"go-agent-manager/config"
+)
+
+// Algorithm 签名算法名称，随签名一起返回给 Agent
+const Algorithm = "ed25519"
+
+var privateKey ed25519.PrivateKey
+
+// Init 从 RULE_SIGNING_KEY 加载 Ed25519 私钥
+// 支持 base64 编码的 32 字节种子或 64 字节完整私钥；未配置时签名功能关闭
+func Init() error {
+	raw := strings.TrimSpace(config.AppConfig.RuleSigningKey)
+	if raw == "" {
+		privateKey = nil
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return fmt.Errorf("RULE_SIGNING_KEY is not valid base64: %w", err)
+	}
+	switch len(decoded) {
+	case ed25519.SeedSize:
+		privateKey = ed25519.NewKeyFromSeed(decoded)
+	case ed25519.PrivateKeySize:
+		privateKey = ed25519.PrivateKey(decoded)
+	default:
+		return fmt.Errorf("RULE_SIGNING_KEY must decode to %d or %d bytes, got %d",
+			ed25519.SeedSize, ed25519.PrivateKeySize, len(decoded))
+	}
+	return nil
+}
+
+// Enabled 是否配置了签名私钥
+func Enabled() bool {
+	return privateKey != nil
+}
+
+// Sign 对载荷签名并返回 base64 编码的签名，未启用时返回空字符串
+func Sign(payload []byte) string {
+	if privateKey == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, payload))
+}
+
+// PublicKey 返回 base64 编码的公钥，未启用时返回空字符串
+func PublicKey() string {
+	if privateKey == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+}
+
+// KeyID 公钥指纹 (SHA-256 前 8 字节)，便于 Agent 识别密钥轮换
+func KeyID() string {
+	if privateKey == nil {
+		return ""
+	}
+	sum := sha256.Sum256(privateKey.Public().(ed25519.PublicKey))
+	return hex.EncodeToString(sum[:8])
+}